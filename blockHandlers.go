@@ -1,5 +1,133 @@
 package highmc
 
+import "math/rand"
+
+// BlockUpdateHandler handles scheduled or random block updates.
+// Read blocks with rd, and write changes with wr: writes are committed after every updates of the tick are handled.
+type BlockUpdateHandler func(pos BlockPos, block Block, rd LevelReader, wr LevelWriter)
+
+// ScheduledUpdateHandlers are block update handlers for Level.ScheduleUpdate, keyed by block ID.
+var ScheduledUpdateHandlers = map[byte]BlockUpdateHandler{}
+
+// RandomTickHandlers are block update handlers for random ticks, keyed by block ID.
+var RandomTickHandlers = map[byte]BlockUpdateHandler{
+	byte(WheatBlock):    growCrop,
+	byte(CarrotBlock):   growCrop,
+	byte(PotatoBlock):   growCrop,
+	byte(BeetrootBlock): growCrop,
+	byte(Sapling):       growSapling,
+}
+
+// Plant growth constants
+const (
+	CropMaxStage        = 7 // Max meta value of crop blocks
+	MinGrowLight        = 9 // Minimum light level for plants to grow
+	FarmlandWaterRadius = 4
+)
+
+// growCrop advances crop stage on random tick.
+// Crops on hydrated farmland(or with water nearby) grows faster than on dry farmland.
+func growCrop(pos BlockPos, block Block, rd LevelReader, wr LevelWriter) {
+	if block.Meta >= CropMaxStage || pos.Y == 0 || pos.Y >= WorldHeight-1 {
+		return
+	}
+	if rd.GetLight(BlockPos{X: pos.X, Y: pos.Y + 1, Z: pos.Z}) < MinGrowLight {
+		return
+	}
+	below := BlockPos{X: pos.X, Y: pos.Y - 1, Z: pos.Z}
+	if rd.GetID(below) != byte(Farmland) {
+		return
+	}
+	f := 1 // Growth chance is 1/(25/f+1)
+	if rd.GetMeta(below) > 0 || waterNearby(below, rd) {
+		f = 3
+	}
+	chance := 25/f + 1
+	if rand.Intn(chance) != 0 {
+		return
+	}
+	wr.SetMeta(pos, block.Meta+1)
+}
+
+// waterNearby returns whether water exists near the farmland.
+func waterNearby(pos BlockPos, rd LevelReader) bool {
+	for dy := byte(0); dy <= 1; dy++ {
+		for dx := int32(-FarmlandWaterRadius); dx <= FarmlandWaterRadius; dx++ {
+			for dz := int32(-FarmlandWaterRadius); dz <= FarmlandWaterRadius; dz++ {
+				p := BlockPos{X: pos.X + dx, Y: pos.Y + dy, Z: pos.Z + dz}
+				if !rd.Available(p) {
+					continue
+				}
+				if id := rd.GetID(p); id == byte(Water) || id == byte(StillWater) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// growSapling grows sapling into tree.
+// Sapling meta 0x8 bit is a growth stage: sapling turns into tree on next growth.
+func growSapling(pos BlockPos, block Block, rd LevelReader, wr LevelWriter) {
+	if pos.Y >= WorldHeight-1 || rd.GetLight(BlockPos{X: pos.X, Y: pos.Y + 1, Z: pos.Z}) < MinGrowLight {
+		return
+	}
+	if rand.Intn(7) != 0 {
+		return
+	}
+	if block.Meta&0x8 == 0 {
+		wr.SetMeta(pos, block.Meta|0x8)
+		return
+	}
+	growTree(pos, block.Meta&0x7, rd, wr)
+}
+
+// growTree places a small tree on given position, if there is enough space.
+// Tree type follows sapling meta: 0 oak, 1 spruce, 2 birch, 3 jungle, 4 acacia, 5 dark oak.
+func growTree(pos BlockPos, typ byte, rd LevelReader, wr LevelWriter) {
+	height := 4 + rand.Intn(3)
+	if int(pos.Y)+height+1 >= WorldHeight {
+		return
+	}
+	trunk, leaves, meta := Block{ID: byte(Log)}, Block{ID: byte(Leaves)}, typ
+	if typ >= 4 {
+		trunk.ID, leaves.ID, meta = byte(Wood2), byte(Leaves2), typ-4
+	}
+	trunk.Meta, leaves.Meta = meta, meta
+
+	for y := 1; y <= height; y++ {
+		p := BlockPos{X: pos.X, Y: pos.Y + byte(y), Z: pos.Z}
+		if !rd.Available(p) {
+			return
+		}
+		if id := rd.GetID(p); id != byte(Air) && id != leaves.ID {
+			return
+		}
+	}
+
+	for ly := height - 3; ly <= height; ly++ {
+		r := int32(2)
+		if ly >= height-1 {
+			r = 1
+		}
+		for dx := -r; dx <= r; dx++ {
+			for dz := -r; dz <= r; dz++ {
+				if (dx == r || dx == -r) && (dz == r || dz == -r) && (ly == height || rand.Intn(2) == 0) {
+					continue // Trim corners
+				}
+				p := BlockPos{X: pos.X + dx, Y: pos.Y + byte(ly), Z: pos.Z + dz}
+				if rd.Available(p) && rd.GetID(p) == byte(Air) {
+					wr.Set(p, leaves)
+				}
+			}
+		}
+	}
+	for y := 0; y < height; y++ {
+		wr.Set(BlockPos{X: pos.X, Y: pos.Y + byte(y), Z: pos.Z}, trunk)
+	}
+}
+
 // FIXME
 /*
 type blockUpdateHandler func(int32, int32, int32, Block, *Level) []BlockRecord
@@ -0,0 +1,66 @@
+package highmc
+
+import "testing"
+
+// randomTicks runs random tick handler of the block on pos until done returns true, up to n times.
+func randomTicks(lv *Level, pos BlockPos, n int, done func() bool) {
+	for i := 0; i < n && !done(); i++ {
+		block := lv.Get(pos)
+		RandomTickHandlers[block.ID](pos, block, lv, lv)
+	}
+}
+
+func TestGrowCrop(t *testing.T) {
+	lv := testLevel()
+	crop := BlockPos{X: 3, Y: 11, Z: 3}
+	lv.Set(BlockPos{X: 3, Y: 10, Z: 3}, Block{ID: byte(Farmland), Meta: 7})
+	lv.Set(crop, Block{ID: byte(WheatBlock)})
+	stage := byte(0)
+	randomTicks(lv, crop, 10000, func() bool {
+		meta := lv.GetMeta(crop)
+		if meta < stage || meta > stage+1 {
+			t.Fatal("Crop stage changed from", stage, "to", meta)
+		}
+		stage = meta
+		return stage == CropMaxStage
+	})
+	if stage != CropMaxStage {
+		t.Fatal("Crop is not fully grown:", stage)
+	}
+	randomTicks(lv, crop, 100, func() bool { return false })
+	if lv.GetMeta(crop) != CropMaxStage {
+		t.Fatal("Crop grew over max stage")
+	}
+
+	dark := BlockPos{X: 5, Y: 11, Z: 5}
+	lv.Set(BlockPos{X: 5, Y: 10, Z: 5}, Block{ID: byte(Farmland), Meta: 7})
+	lv.Set(dark, Block{ID: byte(WheatBlock)})
+	lv.LoadedChunks[ChunkPos{}].SetBlockSkyLight(5, 12, 5, 0)
+	randomTicks(lv, dark, 1000, func() bool { return false })
+	if lv.GetMeta(dark) != 0 {
+		t.Fatal("Crop grew without light")
+	}
+}
+
+func TestGrowSapling(t *testing.T) {
+	lv := testLevel()
+	pos := BlockPos{X: 8, Y: 20, Z: 8}
+	lv.Set(pos, Block{ID: byte(Sapling)})
+	randomTicks(lv, pos, 10000, func() bool { return lv.GetID(pos) != byte(Sapling) })
+	if lv.GetID(pos) != byte(Log) || lv.GetID(BlockPos{X: 8, Y: 23, Z: 8}) != byte(Log) {
+		t.Fatal("Sapling is not replaced with logs:", lv.Get(pos))
+	}
+	leaves := 0
+	for dx := int32(-2); dx <= 2; dx++ {
+		for dz := int32(-2); dz <= 2; dz++ {
+			for y := pos.Y + 3; y <= pos.Y+6; y++ {
+				if lv.GetID(BlockPos{X: pos.X + dx, Y: y, Z: pos.Z + dz}) == byte(Leaves) {
+					leaves++
+				}
+			}
+		}
+	}
+	if leaves == 0 {
+		t.Fatal("Tree has no leaves")
+	}
+}
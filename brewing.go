@@ -0,0 +1,205 @@
+package highmc
+
+// Brewing stand slots and timings
+const (
+	BrewingIngredientSlot = 0 // Slot 1~3 are potion slots
+	BrewingFuelSlot       = 4
+	BrewingStandSize      = 5
+
+	BrewTicks          = 400 // Ticks to brew potions once
+	BlazePowderBrewing = 20  // Brew count per blaze powder
+)
+
+// ContainerSetData properties for brewing stands
+const (
+	BrewingPropertyTime uint16 = 0
+	BrewingPropertyFuel uint16 = 1
+)
+
+// Potion metas
+const (
+	PotionWater          uint16 = 0
+	PotionMundane        uint16 = 1
+	PotionLongMundane    uint16 = 2
+	PotionThick          uint16 = 3
+	PotionAwkward        uint16 = 4
+	PotionNightVision    uint16 = 5
+	PotionLeaping        uint16 = 9
+	PotionFireResistance uint16 = 12
+	PotionSwiftness      uint16 = 14
+	PotionWaterBreathing uint16 = 19
+	PotionHealing        uint16 = 21
+	PotionPoison         uint16 = 25
+	PotionRegeneration   uint16 = 28
+	PotionStrength       uint16 = 31
+	PotionWeakness       uint16 = 34
+	PotionInvalid        uint16 = 0xffff
+)
+
+// BrewingRecipes maps brewing ingredients to potion transforms, from input potion meta to output potion meta.
+var BrewingRecipes = map[ID]map[uint16]uint16{
+	NetherWart: {
+		PotionWater: PotionAwkward,
+	},
+	GlowstoneDust: {
+		PotionWater: PotionThick,
+	},
+	Redstone: {
+		PotionWater: PotionLongMundane,
+	},
+	FermentedSpiderEye: {
+		PotionWater: PotionWeakness,
+	},
+	GoldenCarrot: {
+		PotionAwkward: PotionNightVision,
+	},
+	MagmaCream: {
+		PotionAwkward: PotionFireResistance,
+	},
+	Sugar: {
+		PotionWater:   PotionMundane,
+		PotionAwkward: PotionSwiftness,
+	},
+	GlisteringMelon: {
+		PotionWater:   PotionMundane,
+		PotionAwkward: PotionHealing,
+	},
+	SpiderEye: {
+		PotionWater:   PotionMundane,
+		PotionAwkward: PotionPoison,
+	},
+	GhastTear: {
+		PotionWater:   PotionMundane,
+		PotionAwkward: PotionRegeneration,
+	},
+	BlazePowder: {
+		PotionWater:   PotionMundane,
+		PotionAwkward: PotionStrength,
+	},
+}
+
+// BrewingStandTile is a tile entity for brewing stand blocks.
+type BrewingStandTile struct {
+	Pos       BlockPos
+	Inventory Inventory
+	BrewTime  int // Remaining ticks to finish current brewing, 0 if not brewing
+	Fuel      int // Remaining brew count
+
+	viewers map[*player]byte // Player: window ID
+}
+
+// NewBrewingStandTile returns new brewing stand tile entity on given position.
+func NewBrewingStandTile(pos BlockPos) *BrewingStandTile {
+	return &BrewingStandTile{
+		Pos:       pos,
+		Inventory: make(Inventory, BrewingStandSize),
+		viewers:   make(map[*player]byte),
+	}
+}
+
+// AddViewer makes the brewing stand send its progress to the player, on given window.
+func (b *BrewingStandTile) AddViewer(p *player, windowID byte) {
+	b.viewers[p] = windowID
+}
+
+// RemoveViewer stops sending progress to the player.
+func (b *BrewingStandTile) RemoveViewer(p *player) {
+	delete(b.viewers, p)
+}
+
+// result returns brewed potion meta for given potion slot, or PotionInvalid if it can't be brewed.
+func (b *BrewingStandTile) result(slot int) uint16 {
+	potion := b.Inventory[slot]
+	if potion.ID != Potion {
+		return PotionInvalid
+	}
+	recipe, ok := BrewingRecipes[b.Inventory[BrewingIngredientSlot].ID]
+	if !ok {
+		return PotionInvalid
+	}
+	if meta, ok := recipe[potion.Meta]; ok {
+		return meta
+	}
+	return PotionInvalid
+}
+
+func (b *BrewingStandTile) canBrew() bool {
+	for slot := 1; slot <= 3; slot++ {
+		if b.result(slot) != PotionInvalid {
+			return true
+		}
+	}
+	return false
+}
+
+// Tick implements TileEntity interface.
+func (b *BrewingStandTile) Tick(lv *Level) {
+	if !b.canBrew() {
+		if b.BrewTime > 0 { // Ingredient or potions taken out
+			b.BrewTime = 0
+			b.sendData(lv, BrewingPropertyTime, 0)
+		}
+		return
+	}
+	if b.BrewTime == 0 { // Start brewing
+		if b.Fuel == 0 {
+			fuel := &b.Inventory[BrewingFuelSlot]
+			if fuel.ID != BlazePowder || fuel.Amount == 0 {
+				return
+			}
+			if fuel.Amount--; fuel.Amount == 0 {
+				*fuel = Item{}
+			}
+			b.Fuel = BlazePowderBrewing
+		}
+		b.Fuel--
+		b.BrewTime = BrewTicks
+		b.sendData(lv, BrewingPropertyFuel, uint16(b.Fuel))
+	}
+	b.BrewTime--
+	if b.BrewTime == 0 {
+		b.brew()
+		b.sendContents(lv)
+	}
+	b.sendData(lv, BrewingPropertyTime, uint16(b.BrewTime))
+}
+
+// brew transforms potions and consumes an ingredient.
+func (b *BrewingStandTile) brew() {
+	for slot := 1; slot <= 3; slot++ {
+		if meta := b.result(slot); meta != PotionInvalid {
+			b.Inventory[slot].Meta = meta
+		}
+	}
+	ingredient := &b.Inventory[BrewingIngredientSlot]
+	if ingredient.Amount--; ingredient.Amount == 0 {
+		*ingredient = Item{}
+	}
+}
+
+func (b *BrewingStandTile) sendData(lv *Level, property, value uint16) {
+	if lv.Server == nil {
+		return
+	}
+	for p, windowID := range b.viewers {
+		lv.Server.sendPacket(p, &ContainerSetData{
+			WindowID: windowID,
+			Property: property,
+			Value:    value,
+		})
+	}
+}
+
+func (b *BrewingStandTile) sendContents(lv *Level) {
+	if lv.Server == nil {
+		return
+	}
+	for p, windowID := range b.viewers {
+		slots := make([]Item, len(b.Inventory))
+		copy(slots, b.Inventory)
+		lv.Server.sendPacket(p, &ContainerSetContent{
+			WindowID: windowID,
+			Slots:    slots,
+		})
+	}
+}
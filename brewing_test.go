@@ -0,0 +1,55 @@
+package highmc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBrewing(t *testing.T) {
+	lv := testLevel()
+	lv.Server = NewServer()
+	lv.RandomTickSpeed = 0
+	b := NewBrewingStandTile(BlockPos{X: 1, Y: 2, Z: 3})
+	lv.TileEntities[b.Pos] = b
+	b.Inventory[BrewingIngredientSlot] = Item{ID: NetherWart, Amount: 2}
+	b.Inventory[1] = Item{ID: Potion, Meta: PotionWater, Amount: 1}
+	b.Inventory[3] = Item{ID: Potion, Meta: PotionWater, Amount: 1}
+	b.Inventory[BrewingFuelSlot] = Item{ID: BlazePowder, Amount: 1}
+	viewer := NewPlayer(&session{Address: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}})
+	viewer.SendRequest = make(chan MCPEPacket, BrewTicks+4)
+	b.AddViewer(viewer, MinContainerWindow)
+
+	lv.Tick()
+	if b.BrewTime != BrewTicks-1 || b.Fuel != BlazePowderBrewing-1 || b.Inventory[BrewingFuelSlot].ID != 0 {
+		t.Fatal("Brewing is not started with fuel:", b.BrewTime, b.Fuel)
+	}
+	for i := 1; i < BrewTicks; i++ {
+		lv.Tick()
+	}
+	if b.Inventory[1].Meta != PotionAwkward || b.Inventory[3].Meta != PotionAwkward || b.Inventory[2].ID != 0 {
+		t.Fatal("Water bottles are not brewed:", b.Inventory)
+	}
+	if b.Inventory[BrewingIngredientSlot].Amount != 1 || b.BrewTime != 0 {
+		t.Fatal("Ingredient is not consumed")
+	}
+	lv.Tick()
+	if b.BrewTime != 0 {
+		t.Fatal("Awkward potions are brewed again with nether wart")
+	}
+
+	var progress []uint16
+	contents := 0
+	for len(viewer.SendRequest) > 0 {
+		switch pk := (<-viewer.SendRequest).(type) {
+		case *ContainerSetData:
+			if pk.WindowID == MinContainerWindow && pk.Property == BrewingPropertyTime {
+				progress = append(progress, pk.Value)
+			}
+		case *ContainerSetContent:
+			contents++
+		}
+	}
+	if len(progress) != BrewTicks || progress[0] != BrewTicks-1 || progress[len(progress)-1] != 0 || contents != 1 {
+		t.Fatal("Brewing progress is not reported:", len(progress), contents)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"log"
 	"math"
 	"net"
 )
@@ -70,8 +71,16 @@ func ReadAny(rd io.Reader, p interface{}) {
 		*p.(*net.UDPAddr) = *addr
 	case **net.UDPAddr:
 		*p.(**net.UDPAddr) = ReadAddress(rd)
+	case *Vector3:
+		v := p.(*Vector3)
+		v.X, v.Y, v.Z = ReadFloat(rd), ReadFloat(rd), ReadFloat(rd)
+	case *BlockPos: // Same order with RemoveBlock: X, Z, Y
+		pos := p.(*BlockPos)
+		pos.X, pos.Z = int32(ReadInt(rd)), int32(ReadInt(rd))
+		pos.Y = ReadByte(rd)
 	case byte, uint16, uint32,
-		uint64, float32, float64, string, net.UDPAddr:
+		uint64, float32, float64, string, net.UDPAddr,
+		Vector3, BlockPos:
 		panic("ReadAny requires reference type")
 	default:
 		panic("Unsupported type for ReadAny")
@@ -139,6 +148,32 @@ func ReadLInt(rd io.Reader) uint32 {
 	return uint32(b[3])<<24 | uint32(b[2])<<16 | uint32(b[1])<<8 | uint32(b[0])
 }
 
+// ReadCount reads unsigned int element count from buffer, and panics with Overflow
+// if the buffer is too short to have that many elements of given minimum size.
+// Use this before allocating slices with counts from network, to avoid huge allocations.
+func ReadCount(buf *bytes.Buffer, elemSize int) uint32 {
+	n := ReadInt(buf)
+	if uint64(n)*uint64(elemSize) > uint64(buf.Len()) {
+		panic(Overflow{
+			Need: int(uint64(n) * uint64(elemSize)),
+			Got:  buf.Len(),
+		})
+	}
+	return n
+}
+
+// ReadShortCount is same as ReadCount, but reads unsigned short element count.
+func ReadShortCount(buf *bytes.Buffer, elemSize int) uint16 {
+	n := ReadShort(buf)
+	if int(n)*elemSize > buf.Len() {
+		panic(Overflow{
+			Need: int(n) * elemSize,
+			Got:  buf.Len(),
+		})
+	}
+	return n
+}
+
 // ReadLong reads unsigned long from buffer.
 func ReadLong(rd io.Reader) uint64 {
 	b, err := Read(rd, 8)
@@ -202,21 +237,43 @@ func ReadString(rd io.Reader) (str string) {
 	return string(b)
 }
 
+// ParseBytes reads uint16 length-prefixed byte slice from buffer.
+// Unlike ReadString, it returns error instead of panicking if the data is truncated.
+func ParseBytes(rd io.Reader) ([]byte, error) {
+	l, err := Read(rd, 2)
+	if err != nil {
+		return nil, err
+	}
+	return Read(rd, int(l[0])<<8|int(l[1]))
+}
+
 // ReadAddress reads IP address/port from buffer.
 func ReadAddress(rd io.Reader) (addr *net.UDPAddr) {
-	v := ReadByte(rd)
-	if v != 4 {
-		panic(fmt.Sprintf("ReadAddress got unsupported IP version %d", v))
-	}
-	b, err := Read(rd, 4)
+	addr, err := ParseAddress(rd)
 	if err != nil {
 		panic(err)
 	}
-	p := ReadShort(rd)
+	return
+}
+
+// ParseAddress reads IP address/port from buffer.
+// Unlike ReadAddress, it returns error on malformed or truncated data instead of panicking.
+func ParseAddress(rd io.Reader) (*net.UDPAddr, error) {
+	v, err := Read(rd, 1)
+	if err != nil {
+		return nil, err
+	}
+	if v[0] != 4 {
+		return nil, fmt.Errorf("ReadAddress got unsupported IP version %d", v[0])
+	}
+	b, err := Read(rd, 6)
+	if err != nil {
+		return nil, err
+	}
 	return &net.UDPAddr{
 		IP:   append([]byte{b[0] ^ 0xff}, b[1]^0xff, b[2]^0xff, b[3]^0xff),
-		Port: int(p),
-	}
+		Port: int(b[4])<<8 | int(b[5]),
+	}, nil
 }
 
 // Write writes given byte array to buffer.
@@ -272,6 +329,16 @@ func WriteAny(wr io.Writer, p interface{}) {
 		Write(wr, *p.(*[]byte))
 	case *net.UDPAddr:
 		WriteAddress(wr, p.(*net.UDPAddr))
+	case Vector3:
+		v := p.(Vector3)
+		BatchWrite(wr, v.X, v.Y, v.Z)
+	case *Vector3:
+		WriteAny(wr, *p.(*Vector3))
+	case BlockPos: // Same order with RemoveBlock: X, Z, Y
+		pos := p.(BlockPos)
+		BatchWrite(wr, uint32(pos.X), uint32(pos.Z), pos.Y)
+	case *BlockPos:
+		WriteAny(wr, *p.(*BlockPos))
 	}
 }
 
@@ -345,7 +412,7 @@ func WriteLLong(wr io.Writer, n uint64) {
 		byte(n), byte(n >> 8),
 		byte(n >> 16), byte(n >> 24),
 		byte(n >> 32), byte(n >> 40),
-		byte(n >> 48), byte(56),
+		byte(n >> 48), byte(n >> 56),
 	}); err != nil {
 		panic(err)
 	}
@@ -384,6 +451,17 @@ func WriteString(wr io.Writer, s string) {
 	Write(wr, []byte(s))
 }
 
+// WriteBytes writes uint16 length-prefixed byte slice to buffer.
+func WriteBytes(wr io.Writer, b []byte) {
+	if len(b) > 65535 {
+		panic(StringOverflow{
+			Length: len(b),
+		})
+	}
+	WriteShort(wr, uint16(len(b)))
+	Write(wr, b)
+}
+
 // WriteAddress writes net.UDPAddr address to buffer.
 func WriteAddress(wr io.Writer, i *net.UDPAddr) {
 	WriteByte(wr, 4)
@@ -393,7 +471,11 @@ func WriteAddress(wr io.Writer, i *net.UDPAddr) {
 	WriteShort(wr, uint16(i.Port))
 }
 
-// Dump prints hexdump for given
+// Dump writes hexdump for given buffer to standard logger.
+// It does nothing unless Debug is true.
 func Dump(buf *bytes.Buffer) {
-	fmt.Print(hex.Dump(buf.Bytes()))
+	if !Debug {
+		return
+	}
+	log.Print("Packet dump:\n" + hex.Dump(buf.Bytes()))
 }
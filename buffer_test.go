@@ -0,0 +1,66 @@
+package highmc
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	var captured bytes.Buffer
+	log.SetOutput(&captured)
+	defer log.SetOutput(os.Stderr)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	Dump(bytes.NewBuffer([]byte{0xde, 0xad}))
+	if captured.Len() != 0 {
+		t.Fatal("Dump writes outputs without Debug")
+	}
+	Debug = true
+	Dump(bytes.NewBuffer([]byte{0xde, 0xad}))
+	Debug = false
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if !strings.Contains(captured.String(), "de ad") || len(out) != 0 {
+		t.Fatal("Dump is not written to the logger only:", captured.String(), out)
+	}
+}
+
+func TestLLong(t *testing.T) {
+	for _, n := range []uint64{0, 1, 0xff, 0x0102030405060708, 0x00ffffffffffffff, 0xff00000000000000, math.MaxUint64} {
+		buf := new(bytes.Buffer)
+		WriteLLong(buf, n)
+		if b := buf.Bytes(); len(b) != 8 || b[7] != byte(n>>56) {
+			t.Fatalf("Unexpected encoding of %x: % x", n, b)
+		}
+		if got := ReadLLong(buf); got != n {
+			t.Fatalf("Round trip of %x returned %x", n, got)
+		}
+	}
+}
+
+func TestBatchPositions(t *testing.T) {
+	v, pos := Vector3{X: 1.5, Y: -2, Z: 3.25}, BlockPos{X: -5, Y: 100, Z: 7}
+	buf := new(bytes.Buffer)
+	BatchWrite(buf, v, &v, pos, &pos, byte(9))
+	if buf.Len() != 12*2+9*2+1 {
+		t.Fatal("Unexpected length:", buf.Len())
+	}
+	var v1, v2 Vector3
+	var pos1, pos2 BlockPos
+	var tail byte
+	BatchRead(buf, &v1, &v2, &pos1, &pos2, &tail)
+	if v1 != v || v2 != v || pos1 != pos || pos2 != pos || tail != 9 {
+		t.Fatal("Positions are not preserved:", v1, v2, pos1, pos2, tail)
+	}
+}
@@ -0,0 +1,35 @@
+package highmc
+
+// ChestSize is a count of chest slots.
+const ChestSize = 27
+
+// ChestTile is a tile entity for chest blocks.
+type ChestTile struct {
+	Pos       BlockPos
+	Inventory Inventory
+}
+
+// NewChestTile returns new chest tile entity on given position.
+func NewChestTile(pos BlockPos) *ChestTile {
+	return &ChestTile{
+		Pos:       pos,
+		Inventory: make(Inventory, ChestSize),
+	}
+}
+
+// Tick implements TileEntity interface. Chests have nothing to do on ticks.
+func (c *ChestTile) Tick(lv *Level) {}
+
+// chestAt returns chest tile entity on given position, creating one if the block is a chest without tile entity.
+// It returns nil if the block is not a chest. Level should be write-locked.
+func chestAt(lv *Level, pos BlockPos) *ChestTile {
+	if !lv.Available(pos) || lv.GetID(pos) != byte(Chest) {
+		return nil
+	}
+	if chest, ok := lv.TileEntities[pos].(*ChestTile); ok {
+		return chest
+	}
+	chest := NewChestTile(pos)
+	lv.TileEntities[pos] = chest
+	return chest
+}
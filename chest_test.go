@@ -0,0 +1,125 @@
+package highmc
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+// chestViewer returns a player on the level, who opened the chest on pos.
+func chestViewer(t *testing.T, lv *Level, pos BlockPos, gamemode uint32, port int) *player {
+	p := NewPlayer(&session{
+		EncapsulatedChan: make(chan *EncapsulatedPacket, 256),
+		Server:           NewServer(),
+		Address:          &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+	})
+	p.inventory.Holder = p
+	p.Gamemode = gamemode
+	inv := make(Inventory, PlayerInventorySize)
+	p.inventory.Inventory = &inv
+	p.Level = lv
+	UseItem{X: uint32(pos.X), Y: uint32(pos.Y), Z: uint32(pos.Z), Face: SideUp, Item: &Item{}}.Handle(p)
+	if len(p.openContainers) != 1 {
+		t.Fatal("Chest is not opened")
+	}
+	return p
+}
+
+func TestChestSlots(t *testing.T) {
+	lv := testLevel()
+	pos := BlockPos{X: 3, Y: 4, Z: 5}
+	lv.Set(pos, Block{ID: byte(Chest)})
+	p := chestViewer(t, lv, pos, GamemodeSurvival, 1)
+	chest, ok := lv.TileEntities[pos].(*ChestTile)
+	if !ok || p.openContainers[MinContainerWindow] != &chest.Inventory {
+		t.Fatal("Chest inventory is not opened on first container window")
+	}
+	chest.Inventory[0] = Item{ID: Stone, Amount: 3}
+	ContainerSetSlot{Windowid: InventoryWindow, Slot: 1, Item: &Item{ID: Stone, Amount: 3}}.Handle(p)
+	ContainerSetSlot{Windowid: MinContainerWindow, Slot: 0, Item: &Item{}}.Handle(p)
+	if (*p.inventory.Inventory)[1].Amount != 3 || chest.Inventory[0].ID != 0 || p.inventory.transaction != nil {
+		t.Fatal("Items are not moved from chest to inventory")
+	}
+
+	if id, err := p.OpenContainer(&chest.Inventory, ContainerChest, pos); err != nil || id != MinContainerWindow || len(p.openContainers) != 1 {
+		t.Fatal("Window ID is not reused for the same chest:", id, err)
+	}
+
+	ContainerClose{WindowID: MinContainerWindow}.Handle(p)
+	ContainerSetSlot{Windowid: MinContainerWindow, Slot: 0, Item: &Item{ID: Stone, Amount: 3}}.Handle(p)
+	ContainerSetSlot{Windowid: InventoryWindow, Slot: 1, Item: &Item{}}.Handle(p)
+	if chest.Inventory[0].ID != 0 {
+		t.Fatal("Closed chest is modified")
+	}
+}
+
+func TestChestCreativeItems(t *testing.T) {
+	lv := testLevel()
+	pos := BlockPos{X: 3, Y: 4, Z: 5}
+	lv.Set(pos, Block{ID: byte(Chest)})
+	p := chestViewer(t, lv, pos, GamemodeCreative, 1)
+	chest := lv.TileEntities[pos].(*ChestTile)
+	ContainerSetSlot{Windowid: MinContainerWindow, Slot: 0, Item: &Item{ID: Stone, Amount: 64}}.Handle(p)
+	if chest.Inventory[0].ID != Stone {
+		t.Fatal("Creative item is not put into chest")
+	}
+	ContainerSetSlot{Windowid: MinContainerWindow, Slot: 1, Item: &Item{ID: 0xffff, Amount: 64}}.Handle(p)
+	if chest.Inventory[1].ID != 0 {
+		t.Fatal("Non-creative item is put into chest in creative mode")
+	}
+}
+
+func TestChestSharedViewers(t *testing.T) {
+	lv := testLevel()
+	pos := BlockPos{X: 3, Y: 4, Z: 5}
+	lv.Set(pos, Block{ID: byte(Chest)})
+	viewers := []*player{
+		chestViewer(t, lv, pos, GamemodeCreative, 1),
+		chestViewer(t, lv, pos, GamemodeCreative, 2),
+	}
+	var wg sync.WaitGroup
+	for _, p := range viewers {
+		wg.Add(1)
+		go func(p *player) { // Run with -race to check container slots are locked
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				ContainerSetSlot{Windowid: MinContainerWindow, Slot: uint16(n % ChestSize), Item: &Item{ID: Stone, Amount: byte(n%64 + 1)}}.Handle(p)
+				for len(p.EncapsulatedChan) > 0 {
+					<-p.EncapsulatedChan
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+func TestOpenContainer(t *testing.T) {
+	p := NewPlayer(&session{EncapsulatedChan: make(chan *EncapsulatedPacket, 256), Server: NewServer()})
+	first, second := make(Inventory, ChestSize), make(Inventory, ChestSize)
+	pos := BlockPos{X: 3, Y: 4, Z: 5}
+	if id, err := p.OpenContainer(&first, ContainerChest, pos); err != nil || id != MinContainerWindow {
+		t.Fatal("Unexpected window ID of the first container:", id, err)
+	}
+	if id, err := p.OpenContainer(&second, ContainerChest, pos); err != nil || id != MinContainerWindow+1 {
+		t.Fatal("Unexpected window ID of the second container:", id, err)
+	}
+	pks := sentPackets(t, p)
+	if len(pks) != 4 {
+		t.Fatal("Unexpected packets on open:", pks)
+	}
+	if open, ok := pks[2].(*ContainerOpen); !ok || open.WindowID != MinContainerWindow+1 || open.Slots != ChestSize || open.X != 3 || open.Z != 5 {
+		t.Fatal("Unexpected ContainerOpen:", pks[2])
+	}
+
+	ContainerClose{WindowID: MinContainerWindow}.Handle(p)
+	if _, ok := p.openContainers[MinContainerWindow]; ok || len(p.openContainers) != 1 {
+		t.Fatal("Closed container is still open")
+	}
+	ContainerSetSlot{Windowid: MinContainerWindow, Slot: 0, Item: &Item{ID: Stone, Amount: 1}}.Handle(p)
+	if first[0].ID != 0 {
+		t.Fatal("Closed container is modified")
+	}
+	if id, _ := p.OpenContainer(&first, ContainerChest, pos); id != MinContainerWindow {
+		t.Fatal("Window ID of closed container is not reused:", id)
+	}
+}
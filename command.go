@@ -0,0 +1,309 @@
+package highmc
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Command is a chat command which players can execute with '/' prefix.
+type Command struct {
+	Name        string
+	Description string
+	Usage       string
+	OpLevel     int // Minimum op level required to execute
+	Execute     func(sender *player, args []string) error
+}
+
+var commands = map[string]Command{
+	"op": {
+		Name:        "op",
+		Description: "Gives operator status to the player",
+		Usage:       "/op <player> [level]",
+		OpLevel:     OpLevelAdmin,
+		Execute: func(sender *player, args []string) error {
+			if len(args) < 1 {
+				return fmt.Errorf("player name is not given")
+			}
+			level := DefaultOpLevel
+			if len(args) > 1 {
+				var err error
+				if level, err = strconv.Atoi(args[1]); err != nil || level <= OpLevelNone || level > OpLevelOwner {
+					return fmt.Errorf("op level should be in 1~%d", OpLevelOwner)
+				}
+			}
+			if err := sender.Server.SetOpLevel(args[0], level); err != nil {
+				return err
+			}
+			sender.SendMessage("Opped " + args[0])
+			log.Println(sender.Username, "opped", args[0], "with level", level)
+			return nil
+		},
+	},
+	"deop": {
+		Name:        "deop",
+		Description: "Takes operator status from the player",
+		Usage:       "/deop <player>",
+		OpLevel:     OpLevelAdmin,
+		Execute: func(sender *player, args []string) error {
+			if len(args) < 1 {
+				return fmt.Errorf("player name is not given")
+			}
+			if err := sender.Server.SetOpLevel(args[0], OpLevelNone); err != nil {
+				return err
+			}
+			sender.SendMessage("De-opped " + args[0])
+			log.Println(sender.Username, "de-opped", args[0])
+			return nil
+		},
+	},
+	"tp": {
+		Name:        "tp",
+		Description: "Teleports yourself to given coordinates",
+		Usage:       "/tp <x> <y> <z>",
+		OpLevel:     OpLevelGamemaster,
+		Execute: func(sender *player, args []string) error {
+			if len(args) < 3 {
+				return fmt.Errorf("coordinates are not given")
+			}
+			var pos [3]float32
+			for i := range pos {
+				f, err := strconv.ParseFloat(args[i], 32)
+				if err != nil {
+					return fmt.Errorf("invalid coordinate %s", args[i])
+				}
+				pos[i] = float32(f)
+			}
+			sender.ForcePosition(Vector3{X: pos[0], Y: pos[1], Z: pos[2]}, sender.Yaw, sender.Pitch)
+			sender.SendMessage(fmt.Sprintf("Teleported to %.1f, %.1f, %.1f", pos[0], pos[1], pos[2]))
+			return nil
+		},
+	},
+	"tell": {
+		Name:        "tell",
+		Description: "Sends a private message to the player",
+		Usage:       "/tell <player> <message>",
+		OpLevel:     OpLevelNone,
+		Execute: func(sender *player, args []string) error {
+			if len(args) < 2 {
+				return fmt.Errorf("player name or message is not given")
+			}
+			msg := strings.Join(args[1:], " ")
+			if err := sender.Server.SendTo(args[0], &Text{
+				TextType: TextTypeRaw,
+				Message:  "[" + sender.Username + " -> me] " + msg,
+			}); err != nil {
+				return err
+			}
+			sender.SendMessage("[me -> " + args[0] + "] " + msg)
+			return nil
+		},
+	},
+	"list": {
+		Name:        "list",
+		Description: "Shows online players",
+		Usage:       "/list",
+		OpLevel:     OpLevelNone,
+		Execute: func(sender *player, args []string) error {
+			roster := sender.Server.Roster()
+			names := make([]string, len(roster))
+			for i, e := range roster {
+				names[i] = e.Username
+			}
+			sort.Strings(names)
+			sender.SendMessage(fmt.Sprintf("%d players online: %s", len(names), strings.Join(names, ", ")))
+			return nil
+		},
+	},
+	"stats": {
+		Name:        "stats",
+		Description: "Shows loaded chunks and memory usage",
+		Usage:       "/stats",
+		OpLevel:     OpLevelModerator,
+		Execute: func(sender *player, args []string) error {
+			for name, lv := range sender.Server.Levels {
+				n := 0
+				lv.EachLoadedChunk(func(ChunkPos, *Chunk) {
+					n++
+				})
+				sender.SendMessage(fmt.Sprintf("Level %s: %d chunks loaded", name, n))
+			}
+			var mem runtime.MemStats
+			runtime.ReadMemStats(&mem)
+			sender.SendMessage(fmt.Sprintf("Memory: %d MB allocated, %d goroutines", mem.Alloc/1024/1024, runtime.NumGoroutine()))
+			return nil
+		},
+	},
+	"difficulty": {
+		Name:        "difficulty",
+		Description: "Changes difficulty of current level",
+		Usage:       "/difficulty <0-3>",
+		OpLevel:     OpLevelGamemaster,
+		Execute: func(sender *player, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("difficulty is not given")
+			}
+			d, err := strconv.Atoi(args[0])
+			if err != nil || d < int(DifficultyPeaceful) || d > int(DifficultyHard) {
+				return fmt.Errorf("invalid difficulty %s", args[0])
+			}
+			lv, err := sender.GetLevel()
+			if err != nil {
+				return err
+			}
+			lv.SetDifficulty(uint32(d))
+			sender.SendMessage(fmt.Sprintf("Set difficulty of %s to %d", lv.Name, d))
+			return nil
+		},
+	},
+	"save-all": {
+		Name:        "save-all",
+		Description: "Saves every modified chunks",
+		Usage:       "/save-all",
+		OpLevel:     OpLevelAdmin,
+		Execute: func(sender *player, args []string) error {
+			for name, lv := range sender.Server.Levels {
+				if err := lv.Save(); err != nil {
+					return fmt.Errorf("saving level %s: %s", name, err)
+				}
+			}
+			sender.SendMessage("Saved all levels")
+			log.Println(sender.Username, "saved all levels")
+			return nil
+		},
+	},
+}
+
+func init() {
+	// help refers to the commands map, so it can't be in the map literal.
+	if err := RegisterCommand(Command{
+		Name:        "help",
+		Description: "Shows available commands",
+		Usage:       "/help [command]",
+		OpLevel:     OpLevelNone,
+		Execute: func(sender *player, args []string) error {
+			level := sender.Server.OpLevel(sender.Username)
+			if len(args) > 0 {
+				cmd, ok := sender.Server.Commands.Get(args[0])
+				if !ok || level < cmd.OpLevel {
+					return fmt.Errorf("unknown command %s", args[0])
+				}
+				sender.SendMessage(cmd.Usage + ": " + cmd.Description)
+				return nil
+			}
+			for _, cmd := range sender.Server.Commands.Available(level) {
+				sender.SendMessage("/" + cmd.Name + ": " + cmd.Description)
+			}
+			return nil
+		},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterCommand adds the command to servers created after the call.
+// It returns error if a command with same name already exists.
+// Call this before creating servers: command map is not goroutine-safe.
+// Use Server.Commands to add commands to a single server.
+func RegisterCommand(cmd Command) error {
+	name := strings.ToLower(cmd.Name)
+	if _, ok := commands[name]; ok {
+		return fmt.Errorf("command %s is already registered", name)
+	}
+	commands[name] = cmd
+	return nil
+}
+
+// CommandManager is a set of commands available on a server.
+// It is safe to use from multiple goroutines.
+type CommandManager struct {
+	mutex    sync.RWMutex
+	commands map[string]Command
+}
+
+// newCommandManager returns command manager with commands registered with RegisterCommand.
+func newCommandManager() *CommandManager {
+	m := &CommandManager{commands: make(map[string]Command, len(commands))}
+	for name, cmd := range commands {
+		m.commands[name] = cmd
+	}
+	return m
+}
+
+// Register adds the command executed with fn, which every player can use.
+// Existing command with same name is replaced. Use Add for commands with usage and op level.
+func (m *CommandManager) Register(name string, fn func(sender *player, args []string)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.commands[strings.ToLower(name)] = Command{
+		Name:  name,
+		Usage: "/" + name,
+		Execute: func(sender *player, args []string) error {
+			fn(sender, args)
+			return nil
+		},
+	}
+}
+
+// Add adds the command. It returns error if a command with same name already exists.
+func (m *CommandManager) Add(cmd Command) error {
+	name := strings.ToLower(cmd.Name)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.commands[name]; ok {
+		return fmt.Errorf("command %s is already registered", name)
+	}
+	m.commands[name] = cmd
+	return nil
+}
+
+// Get returns the command with given name, case-insensitively.
+func (m *CommandManager) Get(name string) (Command, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	cmd, ok := m.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// Available returns commands which can be used with given op level, sorted by name.
+func (m *CommandManager) Available(level int) []Command {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	names := make([]string, 0, len(m.commands))
+	for name, cmd := range m.commands {
+		if level >= cmd.OpLevel {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	cmds := make([]Command, len(names))
+	for i, name := range names {
+		cmds[i] = m.commands[name]
+	}
+	return cmds
+}
+
+// ExecuteCommand executes given command line(without '/' prefix) as the player.
+func (p *player) ExecuteCommand(line string) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return
+	}
+	cmd, ok := p.Server.Commands.Get(args[0])
+	if !ok {
+		p.SendMessage("Unknown command: " + args[0])
+		return
+	}
+	if p.Server.OpLevel(p.Username) < cmd.OpLevel {
+		p.SendMessage("You don't have permission to use this command")
+		return
+	}
+	if err := cmd.Execute(p, args[1:]); err != nil {
+		p.SendMessage("Error: " + err.Error())
+		p.SendMessage("Usage: " + cmd.Usage)
+	}
+}
@@ -0,0 +1,109 @@
+package highmc
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCommandManagerPerServer(t *testing.T) {
+	s1, s2 := NewServer(), NewServer()
+	var got []string
+	s1.Commands.Register("Greet", func(sender *player, args []string) {
+		got = args
+	})
+	p := testPlayer(s1, testLevel(), GamemodeSurvival)
+	p.ExecuteCommand("greet 1 2")
+	if len(got) != 2 || got[1] != "2" {
+		t.Fatal("Registered command is not executed:", got)
+	}
+	if _, ok := s2.Commands.Get("greet"); ok {
+		t.Fatal("Command registered on a server is available on other server")
+	}
+	if _, ok := s2.Commands.Get("help"); !ok {
+		t.Fatal("Commands added with RegisterCommand are not available")
+	}
+	if err := s1.Commands.Add(Command{Name: "GREET"}); err == nil {
+		t.Fatal("Duplicate command is added")
+	}
+}
+
+func TestOpPermissions(t *testing.T) {
+	OpsFile = filepath.Join(t.TempDir(), "ops.txt")
+	defer func() { OpsFile = "ops.txt" }()
+	s := NewServer()
+	s.Start()
+	defer s.Stop()
+	ran := false
+	if err := s.Commands.Add(Command{Name: "gated", OpLevel: OpLevelModerator, Execute: func(*player, []string) error {
+		ran = true
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetOpLevel("Admin", OpLevelAdmin); err != nil {
+		t.Fatal(err)
+	}
+	permission := func(p *player) uint32 {
+		for _, pk := range sentPackets(t, p) {
+			if settings, ok := pk.(*AdventureSettings); ok {
+				return settings.UserPermission
+			}
+		}
+		t.Fatal("AdventureSettings is not sent on spawn")
+		return 0
+	}
+	op, user := loginPlayer(t, s, "admin", 1), loginPlayer(t, s, "Steve", 2)
+	if permission(op) != PermissionOperator || permission(user) != PermissionNormal {
+		t.Fatal("Spawn permission flags do not follow op status")
+	}
+
+	user.ExecuteCommand("gated")
+	if ran {
+		t.Fatal("Non-op player executed gated command")
+	}
+	if msg := sentPackets(t, user)[0].(*Text).Message; msg != "You don't have permission to use this command" {
+		t.Fatal("Unexpected denial message:", msg)
+	}
+	op.ExecuteCommand("gated")
+	if !ran {
+		t.Fatal("Op player can't execute gated command")
+	}
+}
+
+func TestChatCommands(t *testing.T) {
+	OpsFile = filepath.Join(t.TempDir(), "ops.txt")
+	defer func() { OpsFile = "ops.txt" }()
+	s := NewServer()
+	s.JoinMessage = ""
+	s.Start()
+	defer s.Stop()
+	var got []string
+	s.Commands.Register("echo", func(sender *player, args []string) {
+		got = args
+	})
+	if err := s.SetOpLevel("Steve", OpLevelAdmin); err != nil {
+		t.Fatal(err)
+	}
+	p, other := loginPlayer(t, s, "Steve", 1), loginPlayer(t, s, "Alex", 2)
+	sentPackets(t, p)
+	sentPackets(t, other)
+
+	Text{TextType: TextTypeChat, Message: "/echo 1  2"}.Handle(p)
+	if len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Fatal("Unexpected command arguments:", got)
+	}
+	Text{TextType: TextTypeChat, Message: "/tp 10 70.5 -5"}.Handle(p)
+	if p.Position != (Vector3{X: 10, Y: 70.5, Z: -5}) {
+		t.Fatal("Player is not teleported:", p.Position)
+	}
+	sentPackets(t, p)
+	Text{TextType: TextTypeChat, Message: "/nonexistent"}.Handle(p)
+	if pks := sentPackets(t, p); len(pks) != 1 || pks[0].(*Text).Message != "Unknown command: nonexistent" {
+		t.Fatal("Unexpected reply to unknown command:", pks)
+	}
+	for _, pk := range sentPackets(t, other) {
+		if _, ok := pk.(*Text); ok {
+			t.Fatal("Command is broadcasted:", pk)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package highmc
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+)
+
+// Recipe types for CraftingData entries
+const (
+	RecipeShapeless byte = 0
+	RecipeShaped    byte = 1
+)
+
+// Recipe is a crafting recipe.
+// For shaped recipes, Input is Width*Height items ordered row by row, and air items are empty cells.
+type Recipe struct {
+	Type          byte
+	Width, Height int // Shaped recipes only
+	Input         []Item
+	Output        []Item
+	UUID          [16]byte
+}
+
+// ShapelessRecipe returns a shapeless recipe with given inputs and outputs.
+func ShapelessRecipe(input []Item, output ...Item) Recipe {
+	return Recipe{
+		Type:   RecipeShapeless,
+		Input:  input,
+		Output: output,
+	}
+}
+
+// ShapedRecipe returns a shaped recipe with given grid size, inputs and outputs.
+func ShapedRecipe(width, height int, input []Item, output ...Item) Recipe {
+	return Recipe{
+		Type:   RecipeShaped,
+		Width:  width,
+		Height: height,
+		Input:  input,
+		Output: output,
+	}
+}
+
+// RecipeRegistry is a list of recipes sent to clients on spawn.
+// Register recipes before starting the server, as the registry is not goroutine-safe.
+var RecipeRegistry []Recipe
+
+// RegisterRecipe validates the recipe and adds it to RecipeRegistry.
+// If the recipe has zero UUID, random UUID will be assigned.
+func RegisterRecipe(recipe Recipe) error {
+	switch recipe.Type {
+	case RecipeShapeless:
+	case RecipeShaped:
+		if recipe.Width < 1 || recipe.Width > 3 || recipe.Height < 1 || recipe.Height > 3 {
+			return fmt.Errorf("invalid shaped recipe size %dx%d", recipe.Width, recipe.Height)
+		}
+		if len(recipe.Input) != recipe.Width*recipe.Height {
+			return fmt.Errorf("shaped recipe needs %d inputs, got %d", recipe.Width*recipe.Height, len(recipe.Input))
+		}
+	default:
+		return fmt.Errorf("unknown recipe type %d", recipe.Type)
+	}
+	if len(recipe.Output) == 0 {
+		return fmt.Errorf("recipe has no outputs")
+	}
+	if recipe.UUID == [16]byte{} {
+		rand.Read(recipe.UUID[:])
+	}
+	RecipeRegistry = append(RecipeRegistry, recipe)
+	return nil
+}
+
+func init() {
+	plank := Item{ID: Plank, Amount: 1}
+	for _, recipe := range []Recipe{
+		ShapelessRecipe([]Item{{ID: Log, Amount: 1}}, Item{ID: Plank, Amount: 4}),
+		ShapedRecipe(2, 2, []Item{plank, plank, plank, plank}, Item{ID: CraftingTable, Amount: 1}),
+	} {
+		if err := RegisterRecipe(recipe); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// writeRecipe writes recipe entry body for CraftingData packet.
+func writeRecipe(buf *bytes.Buffer, recipe Recipe) {
+	if recipe.Type == RecipeShaped {
+		WriteInt(buf, uint32(recipe.Width))
+		WriteInt(buf, uint32(recipe.Height))
+	} else {
+		WriteInt(buf, uint32(len(recipe.Input)))
+	}
+	for _, item := range recipe.Input {
+		Write(buf, item.Write())
+	}
+	WriteInt(buf, uint32(len(recipe.Output)))
+	for _, item := range recipe.Output {
+		Write(buf, item.Write())
+	}
+	Write(buf, recipe.UUID[:])
+}
+
+// readRecipe reads recipe entry body of given type from CraftingData packet.
+func readRecipe(buf *bytes.Buffer, typ byte) (recipe Recipe) {
+	recipe.Type = typ
+	var count uint32
+	if typ == RecipeShaped {
+		width, height := ReadInt(buf), ReadInt(buf)
+		if width > 3 || height > 3 {
+			panic(fmt.Errorf("invalid shaped recipe size %dx%d", width, height))
+		}
+		recipe.Width, recipe.Height = int(width), int(height)
+		count = width * height
+	} else {
+		count = ReadCount(buf, 2)
+	}
+	recipe.Input = make([]Item, count)
+	for j := range recipe.Input {
+		recipe.Input[j].Read(buf)
+	}
+	recipe.Output = make([]Item, ReadCount(buf, 2))
+	for j := range recipe.Output {
+		recipe.Output[j].Read(buf)
+	}
+	copy(recipe.UUID[:], buf.Next(16))
+	return
+}
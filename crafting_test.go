@@ -0,0 +1,59 @@
+package highmc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCraftingData(t *testing.T) {
+	plank := Item{ID: Plank, Amount: 1}
+	shaped := ShapedRecipe(2, 2, []Item{plank, plank, plank, plank}, Item{ID: CraftingTable, Amount: 1})
+	shaped.UUID[0] = 1
+	shapeless := ShapelessRecipe([]Item{{ID: Log, Amount: 1}}, Item{ID: Plank, Amount: 4})
+	b := CraftingData{Recipes: []Recipe{shaped, shapeless}, CleanRecipes: true}.Write().Bytes()
+
+	expected := []byte{CraftingDataHead, 0, 0, 0, 2, 0, 0, 0, byte(RecipeShaped), 0, 0, 0, 63} // Recipe count, type and length
+	expected = append(expected, 0, 0, 0, 2, 0, 0, 0, 2)                                        // Width, height
+	for i := 0; i < 4; i++ {
+		expected = append(expected, 0, byte(Plank), 1, 0, 0, 0, 0)
+	}
+	expected = append(expected, 0, 0, 0, 1, 0, byte(CraftingTable), 1, 0, 0, 0, 0)
+	expected = append(expected, shaped.UUID[:]...)
+	if !bytes.Equal(b[:len(expected)], expected) {
+		t.Fatalf("Unexpected shaped recipe encoding:\n% x\nexpected:\n% x", b[:len(expected)], expected)
+	}
+
+	decoded := new(CraftingData)
+	decoded.Read(bytes.NewBuffer(b[1:]))
+	if len(decoded.Recipes) != 2 || !decoded.CleanRecipes {
+		t.Fatal("Unexpected crafting data:", decoded)
+	}
+	if r := decoded.Recipes[0]; r.Type != RecipeShaped || r.Width != 2 || r.Height != 2 || len(r.Input) != 4 || r.Output[0].ID != CraftingTable || r.UUID != shaped.UUID {
+		t.Fatal("Unexpected shaped recipe:", r)
+	}
+	if r := decoded.Recipes[1]; r.Type != RecipeShapeless || len(r.Input) != 1 || r.Input[0].ID != Log || r.Output[0].ID != Plank || r.Output[0].Amount != 4 {
+		t.Fatal("Unexpected shapeless recipe:", r)
+	}
+}
+
+func TestRegisterRecipe(t *testing.T) {
+	defer func(registry []Recipe) { RecipeRegistry = registry }(RecipeRegistry)
+	plank := Item{ID: Plank, Amount: 1}
+	for _, recipe := range []Recipe{
+		ShapedRecipe(2, 2, []Item{plank}, Item{ID: CraftingTable, Amount: 1}),
+		ShapedRecipe(4, 1, []Item{plank, plank, plank, plank}, Item{ID: CraftingTable, Amount: 1}),
+		ShapelessRecipe([]Item{plank}),
+		{Type: 5, Output: []Item{plank}},
+	} {
+		if err := RegisterRecipe(recipe); err == nil {
+			t.Fatal("Invalid recipe is registered:", recipe)
+		}
+	}
+	n := len(RecipeRegistry)
+	if err := RegisterRecipe(ShapelessRecipe([]Item{plank, plank}, Item{ID: Stick, Amount: 4})); err != nil {
+		t.Fatal(err)
+	}
+	if len(RecipeRegistry) != n+1 || RecipeRegistry[n].UUID == [16]byte{} {
+		t.Fatal("Recipe is not registered with UUID")
+	}
+}
@@ -0,0 +1,176 @@
+package highmc
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Item entity timings and physics
+const (
+	ItemPickupDelay = time.Millisecond * 500 // Dropped items can't be picked up until this delay passes
+	ItemDespawnTime = time.Minute * 5
+	ItemPickupRange = 1.5 // Maximum distance from player feet to pick up items, in blocks
+
+	itemGravity = 0.04 // Blocks per tick^2
+	itemDrag    = 0.98 // Velocity multiplier per tick
+)
+
+// ItemEntity is a dropped item on a level.
+// Item entities are owned by the level, so access them with the level locked.
+type ItemEntity struct {
+	EntityID uint64
+	Item     Item
+	Position Vector3
+	Velocity Vector3 // Blocks per tick
+	Spawned  time.Time
+}
+
+// NewItemEntity returns new item entity with new entity ID.
+func NewItemEntity(item Item, pos, velocity Vector3) *ItemEntity {
+	return &ItemEntity{
+		EntityID: atomic.AddUint64(&lastEntityID, 1),
+		Item:     item,
+		Position: pos,
+		Velocity: velocity,
+		Spawned:  time.Now(),
+	}
+}
+
+// Pickable returns whether the pickup delay of the item has passed.
+func (e *ItemEntity) Pickable(now time.Time) bool {
+	return now.Sub(e.Spawned) >= ItemPickupDelay
+}
+
+// Expired returns whether the item should be despawned.
+func (e *ItemEntity) Expired(now time.Time) bool {
+	return now.Sub(e.Spawned) >= ItemDespawnTime
+}
+
+// AddPacket returns AddItemEntity packet to show the item to players.
+func (e *ItemEntity) AddPacket() *AddItemEntity {
+	item := e.Item
+	return &AddItemEntity{
+		EntityID: e.EntityID,
+		Item:     &item,
+		X:        e.Position.X,
+		Y:        e.Position.Y,
+		Z:        e.Position.Z,
+		SpeedX:   e.Velocity.X,
+		SpeedY:   e.Velocity.Y,
+		SpeedZ:   e.Velocity.Z,
+	}
+}
+
+// move applies velocity and gravity on the item for a tick, and returns whether the item moved.
+// Items stop on top of non-air blocks. Level should be write-locked.
+func (e *ItemEntity) move(lv *Level) bool {
+	if e.Velocity == (Vector3{}) && e.grounded(lv) {
+		return false
+	}
+	e.Velocity.Y -= itemGravity
+	e.Position = Vector3{
+		X: e.Position.X + e.Velocity.X,
+		Y: e.Position.Y + e.Velocity.Y,
+		Z: e.Position.Z + e.Velocity.Z,
+	}
+	if solidAt(lv, e.Position) { // Landed into the block: put it on top
+		e.Position.Y = float32(math.Floor(float64(e.Position.Y))) + 1
+		if e.Position.Y < 0 {
+			e.Position.Y = 0
+		}
+		e.Velocity = Vector3{}
+		return true
+	}
+	e.Velocity.X *= itemDrag
+	e.Velocity.Y *= itemDrag
+	e.Velocity.Z *= itemDrag
+	return true
+}
+
+// grounded returns whether the item is on top of a solid block.
+func (e *ItemEntity) grounded(lv *Level) bool {
+	below := e.Position
+	below.Y -= 0.001
+	return solidAt(lv, below)
+}
+
+// solidAt returns whether the block on given position is not air.
+// Below the world and unloaded chunks are solid, to keep items from falling through. Level should be locked.
+func solidAt(lv *Level, pos Vector3) bool {
+	if pos.Y < 0 {
+		return true
+	}
+	if pos.Y >= WorldHeight {
+		return false
+	}
+	bpos := BlockPos{
+		X: int32(math.Floor(float64(pos.X))),
+		Y: byte(pos.Y),
+		Z: int32(math.Floor(float64(pos.Z))),
+	}
+	if !lv.Available(bpos) {
+		return true
+	}
+	return lv.GetID(bpos) != byte(Air)
+}
+
+// SpawnItem adds new item entity to the level, and shows it to players on the level.
+// It must not be called on server goroutine, and level should not be locked.
+func (lv *Level) SpawnItem(item Item, pos, velocity Vector3) *ItemEntity {
+	e := NewItemEntity(item, pos, velocity)
+	lv.Lock()
+	lv.Items[e.EntityID] = e
+	pk := e.AddPacket()
+	lv.Unlock()
+	if lv.Server != nil {
+		lv.Server.BroadcastPacket(pk, func(p *player) bool {
+			return p.Level == lv
+		})
+	}
+	return e
+}
+
+// PickupItems removes pickable items within ItemPickupRange from given player position, if take returns true.
+// take is called with the level locked, so do not access the level in take. Level should not be locked.
+func (lv *Level) PickupItems(pos Vector3, take func(Item) bool) []*ItemEntity {
+	feet := Vector3{X: pos.X, Y: pos.Y - PlayerEyeHeight, Z: pos.Z}
+	now := time.Now()
+	var taken []*ItemEntity
+	lv.Lock()
+	defer lv.Unlock()
+	for eid, e := range lv.Items {
+		if !e.Pickable(now) || e.Position.Distance(feet) > ItemPickupRange {
+			continue
+		}
+		if !take(e.Item) {
+			continue
+		}
+		delete(lv.Items, eid)
+		taken = append(taken, e)
+	}
+	return taken
+}
+
+// TickItems moves item entities, and removes expired ones.
+// It returns new positions of moved items, and entity IDs of despawned items. Level should not be locked.
+func (lv *Level) TickItems() (moved map[uint64]Vector3, despawned []uint64) {
+	if lv.Items == nil { // Not initialized
+		return nil, nil
+	}
+	now := time.Now()
+	moved = make(map[uint64]Vector3)
+	lv.Lock()
+	defer lv.Unlock()
+	for eid, e := range lv.Items {
+		if e.Expired(now) {
+			delete(lv.Items, eid)
+			despawned = append(despawned, eid)
+			continue
+		}
+		if e.move(lv) {
+			moved[eid] = e.Position
+		}
+	}
+	return
+}
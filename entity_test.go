@@ -0,0 +1,61 @@
+package highmc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestItemDespawn(t *testing.T) {
+	lv := testLevel()
+	e := lv.SpawnItem(Item{ID: Stone, Amount: 1}, Vector3{X: 1.5, Y: 5, Z: 1.5}, Vector3{})
+	if _, despawned := lv.TickItems(); len(despawned) != 0 {
+		t.Fatal("Item is despawned before despawn time")
+	}
+	e.Spawned = time.Now().Add(-ItemDespawnTime)
+	if _, despawned := lv.TickItems(); len(despawned) != 1 || despawned[0] != e.EntityID || len(lv.Items) != 0 {
+		t.Fatal("Expired item is not despawned:", despawned)
+	}
+}
+
+func TestItemPickup(t *testing.T) {
+	lv := testLevel()
+	inv := make(Inventory, 3)
+	inv[0] = Item{ID: Stone, Amount: 63}
+	pi := &PlayerInventory{Inventory: &inv}
+	e := lv.SpawnItem(Item{ID: Stone, Amount: 2}, Vector3{X: 1, Y: 5, Z: 1}, Vector3{})
+	eye := Vector3{X: 1, Y: 5 + PlayerEyeHeight, Z: 1}
+	if len(lv.PickupItems(eye, pi.AddItem)) != 0 {
+		t.Fatal("Item is picked up before pickup delay")
+	}
+	e.Spawned = time.Now().Add(-ItemPickupDelay)
+	if len(lv.PickupItems(Vector3{X: 5, Y: 5 + PlayerEyeHeight, Z: 1}, pi.AddItem)) != 0 {
+		t.Fatal("Item out of pickup range is picked up")
+	}
+	if taken := lv.PickupItems(eye, pi.AddItem); len(taken) != 1 || taken[0] != e || len(lv.Items) != 0 {
+		t.Fatal("Item is not picked up")
+	}
+	if inv[0].Amount != 64 || inv[1].ID != Stone || inv[1].Amount != 1 {
+		t.Fatal("Picked up item is not added to inventory:", inv)
+	}
+
+	full := Inventory{{ID: Dirt, Amount: 64}}
+	lv.SpawnItem(Item{ID: Stone, Amount: 1}, Vector3{X: 1, Y: 5, Z: 1}, Vector3{}).Spawned = e.Spawned
+	if len(lv.PickupItems(eye, (&PlayerInventory{Inventory: &full}).AddItem)) != 0 || len(lv.Items) != 1 {
+		t.Fatal("Item is picked up with full inventory")
+	}
+}
+
+func TestItemLanding(t *testing.T) {
+	lv := testLevel()
+	lv.Set(BlockPos{X: 2, Y: 10, Z: 2}, Block{ID: Stone.Block()})
+	e := lv.SpawnItem(Item{ID: Stone, Amount: 1}, Vector3{X: 2.5, Y: 20, Z: 2.5}, Vector3{Y: 0.1})
+	for i := 0; i < 200; i++ {
+		lv.TickItems()
+	}
+	if e.Position.Y != 11 {
+		t.Fatal("Item is not on top of the block:", e.Position)
+	}
+	if moved, _ := lv.TickItems(); len(moved) != 0 {
+		t.Fatal("Resting item is moved")
+	}
+}
@@ -0,0 +1,114 @@
+package highmc
+
+import (
+	"bytes"
+	"net"
+	"runtime"
+	"testing"
+)
+
+// maxDecodeAlloc is a maximum bytes allocated while decoding a fuzz input, per input byte.
+// Decoders should not allocate much more than the input, whatever lengths the input claims.
+const maxDecodeAlloc = 1024
+
+// checkDecode runs decode with a recover, like session.handlePacket does.
+// Decode errors are expected, but runtime errors such as out of range indices are bugs on decoders.
+// Allocations over limit bytes are also reported.
+func checkDecode(t *testing.T, name string, input []byte, limit uint64, decode func(*bytes.Buffer)) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if err, ok := r.(runtime.Error); ok {
+					t.Fatalf("%s: runtime error while decoding %x: %v", name, input, err)
+				}
+			}
+		}()
+		decode(bytes.NewBuffer(append([]byte(nil), input...)))
+	}()
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > limit {
+		t.Fatalf("%s: decoding %d bytes allocated %d bytes", name, len(input), alloc)
+	}
+}
+
+// fuzzSeeds returns encoded valid packets, including their pids.
+func fuzzSeeds() [][]byte {
+	var seeds [][]byte
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}
+	addrs := make([]*net.UDPAddr, 10)
+	for i := range addrs {
+		addrs[i] = addr
+	}
+	for _, pk := range []interface {
+		Write(*bytes.Buffer)
+	}{
+		&UnconnectedPing{PingID: 1},
+		&OpenConnectionRequest1{Protocol: RaknetProtocol, MtuSize: 1400},
+		&OpenConnectionRequest2{ServerAddress: addr, MtuSize: 1400, ClientID: 1},
+		&ClientConnect{ClientID: 1, SendPing: 2},
+		&ClientHandshake{Address: addr, SystemAddresses: addrs, SendPing: 1, SendPong: 2},
+		&ServerHandshake{Address: addr, SystemAddresses: addrs, SendPing: 1, SendPong: 2},
+	} {
+		buf := new(bytes.Buffer)
+		pk.Write(buf)
+		seeds = append(seeds, buf.Bytes())
+	}
+	dp := &DataPacket{Head: 0x84, SeqNumber: 1, Packets: []*EncapsulatedPacket{
+		{Buffer: bytes.NewBuffer([]byte{0x8e, TextHead}), Reliability: 2, MessageIndex: 1},
+		{Buffer: bytes.NewBuffer([]byte{0x00, 1, 2, 3}), Reliability: 3, HasSplit: true, SplitCount: 2, SplitID: 1},
+	}}
+	dp.Encode()
+	seeds = append(seeds, dp.Bytes())
+	seeds = append(seeds, append([]byte{0xc0}, EncodeAck([]uint32{1, 2, 3, 7}).Bytes()...))
+	for _, pk := range []MCPEPacket{
+		&Login{
+			Username: "Steve", Proto1: MinecraftProtocol, Proto2: MinecraftProtocol,
+			SkinName: "Standard_Steve", Skin: make([]byte, SkinSize),
+		},
+		&Text{TextType: TextTypeChat, Source: "Steve", Message: "Hello"},
+		&MovePlayer{X: 1, Y: 2, Z: 3},
+		&UseItem{X: 1, Y: 2, Z: 3, Face: SideUp, Item: &Item{ID: Stone, Amount: 1}},
+		&PlayerAction{Action: ActionStartBreak, X: 1, Y: 2, Z: 3},
+		&MobEquipment{Item: &Item{ID: Stone, Amount: 1}},
+		&ContainerSetSlot{Windowid: InventoryWindow, Slot: 1, Item: &Item{ID: Dirt, Amount: 3}},
+		&ContainerSetContent{WindowID: InventoryWindow, Slots: []Item{{ID: Stone, Amount: 1}}, Hotbar: []uint32{9}},
+		&RequestChunkRadius{Radius: 4},
+		&Batch{Payloads: [][]byte{(&RequestChunkRadius{Radius: 4}).Write().Bytes()}},
+	} {
+		seeds = append(seeds, pk.Write().Bytes())
+	}
+	return seeds
+}
+
+// FuzzDecode feeds arbitrary bytes into MCPE and Raknet packet decoders, dispatched with the first byte.
+func FuzzDecode(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if len(b) == 0 {
+			return
+		}
+		limit := uint64(len(b)+64) * maxDecodeAlloc
+		if pk := GetMCPEPacket(b[0]); pk != nil {
+			if b[0] == BatchHead { // Compressed payload, bounded by MaxDeflateSize
+				limit += MaxDeflateSize * 4
+			}
+			checkDecode(t, "MCPE packet", b[1:], limit, func(buf *bytes.Buffer) {
+				pk.Read(buf)
+			})
+		}
+		if pk := GetRaknetPacket(b[0]); pk != nil {
+			checkDecode(t, "Raknet packet", b[1:], limit, func(buf *bytes.Buffer) {
+				pk.Read(buf)
+			})
+		}
+		if pk := GetDataPacket(b[0]); pk != nil {
+			checkDecode(t, "Raknet data packet", b[1:], limit, func(buf *bytes.Buffer) {
+				pk.Read(buf)
+			})
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package highmc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Generator is a interface for level chunk generators.
+// Generate may be called on several goroutines at once.
+type Generator interface {
+	Generate(ChunkPos) *Chunk
+}
+
+// FlatLayer is a layer of same blocks for FlatGenerator.
+type FlatLayer struct {
+	Block  Block
+	Height int
+}
+
+// FlatGenerator generates flat chunks with given block layers, from bottom to top.
+type FlatGenerator struct {
+	Layers     []FlatLayer
+	Biome      byte
+	BiomeColor [3]byte
+}
+
+// DefaultFlatGenerator generates bedrock, dirt and grass layers with grass surface on Y=56.
+var DefaultFlatGenerator = &FlatGenerator{
+	Layers: []FlatLayer{
+		{Block{ID: Bedrock.Block()}, 1},
+		{Block{ID: Dirt.Block()}, 55},
+		{Block{ID: Grass.Block()}, 1},
+	},
+	Biome:      BiomePlains,
+	BiomeColor: [3]byte{20, 128, 10},
+}
+
+// BiomePlains is a biome ID of plains.
+const BiomePlains = 1
+
+// NewFlatGenerator returns a FlatGenerator with layer spec. See ParseFlatLayers for spec format.
+func NewFlatGenerator(spec string) (*FlatGenerator, error) {
+	layers, err := ParseFlatLayers(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &FlatGenerator{
+		Layers:     layers,
+		Biome:      DefaultFlatGenerator.Biome,
+		BiomeColor: DefaultFlatGenerator.BiomeColor,
+	}, nil
+}
+
+// ParseFlatLayers parses comma-separated layer spec, from bottom to top.
+// Each layer is [height*]id[:meta], e.g. "7,3*3,2" is a bedrock, 3 dirt and a grass layer.
+// Empty spec means no layers, so every block is air.
+func ParseFlatLayers(spec string) ([]FlatLayer, error) {
+	var layers []FlatLayer
+	if strings.TrimSpace(spec) == "" {
+		return layers, nil
+	}
+	for _, token := range strings.Split(spec, ",") {
+		token = strings.TrimSpace(token)
+		layer := FlatLayer{Height: 1}
+		if i := strings.Index(token, "*"); i >= 0 {
+			h, err := strconv.Atoi(token[:i])
+			if err != nil || h < 1 {
+				return nil, fmt.Errorf("invalid layer height in %q", token)
+			}
+			layer.Height, token = h, token[i+1:]
+		}
+		meta := "0"
+		if i := strings.Index(token, ":"); i >= 0 {
+			token, meta = token[:i], token[i+1:]
+		}
+		id, err := strconv.ParseUint(token, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block ID %q", token)
+		}
+		m, err := strconv.ParseUint(meta, 10, 4)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block meta %q", meta)
+		}
+		layer.Block = Block{ID: byte(id), Meta: byte(m)}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// Generate implements Generator interface.
+// Blocks above the layers get full sky light, and layers above WorldHeight are truncated.
+func (g *FlatGenerator) Generate(pos ChunkPos) *Chunk {
+	chunk := new(Chunk)
+	chunk.Position = pos
+	for x := byte(0); x < 16; x++ {
+		for z := byte(0); z < 16; z++ {
+			y := 0
+			for _, layer := range g.Layers {
+				for n := 0; n < layer.Height && y < WorldHeight; n++ {
+					chunk.SetBlock(x, byte(y), z, layer.Block.ID)
+					chunk.SetBlockMeta(x, byte(y), z, layer.Block.Meta)
+					y++
+				}
+			}
+			for ; y < WorldHeight; y++ {
+				chunk.SetBlockSkyLight(x, byte(y), z, 15)
+			}
+			chunk.SetBiomeID(x, z, g.Biome)
+			chunk.SetBiomeColor(x, z, g.BiomeColor[0], g.BiomeColor[1], g.BiomeColor[2])
+		}
+	}
+	return chunk
+}
@@ -0,0 +1,73 @@
+package highmc
+
+import "testing"
+
+func TestFlatGenerator(t *testing.T) {
+	c := DefaultFlatGenerator.Generate(ChunkPos{X: 2, Z: 3})
+	for y, id := range map[byte]byte{0: Bedrock.Block(), 1: Dirt.Block(), 55: Dirt.Block(), 56: Grass.Block(), 57: 0} {
+		if c.GetBlock(3, y, 4) != id {
+			t.Fatal("Unexpected block", c.GetBlock(3, y, 4), "on Y", y)
+		}
+	}
+	if c.GetHeightMap(3, 4) != 56 || c.GetBlockSkyLight(3, 57, 4) != 15 || c.GetBiomeID(3, 4) != BiomePlains {
+		t.Fatal("Unexpected height map, light or biome")
+	}
+}
+
+func TestCreateChunk(t *testing.T) {
+	lv := &Level{Provider: newTestProvider()}
+	lv.Init()
+	defer lv.Close()
+	pos := ChunkPos{X: 5, Z: 5}
+	c := lv.CreateChunk(pos)
+	if c == nil || c.GetBlock(0, 56, 0) != Grass.Block() {
+		t.Fatal("Unsaved chunk is not generated")
+	}
+	if lv.CreateChunk(pos) != c || !lv.Available(BlockPos{X: 80, Y: 56, Z: 80}) {
+		t.Fatal("Generated chunk is not loaded")
+	}
+}
+
+func TestParseFlatLayers(t *testing.T) {
+	layers, err := ParseFlatLayers("7,3*3,2:1")
+	if err != nil || len(layers) != 3 {
+		t.Fatal("Unexpected layers:", layers, err)
+	}
+	if layers[0] != (FlatLayer{Block{ID: 7}, 1}) || layers[1] != (FlatLayer{Block{ID: 3}, 3}) || layers[2] != (FlatLayer{Block{ID: 2, Meta: 1}, 1}) {
+		t.Fatal("Unexpected layers:", layers)
+	}
+	if layers, err := ParseFlatLayers(""); err != nil || len(layers) != 0 {
+		t.Fatal("Empty spec should have no layers:", layers, err)
+	}
+	for _, spec := range []string{"x", "0*3", "3*", "1,,2", "300", "1:16"} {
+		if _, err := ParseFlatLayers(spec); err == nil {
+			t.Error("Invalid spec", spec, "is accepted")
+		}
+	}
+}
+
+func TestFlatGeneratorLayers(t *testing.T) {
+	for _, c := range []struct {
+		spec   string
+		column map[byte]Block
+		height byte
+	}{
+		{"7,3*3,2:1", map[byte]Block{0: {ID: 7}, 1: {ID: 3}, 3: {ID: 3}, 4: {ID: 2, Meta: 1}, 5: {}}, 4},
+		{"200*1,2", map[byte]Block{0: {ID: 1}, WorldHeight - 1: {ID: 1}}, WorldHeight - 1}, // Truncated
+		{"", map[byte]Block{0: {}, 64: {}}, 0},
+	} {
+		g, err := NewFlatGenerator(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk := g.Generate(ChunkPos{})
+		for y, block := range c.column {
+			if got := (Block{ID: chunk.GetBlock(8, y, 8), Meta: chunk.GetBlockMeta(8, y, 8)}); got != block {
+				t.Fatal("Unexpected block", got, "on Y", y, "with spec", c.spec)
+			}
+		}
+		if chunk.GetHeightMap(8, 8) != c.height || chunk.GetBiomeID(8, 8) != BiomePlains {
+			t.Fatal("Unexpected height map or biome with spec", c.spec)
+		}
+	}
+}
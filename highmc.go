@@ -13,6 +13,10 @@ var GitCommit = "unknown"
 // You should set this with -ldflags "-X github.com/cr0sh/highmc.BuildTime="
 var BuildTime = "unknown"
 
+// Debug enables debugging outputs such as packet dumps.
+// Outputs are written to standard logger, so use log.SetOutput to redirect them.
+var Debug = false
+
 var lastEntityID = uint64(1)
 
 var defaultLvl = "default"
@@ -0,0 +1,257 @@
+package highmc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// testClient is a minimal Raknet/MCPE client for integration tests.
+type testClient struct {
+	t        *testing.T
+	conn     *net.UDPConn
+	server   *net.UDPAddr
+	seq      uint32
+	msgIndex uint32
+	splitID  uint16
+	splits   map[uint16][][]byte
+}
+
+// dialTest connects new test client to the router over loopback.
+func dialTest(t *testing.T, r *Router) *testClient {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: r.conn.LocalAddr().(*net.UDPAddr).Port}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{t: t, conn: conn, server: addr, splits: make(map[uint16][][]byte)}
+}
+
+// sendRaknet sends unconnected Raknet packet.
+func (c *testClient) sendRaknet(pk interface {
+	Write(*bytes.Buffer)
+}) {
+	buf := new(bytes.Buffer)
+	pk.Write(buf)
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// sendReliable sends the payload with reliable encapsulated packets, splitting it if needed.
+func (c *testClient) sendReliable(payload []byte) {
+	const mtu = 1000
+	count := uint32((len(payload) + mtu - 1) / mtu)
+	for i := uint32(0); i < count; i++ {
+		end := int(i+1) * mtu
+		if end > len(payload) {
+			end = len(payload)
+		}
+		ep := &EncapsulatedPacket{
+			Buffer:       bytes.NewBuffer(payload[int(i)*mtu : end]),
+			Reliability:  2,
+			MessageIndex: c.msgIndex,
+		}
+		if count > 1 {
+			ep.HasSplit, ep.SplitCount, ep.SplitID, ep.SplitIndex = true, count, c.splitID, i
+		}
+		c.msgIndex++
+		dp := &DataPacket{Head: 0x84, SeqNumber: c.seq, Packets: []*EncapsulatedPacket{ep}}
+		dp.Encode()
+		c.seq++
+		if _, err := c.conn.Write(dp.Bytes()); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	c.splitID++
+}
+
+// sendMCPE sends MCPE packet.
+func (c *testClient) sendMCPE(pk MCPEPacket) {
+	c.sendReliable(append([]byte{0x8e}, pk.Write().Bytes()...))
+}
+
+// read reads a datagram from the server.
+func (c *testClient) read(deadline time.Time) []byte {
+	b := make([]byte, 65536)
+	c.conn.SetReadDeadline(deadline)
+	n, err := c.conn.Read(b)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return b[:n]
+}
+
+// readUntil reads encapsulated payloads from the server, until match returns true for one of them.
+// Split packets are reassembled before passed to match.
+func (c *testClient) readUntil(match func(payload []byte) bool) {
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		b := c.read(deadline)
+		if b[0] < 0x80 || b[0] > 0x8f { // Not a data packet
+			continue
+		}
+		dp := &DataPacket{Buffer: bytes.NewBuffer(b[1:])}
+		if !decodeData(dp) {
+			continue
+		}
+		for _, ep := range dp.Packets {
+			payload := ep.Buffer.Bytes()
+			if ep.HasSplit {
+				if payload = c.joinSplit(ep); payload == nil {
+					continue
+				}
+			}
+			if match(payload) {
+				return
+			}
+		}
+	}
+}
+
+// decodeData decodes the data packet, and returns false if it is malformed.
+// Outbound packets are not split, so ones over 8KB like PlayerList with a skin overflow the length field.
+func decodeData(dp *DataPacket) (ok bool) {
+	defer func() {
+		ok = recover() == nil
+	}()
+	dp.Decode()
+	return
+}
+
+// joinSplit stores the split part, and returns joined payload if every parts are received.
+func (c *testClient) joinSplit(ep *EncapsulatedPacket) []byte {
+	parts, ok := c.splits[ep.SplitID]
+	if !ok {
+		parts = make([][]byte, ep.SplitCount)
+		c.splits[ep.SplitID] = parts
+	}
+	parts[ep.SplitIndex] = append([]byte(nil), ep.Buffer.Bytes()...)
+	var joined []byte
+	for _, part := range parts {
+		if part == nil {
+			return nil
+		}
+		joined = append(joined, part...)
+	}
+	delete(c.splits, ep.SplitID)
+	return joined
+}
+
+// login connects to the server and logs in with given username, until StartGame is received.
+func (c *testClient) login(username string) {
+	c.sendRaknet(&OpenConnectionRequest1{Protocol: RaknetProtocol, MtuSize: 1400})
+	if b := c.read(time.Now().Add(time.Second * 5)); b[0] != 0x06 {
+		c.t.Fatalf("Expected OpenConnectionReply1, got 0x%02x", b[0])
+	}
+	c.sendRaknet(&OpenConnectionRequest2{ServerAddress: c.server, MtuSize: 1400, ClientID: 1})
+	if b := c.read(time.Now().Add(time.Second * 5)); b[0] != 0x08 {
+		c.t.Fatalf("Expected OpenConnectionReply2, got 0x%02x", b[0])
+	}
+
+	buf := new(bytes.Buffer)
+	(&ClientConnect{ClientID: 1, SendPing: 5}).Write(buf)
+	c.sendReliable(buf.Bytes())
+	c.readUntil(func(payload []byte) bool {
+		return payload[0] == 0x10 // ServerHandshake
+	})
+
+	buf.Reset()
+	addrs := make([]*net.UDPAddr, 10)
+	for i := range addrs {
+		addrs[i] = &net.UDPAddr{IP: net.IPv4(0, 0, 0, 0)}
+	}
+	(&ClientHandshake{Address: c.server, SystemAddresses: addrs}).Write(buf)
+	c.sendReliable(buf.Bytes())
+
+	c.sendMCPE(&Login{
+		Username:     username,
+		Proto1:       MinecraftProtocol,
+		Proto2:       MinecraftProtocol,
+		ClientID:     1,
+		ClientSecret: "secret",
+		SkinName:     "Standard_Steve",
+		Skin:         make([]byte, SkinSize),
+	})
+	var loginSuccess, startGame bool
+	c.readUntil(func(payload []byte) bool {
+		if len(payload) < 2 || payload[0] != 0x8e {
+			return false
+		}
+		buf := bytes.NewBuffer(payload[2:])
+		switch payload[1] {
+		case PlayStatusHead:
+			pk := new(PlayStatus)
+			pk.Read(buf)
+			if pk.Status == LoginSuccess {
+				loginSuccess = true
+			}
+		case StartGameHead:
+			if !loginSuccess {
+				c.t.Fatal("StartGame is sent before PlayStatus(LoginSuccess)")
+			}
+			startGame = true
+		}
+		return loginSuccess && startGame
+	})
+}
+
+func TestLoopbackLogin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping loopback integration test in short mode")
+	}
+	r, err := CreateRouter(0, WithRandSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	s := NewServer()
+	r.Owner, s.Router = s, r
+	r.Start()
+	s.Start()
+	defer s.Stop()
+
+	c := dialTest(t, r)
+	defer c.conn.Close()
+	c.login("Steve")
+}
+
+func TestTwoServers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping loopback integration test in short mode")
+	}
+	var servers []*Server
+	var clients []*testClient
+	for i := 0; i < 2; i++ {
+		r, err := CreateRouter(0, WithRandSeed(int64(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Stop()
+		s := NewServer()
+		r.Owner, s.Router = s, r
+		r.Start()
+		s.Start()
+		defer s.Stop()
+		c := dialTest(t, r)
+		defer c.conn.Close()
+		servers, clients = append(servers, s), append(clients, c)
+	}
+	for _, c := range clients { // Same username on both servers: the second login should not kick the first
+		c.login("Steve")
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for i, s := range servers { // Players are registered after the spawn sequence is sent
+		roster := s.Roster()
+		for ; len(roster) == 0 && time.Now().Before(deadline); roster = s.Roster() {
+			time.Sleep(time.Millisecond * 10)
+		}
+		if len(roster) != 1 || roster[0].Username != "Steve" {
+			t.Fatal("Unexpected roster on server", i, ":", roster)
+		}
+	}
+	if servers[0].Router.ServerID == servers[1].Router.ServerID {
+		t.Fatal("Servers share the server ID")
+	}
+}
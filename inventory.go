@@ -1,20 +1,180 @@
 package highmc
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/minero/minero/proto/nbt"
+)
+
+const (
+	// PlayerInventorySize is a count of player inventory slots, excluding armor slots.
+	PlayerInventorySize = 36
+	// HotbarSize is a count of player hotbar slots.
+	HotbarSize = 9
+	// CreativeHotbarSize is a count of hotbar slots creative players can put items on, with GiveCreative.
+	CreativeHotbarSize = 8
+	// HotbarUnlinked is a hotbar link value for hotbar slots not linked to any inventory slot.
+	HotbarUnlinked = -1
+)
+
+// ArmorSlotOffset is a slot index of first armor slot on marshaled player inventory.
+const ArmorSlotOffset = 100
+
+// Armor slot indexes of PlayerInventory.Armor
+const (
+	ArmorHelmet = iota
+	ArmorChestplate
+	ArmorLeggings
+	ArmorBoots
+)
+
+// ArmorSlot returns the armor slot index where the item can be worn, or false if the item is not an armor.
+func ArmorSlot(id ID) (int, bool) {
+	if id < LeatherCap || id > GoldBoots {
+		return 0, false
+	}
+	return int(id-LeatherCap) % 4, true
+}
+
 // Inventory is just a set of items, for containers or inventory holder entities.
 type Inventory []Item
 
+// Marshal converts the inventory to NBT list of slot-indexed item compounds.
+// Empty slots are omitted.
+func (inv Inventory) Marshal() *nbt.List {
+	list := &nbt.List{Typ: nbt.TagCompound}
+	for slot, item := range inv {
+		if item.ID == 0 {
+			continue
+		}
+		list.Value = append(list.Value, item.marshal(slot))
+	}
+	return list
+}
+
+// Unmarshal reads items from NBT list made with Inventory.Marshal.
+// Slots not in the list are emptied, and inventory grows if the list has slot index out of inventory.
+func (inv *Inventory) Unmarshal(list *nbt.List) error {
+	for i := range *inv {
+		(*inv)[i] = Item{}
+	}
+	for _, tag := range list.Value {
+		slot, item, err := unmarshalItem(tag)
+		if err != nil {
+			return err
+		}
+		if slot >= len(*inv) {
+			grown := make(Inventory, slot+1)
+			copy(grown, *inv)
+			*inv = grown
+		}
+		(*inv)[slot] = item
+	}
+	return nil
+}
+
+// Marshal converts the player inventory to NBT list, with armor slots from ArmorSlotOffset.
+func (pi *PlayerInventory) Marshal() *nbt.List {
+	list := &nbt.List{Typ: nbt.TagCompound}
+	if pi.Inventory != nil {
+		list = pi.Inventory.Marshal()
+	}
+	for i, item := range pi.Armor {
+		if item.ID == 0 {
+			continue
+		}
+		list.Value = append(list.Value, item.marshal(ArmorSlotOffset+i))
+	}
+	return list
+}
+
+// Unmarshal reads player inventory from NBT list made with PlayerInventory.Marshal.
+func (pi *PlayerInventory) Unmarshal(list *nbt.List) error {
+	inv := make(Inventory, PlayerInventorySize)
+	pi.Armor = [4]Item{}
+	items := &nbt.List{Typ: nbt.TagCompound}
+	for _, tag := range list.Value {
+		slot, item, err := unmarshalItem(tag)
+		if err != nil {
+			return err
+		}
+		if slot >= ArmorSlotOffset && slot < ArmorSlotOffset+len(pi.Armor) {
+			pi.Armor[slot-ArmorSlotOffset] = item
+			continue
+		}
+		items.Value = append(items.Value, tag)
+	}
+	if err := inv.Unmarshal(items); err != nil {
+		return err
+	}
+	pi.Inventory = &inv
+	return nil
+}
+
+func (i Item) marshal(slot int) *nbt.Compound {
+	c := &nbt.Compound{
+		Value: map[string]nbt.Tag{
+			"Slot":   &nbt.Byte{Int8: int8(slot)},
+			"id":     &nbt.Short{Int16: int16(i.ID)},
+			"Damage": &nbt.Short{Int16: int16(i.Meta)},
+			"Count":  &nbt.Byte{Int8: int8(i.Amount)},
+		},
+	}
+	if i.Compound != nil {
+		tag := *i.Compound
+		tag.Name = "tag"
+		c.Value["tag"] = &tag
+	}
+	return c
+}
+
+func unmarshalItem(tag nbt.Tag) (slot int, item Item, err error) {
+	c, ok := tag.(*nbt.Compound)
+	if !ok {
+		return 0, item, fmt.Errorf("item tag should be compound, got %T", tag)
+	}
+	s, ok1 := c.Value["Slot"].(*nbt.Byte)
+	id, ok2 := c.Value["id"].(*nbt.Short)
+	damage, ok3 := c.Value["Damage"].(*nbt.Short)
+	count, ok4 := c.Value["Count"].(*nbt.Byte)
+	if !(ok1 && ok2 && ok3 && ok4) {
+		return 0, item, fmt.Errorf("item compound has missing or invalid fields")
+	}
+	item = Item{
+		ID:     ID(uint16(id.Int16)),
+		Meta:   uint16(damage.Int16),
+		Amount: byte(count.Int8),
+	}
+	if t, ok := c.Value["tag"].(*nbt.Compound); ok {
+		item.Compound = t
+	}
+	return int(byte(s.Int8)), item, nil
+}
+
 // PlayerInventory is a inventory holder for players.
 type PlayerInventory struct {
 	*Inventory
+	Armor   [4]Item // Helmet, Chestplate, Leggings, Boots
 	Hotbars []Item
 	Hand    Item
 	Holder  *player
+
+	SelectedSlot int // Selected hotbar slot, which Hand is from
+
+	HotbarLinks [HotbarSize]int // Inventory slot index for each hotbar slot
+
+	transaction *transaction // Pending survival slot changes, nil if balanced
 }
 
-// Init initializes the inventory.
+// Init initializes the inventory, and sends its contents to the holder.
+// Creative players get creative items only. Other players get saved inventory items, with hotbar links and armor.
 func (pi *PlayerInventory) Init() {
-	pi.Hotbars = make([]Item, 8)
-	if true { // No survival inventory now
+	for i := range pi.HotbarLinks {
+		pi.HotbarLinks[i] = i
+	}
+	if pi.Holder.Gamemode == GamemodeCreative {
+		pi.Hotbars = make([]Item, CreativeHotbarSize)
 		inv := make(Inventory, len(CreativeItems))
 		copy(inv, CreativeItems)
 		pi.Inventory = &inv
@@ -22,5 +182,321 @@ func (pi *PlayerInventory) Init() {
 			WindowID: CreativeWindow,
 			Slots:    inv,
 		})
+		return
+	}
+	pi.Hotbars = make([]Item, HotbarSize)
+	if pi.Inventory == nil { // No saved data
+		inv := make(Inventory, PlayerInventorySize)
+		pi.Inventory = &inv
+	}
+	pi.Holder.SendCompressed(&ContainerSetContent{
+		WindowID: InventoryWindow,
+		Slots:    *pi.Inventory,
+		Hotbar:   pi.HotbarLink(),
+	})
+	pi.SendArmor()
+}
+
+// SendArmor sends armor contents to the holder.
+func (pi *PlayerInventory) SendArmor() {
+	pi.Holder.SendCompressed(&ContainerSetContent{
+		WindowID: ArmorWindow,
+		Slots:    pi.Armor[:],
+	})
+}
+
+// MaxStackSize is a maximum amount of items in a slot.
+const MaxStackSize = 64
+
+// AddItem puts the item into the inventory, stacking on slots with same item first.
+// It returns false without modifying the inventory if there is not enough room.
+func (pi *PlayerInventory) AddItem(item Item) bool {
+	if pi.Inventory == nil || item.ID == 0 || item.Amount == 0 {
+		return false
+	}
+	inv := *pi.Inventory
+	stackable := func(slot Item) bool {
+		return slot.ID == item.ID && slot.Meta == item.Meta && slot.Compound == nil && item.Compound == nil
+	}
+	room := 0
+	for _, slot := range inv {
+		if slot.ID == 0 {
+			room += MaxStackSize
+		} else if stackable(slot) && slot.Amount < MaxStackSize {
+			room += MaxStackSize - int(slot.Amount)
+		}
+	}
+	if room < int(item.Amount) {
+		return false
+	}
+	left := item.Amount
+	for i := range inv {
+		if stackable(inv[i]) && inv[i].Amount < MaxStackSize {
+			n := MaxStackSize - inv[i].Amount
+			if n > left {
+				n = left
+			}
+			inv[i].Amount += n
+			if left -= n; left == 0 {
+				return true
+			}
+		}
+	}
+	for i := range inv {
+		if inv[i].ID == 0 {
+			inv[i] = item
+			if left <= MaxStackSize {
+				inv[i].Amount = left
+				return true
+			}
+			inv[i].Amount = MaxStackSize
+			left -= MaxStackSize
+		}
+	}
+	return true
+}
+
+// HotbarLink returns hotbar link array for ContainerSetContent on inventory window.
+// Client addresses inventory slots after hotbar slots, so linked indexes are offset by HotbarSize.
+func (pi *PlayerInventory) HotbarLink() []uint32 {
+	links := make([]uint32, HotbarSize)
+	for i, idx := range pi.HotbarLinks {
+		if idx <= HotbarUnlinked {
+			links[i] = ^uint32(0)
+			continue
+		}
+		links[i] = uint32(idx + HotbarSize)
+	}
+	return links
+}
+
+// HandSlot returns the inventory slot linked to the selected hotbar slot, or nil if not linked.
+func (pi *PlayerInventory) HandSlot() *Item {
+	if pi.SelectedSlot < 0 || pi.SelectedSlot >= HotbarSize {
+		return nil
+	}
+	return pi.Slot(InventoryWindow, pi.HotbarLinks[pi.SelectedSlot])
+}
+
+// Equip selects the hotbar slot, and links it to given inventory slot.
+// Inventory slot index is the one from MobEquipment, which is offset by HotbarSize. Out of range index unlinks the hotbar slot.
+// Non-creative holders can only hold the item on the inventory slot: it returns error if item does not match.
+func (pi *PlayerInventory) Equip(hotbar int, slot int, item Item) error {
+	if hotbar < 0 || hotbar >= HotbarSize {
+		return fmt.Errorf("invalid hotbar slot %d", hotbar)
+	}
+	if pi.Holder != nil && pi.Holder.Gamemode == GamemodeCreative {
+		if err := pi.GiveCreative(hotbar, item); err != nil {
+			return err
+		}
+		pi.SelectedSlot, pi.Hand = hotbar, item
+		return nil
+	}
+	slot -= HotbarSize
+	var held Item
+	if target := pi.Slot(InventoryWindow, slot); target != nil {
+		held = *target
+	} else {
+		slot = HotbarUnlinked
+	}
+	if item.ID != held.ID || item.ID != 0 && item.Meta != held.Meta {
+		return fmt.Errorf("item %d:%d is not on inventory slot %d", item.ID, item.Meta, slot)
+	}
+	pi.HotbarLinks[hotbar] = slot
+	pi.SelectedSlot, pi.Hand = hotbar, held
+	return nil
+}
+
+// creativeItemSet is a set of ID and meta pairs of CreativeItems.
+var creativeItemSet = make(map[[2]uint16]struct{})
+
+func init() {
+	for _, item := range CreativeItems {
+		creativeItemSet[[2]uint16{uint16(item.ID), item.Meta}] = struct{}{}
+	}
+}
+
+// IsCreativeItem returns true if the item is air, or its ID and meta is in CreativeItems.
+func IsCreativeItem(item Item) bool {
+	if item.ID == 0 {
+		return true
+	}
+	_, ok := creativeItemSet[[2]uint16{uint16(item.ID), item.Meta}]
+	return ok
+}
+
+// GiveCreative puts the creative item into given hotbar slot.
+// It returns error if the holder is not in creative mode, or the item is not a creative item.
+func (pi *PlayerInventory) GiveCreative(slot int, item Item) error {
+	if pi.Holder.Gamemode != GamemodeCreative {
+		return fmt.Errorf("player is not in creative mode")
+	}
+	if !IsCreativeItem(item) {
+		return fmt.Errorf("item %d:%d is not a creative item", item.ID, item.Meta)
+	}
+	if slot < 0 || slot >= len(pi.Hotbars) {
+		return fmt.Errorf("invalid hotbar slot %d", slot)
+	}
+	pi.Hotbars[slot] = item
+	return nil
+}
+
+// TransactionTimeout is a time to wait for slot changes from client to balance out.
+// If slot changes still create items after the timeout, they are reverted.
+const TransactionTimeout = time.Second
+
+type slotChange struct {
+	window byte
+	slot   int
+	old    Item
+}
+
+// transaction is a set of slot changes from client, which should not create items in total.
+// For example, swapping two slots is sent as two slot changes, and the first one creates items temporarily.
+type transaction struct {
+	changes []slotChange
+	balance map[[2]uint16]int // Item ID and meta: amount created by changes, negative if removed
+	started time.Time
+}
+
+func itemKey(item Item) [2]uint16 {
+	return [2]uint16{uint16(item.ID), item.Meta}
+}
+
+// Slot returns the pointer to the slot on given window, or nil if the window or slot is invalid.
+func (pi *PlayerInventory) Slot(window byte, slot int) *Item {
+	switch window {
+	case InventoryWindow:
+		if pi.Inventory != nil && slot >= 0 && slot < len(*pi.Inventory) {
+			return &(*pi.Inventory)[slot]
+		}
+	case ArmorWindow:
+		if slot >= 0 && slot < len(pi.Armor) {
+			return &pi.Armor[slot]
+		}
+	default:
+		if pi.Holder == nil {
+			return nil
+		}
+		if inv, ok := pi.Holder.openContainers[window]; ok && slot >= 0 && slot < len(*inv) {
+			return &(*inv)[slot]
+		}
+	}
+	return nil
+}
+
+// count returns total amount of given item ID and meta on inventory, armor and open container slots.
+func (pi *PlayerInventory) count(key [2]uint16) (n int) {
+	invs := []Inventory{pi.Armor[:]}
+	if pi.Inventory != nil {
+		invs = append(invs, *pi.Inventory)
+	}
+	if pi.Holder != nil {
+		for _, inv := range pi.Holder.openContainers {
+			invs = append(invs, *inv)
+		}
+	}
+	for _, inv := range invs {
+		for _, item := range inv {
+			if item.ID != 0 && itemKey(item) == key {
+				n += int(item.Amount)
+			}
+		}
+	}
+	return
+}
+
+// SetSlot applies a slot change from non-creative client.
+// It returns error without changing the slot, if pending changes would create more items than the inventory had
+// before the transaction. Otherwise the change is applied, and kept pending until following changes balance it out.
+// Pending changes which still create items after TransactionTimeout are reverted by ExpireTransaction,
+// which runs on player ticks and before each change.
+func (pi *PlayerInventory) SetSlot(window byte, slot int, item Item) error {
+	now := time.Now()
+	pi.ExpireTransaction(now)
+	target := pi.Slot(window, slot)
+	if target == nil {
+		return fmt.Errorf("invalid slot %d on window 0x%02x", slot, window)
+	}
+	if item.ID == 0 || item.Amount == 0 {
+		item = Item{}
+	}
+	if window == ArmorWindow && item.ID != 0 {
+		if armor, ok := ArmorSlot(item.ID); !ok || armor != slot {
+			return fmt.Errorf("item %d can't be worn on armor slot %d", item.ID, slot)
+		}
+	}
+	old := *target
+	tx := pi.transaction
+	if tx == nil {
+		tx = &transaction{balance: make(map[[2]uint16]int), started: now}
+	}
+	if item.ID != 0 {
+		key := itemKey(item)
+		created := tx.balance[key] + int(item.Amount)
+		existed := pi.count(key) - tx.balance[key] // Items before the transaction, as live slots have pending changes
+		if old.ID != 0 && itemKey(old) == key {
+			created -= int(old.Amount)
+		}
+		if created > 0 && created > existed {
+			return fmt.Errorf("slot change creates %d of item %d:%d", created, item.ID, item.Meta)
+		}
+	}
+	if item.ID != 0 {
+		tx.balance[itemKey(item)] += int(item.Amount)
+	}
+	if old.ID != 0 {
+		tx.balance[itemKey(old)] -= int(old.Amount)
+	}
+	tx.changes = append(tx.changes, slotChange{window: window, slot: slot, old: old})
+	*target = item
+	pi.transaction = nil
+	for _, n := range tx.balance {
+		if n != 0 { // Removed items may be put on other slots by following changes
+			pi.transaction = tx
+			break
+		}
+	}
+	return nil
+}
+
+// ExpireTransaction ends pending slot changes if TransactionTimeout has passed.
+// If the changes create items in total, they are reverted and reverted slots are resent.
+func (pi *PlayerInventory) ExpireTransaction(now time.Time) {
+	tx := pi.transaction
+	if tx == nil || now.Sub(tx.started) < TransactionTimeout {
+		return
+	}
+	pi.transaction = nil
+	created := false
+	for _, n := range tx.balance {
+		if n > 0 {
+			created = true
+			break
+		}
+	}
+	if !created {
+		return
+	}
+	for j := len(tx.changes) - 1; j >= 0; j-- {
+		c := tx.changes[j]
+		*pi.Slot(c.window, c.slot) = c.old
+	}
+	for _, c := range tx.changes {
+		pi.resendSlot(c.window, c.slot)
+	}
+}
+
+// resendSlot sends the slot content to the holder, to correct client-side inventory.
+func (pi *PlayerInventory) resendSlot(window byte, slot int) {
+	target := pi.Slot(window, slot)
+	if pi.Holder == nil || target == nil {
+		return
 	}
+	item := *target
+	pi.Holder.SendPacket(&ContainerSetSlot{
+		Windowid: window,
+		Slot:     uint16(slot),
+		Item:     &item,
+	})
 }
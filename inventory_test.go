@@ -0,0 +1,234 @@
+package highmc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minero/minero/proto/nbt"
+)
+
+func TestSetSlotSurvival(t *testing.T) {
+	inv := make(Inventory, 4)
+	inv[0] = Item{ID: Stone, Amount: 10}
+	inv[1] = Item{ID: Dirt, Amount: 5}
+	pi := &PlayerInventory{Inventory: &inv}
+	if err := pi.SetSlot(InventoryWindow, 0, Item{ID: Dirt, Amount: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if pi.transaction == nil {
+		t.Fatal("Swap should be pending after the first change")
+	}
+	if err := pi.SetSlot(InventoryWindow, 1, Item{ID: Stone, Amount: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if pi.transaction != nil || inv[0].ID != Dirt || inv[1].ID != Stone {
+		t.Fatal("Swap is not applied:", inv)
+	}
+	if err := pi.SetSlot(InventoryWindow, 2, Item{ID: DiamondSword, Amount: 1}); err == nil || inv[2].ID != 0 {
+		t.Fatal("Item injection is accepted")
+	}
+
+	// Copying without clearing the source is reverted after timeout
+	if err := pi.SetSlot(InventoryWindow, 3, Item{ID: Stone, Amount: 10}); err != nil {
+		t.Fatal(err)
+	}
+	pi.ExpireTransaction(time.Now().Add(TransactionTimeout))
+	if inv[3].ID != 0 || inv[1].Amount != 10 {
+		t.Fatal("Duplication is not reverted:", inv)
+	}
+
+	// Splitting a stack
+	if pi.SetSlot(InventoryWindow, 1, Item{ID: Stone, Amount: 4}) != nil || pi.SetSlot(InventoryWindow, 3, Item{ID: Stone, Amount: 6}) != nil {
+		t.Fatal("Stack split is rejected:", inv)
+	}
+	if pi.transaction != nil {
+		t.Fatal("Stack split should not be pending")
+	}
+}
+
+func TestSetSlotChainedDuplication(t *testing.T) {
+	inv := make(Inventory, 4)
+	inv[0] = Item{ID: Diamond, Amount: 64}
+	pi := &PlayerInventory{Inventory: &inv}
+	if err := pi.SetSlot(InventoryWindow, 1, Item{ID: Diamond, Amount: 64}); err != nil {
+		t.Fatal("Moving the stack should be allowed until the source is cleared:", err)
+	}
+	if err := pi.SetSlot(InventoryWindow, 2, Item{ID: Diamond, Amount: 64}); err == nil {
+		t.Fatal("Pending items are counted as existing items")
+	}
+	if err := pi.SetSlot(InventoryWindow, 2, Item{ID: Diamond, Amount: 1}); err == nil {
+		t.Fatal("Pending items are counted as existing items")
+	}
+	if err := pi.SetSlot(InventoryWindow, 0, Item{}); err != nil {
+		t.Fatal(err)
+	}
+	if pi.transaction != nil || inv[0].ID != 0 || inv[1].Amount != 64 || inv[2].ID != 0 {
+		t.Fatal("Move is not applied:", inv)
+	}
+}
+
+func TestPlayerTickExpiresTransaction(t *testing.T) {
+	inv := make(Inventory, 2)
+	inv[0] = Item{ID: Diamond, Amount: 64}
+	p := &player{inventory: &PlayerInventory{Inventory: &inv}}
+	if err := p.inventory.SetSlot(InventoryWindow, 1, Item{ID: Diamond, Amount: 64}); err != nil {
+		t.Fatal(err)
+	}
+	p.inventory.transaction.started = time.Now().Add(-TransactionTimeout)
+	p.tick()
+	if inv[1].ID != 0 || inv[0].Amount != 64 || p.inventory.transaction != nil {
+		t.Fatal("Tick does not revert expired transaction:", inv)
+	}
+}
+
+func TestInitContents(t *testing.T) {
+	p := testPlayer(NewServer(), testLevel(), GamemodeCreative)
+	p.mtuSize = 1400
+	p.inventory.Holder = p
+	p.inventory.Init()
+	if len(p.EncapsulatedChan) != 1 || len(p.inventory.Hotbars) != CreativeHotbarSize || len(*p.inventory.Inventory) != len(CreativeItems) {
+		t.Fatal("Creative players should get creative items only, with", CreativeHotbarSize, "hotbar slots")
+	}
+
+	p = testPlayer(NewServer(), testLevel(), GamemodeSurvival)
+	p.mtuSize = 1400
+	p.inventory.Holder = p
+	p.inventory.Init()
+	if len(p.EncapsulatedChan) != 2 || len(p.inventory.Hotbars) != HotbarSize || len(*p.inventory.Inventory) != PlayerInventorySize {
+		t.Fatal("Survival players should get inventory and armor contents, with", HotbarSize, "hotbar slots")
+	}
+}
+
+func TestInitSurvivalContents(t *testing.T) {
+	p := testPlayer(NewServer(), testLevel(), GamemodeSurvival)
+	p.mtuSize = 1400
+	p.inventory.Holder = p
+	p.inventory.Init()
+	pks := sentPackets(t, p)
+	if len(pks) != 2 {
+		t.Fatal("Expected inventory and armor contents, got", len(pks), "packets")
+	}
+	content, ok := pks[0].(*ContainerSetContent)
+	if !ok || content.WindowID != InventoryWindow || len(content.Slots) != PlayerInventorySize || len(content.Hotbar) != HotbarSize {
+		t.Fatal("Unexpected inventory contents:", pks[0])
+	}
+	for i, slot := range content.Slots {
+		if slot.ID != 0 {
+			t.Fatal("Slot", i, "is not empty:", slot)
+		}
+	}
+	for i, link := range content.Hotbar {
+		if link != uint32(i+HotbarSize) {
+			t.Fatal("Hotbar slot", i, "is linked to", link)
+		}
+	}
+	if armor, ok := pks[1].(*ContainerSetContent); !ok || armor.WindowID != ArmorWindow || len(armor.Slots) != 4 {
+		t.Fatal("Unexpected armor contents:", pks[1])
+	}
+}
+
+func TestInitSavedItems(t *testing.T) {
+	p := testPlayer(NewServer(), testLevel(), GamemodeSurvival)
+	p.mtuSize = 1400
+	p.inventory.Holder = p
+	inv := make(Inventory, PlayerInventorySize)
+	inv[0], inv[5] = Item{ID: Stone, Amount: 10}, Item{ID: DiamondSword, Amount: 1}
+	p.inventory.Inventory = &inv
+	p.inventory.Armor[1] = Item{ID: IronChestplate, Amount: 1}
+	p.inventory.Init()
+	pks := sentPackets(t, p)
+	if len(pks) != 2 {
+		t.Fatal("Expected inventory and armor contents, got", len(pks), "packets")
+	}
+	content, ok := pks[0].(*ContainerSetContent)
+	if !ok || content.WindowID != InventoryWindow || len(content.Slots) != PlayerInventorySize {
+		t.Fatal("Inventory contents are not sent first")
+	}
+	if content.Slots[0].ID != Stone || content.Slots[0].Amount != 10 || content.Slots[5].ID != DiamondSword || content.Slots[1].ID != 0 {
+		t.Fatal("Saved items are not sent on their slots")
+	}
+	armor, ok := pks[1].(*ContainerSetContent)
+	if !ok || armor.WindowID != ArmorWindow || len(armor.Slots) != len(p.inventory.Armor) || armor.Slots[1].ID != IronChestplate {
+		t.Fatal("Armor contents are not sent")
+	}
+}
+
+func TestHotbarLink(t *testing.T) {
+	p := testPlayer(NewServer(), testLevel(), GamemodeSurvival)
+	p.mtuSize = 1400
+	p.inventory.Holder = p
+	p.inventory.Init()
+	content := sentPackets(t, p)[0].(*ContainerSetContent)
+	if len(content.Hotbar) != HotbarSize {
+		t.Fatal("Unexpected hotbar length:", len(content.Hotbar))
+	}
+	for i, link := range content.Hotbar {
+		if link != uint32(i+HotbarSize) {
+			t.Fatal("Hotbar slot", i, "is linked to", link)
+		}
+	}
+
+	p.inventory.HotbarLinks[0], p.inventory.HotbarLinks[1] = 20, HotbarUnlinked
+	p.SendPacket(&ContainerSetContent{WindowID: InventoryWindow, Slots: *p.inventory.Inventory, Hotbar: p.inventory.HotbarLink()})
+	content = sentPackets(t, p)[0].(*ContainerSetContent)
+	if content.Hotbar[0] != 20+HotbarSize || content.Hotbar[1] != ^uint32(0) || content.Hotbar[2] != 2+HotbarSize {
+		t.Fatal("Hotbar links are not mapped to inventory slots:", content.Hotbar)
+	}
+}
+
+func TestInventoryMarshal(t *testing.T) {
+	inv := make(Inventory, ChestSize)
+	inv[3] = Item{ID: Stone, Meta: 2, Amount: 64}
+	inv[20] = Item{ID: DiamondSword, Amount: 1, Compound: &nbt.Compound{Value: map[string]nbt.Tag{"Damage": &nbt.Int{Int32: 5}}}}
+	list := inv.Marshal()
+	if len(list.Value) != 2 {
+		t.Fatal("Expected 2 non-empty slots, got", len(list.Value))
+	}
+	decoded := make(Inventory, ChestSize)
+	decoded[0] = Item{ID: Dirt, Amount: 1}
+	if err := decoded.Unmarshal(list); err != nil {
+		t.Fatal(err)
+	}
+	if decoded[0].ID != 0 || decoded[3] != inv[3] || decoded[20].ID != DiamondSword {
+		t.Fatal("Slots are not restored:", decoded)
+	}
+	if tag, ok := decoded[20].Compound.Value["Damage"].(*nbt.Int); !ok || tag.Int32 != 5 {
+		t.Fatal("Item compound is not restored")
+	}
+
+	pi := &PlayerInventory{Inventory: &inv}
+	pi.Armor[1] = Item{ID: IronChestplate, Amount: 1}
+	restored := new(PlayerInventory)
+	if err := restored.Unmarshal(pi.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Armor[1].ID != IronChestplate || len(*restored.Inventory) != PlayerInventorySize || (*restored.Inventory)[3] != inv[3] {
+		t.Fatal("Player inventory is not restored")
+	}
+}
+
+func TestGiveCreative(t *testing.T) {
+	p := testPlayer(NewServer(), testLevel(), GamemodeCreative)
+	p.inventory.Holder = p
+	p.inventory.Hotbars = make([]Item, CreativeHotbarSize)
+	valid := CreativeItems[3]
+	ContainerSetSlot{Windowid: InventoryWindow, Slot: 0, Item: &valid}.Handle(p)
+	if p.inventory.Hotbars[0] != valid || len(p.EncapsulatedChan) != 0 {
+		t.Fatal("Creative item is not given")
+	}
+
+	invalid := Item{ID: Bedrock, Amount: 1, Meta: 99}
+	ContainerSetSlot{Windowid: InventoryWindow, Slot: 0, Item: &invalid}.Handle(p)
+	pks := sentPackets(t, p)
+	if p.inventory.Hotbars[0] != valid || len(pks) != 1 {
+		t.Fatal("Non-creative item is given")
+	}
+	if revert, ok := pks[0].(*ContainerSetSlot); !ok || revert.Slot != 0 || revert.Item.ID != valid.ID || revert.Item.Meta != valid.Meta {
+		t.Fatal("Rejected slot is not reverted:", pks[0])
+	}
+
+	p.Gamemode = GamemodeSurvival
+	if err := p.inventory.GiveCreative(1, valid); err == nil {
+		t.Fatal("Creative item is given to survival player")
+	}
+}
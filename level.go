@@ -1,8 +1,15 @@
 package highmc
 
 import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
 	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // BlockPos is a type for x-y-z block coordinates.
@@ -11,12 +18,57 @@ type BlockPos struct {
 	Y    byte
 }
 
+// Side returns the position of adjacent block on given side.
+// It returns false if the side is invalid, or the position is out of level height.
+func (pos BlockPos) Side(side byte) (BlockPos, bool) {
+	switch side {
+	case SideDown:
+		if pos.Y == 0 {
+			return pos, false
+		}
+		pos.Y--
+	case SideUp:
+		if pos.Y >= WorldHeight-1 {
+			return pos, false
+		}
+		pos.Y++
+	case SideNorth:
+		pos.Z--
+	case SideSouth:
+		pos.Z++
+	case SideWest:
+		pos.X--
+	case SideEast:
+		pos.X++
+	default:
+		return pos, false
+	}
+	return pos, true
+}
+
+// Vector3 returns the position of the block's minimum corner as a vector.
+func (pos BlockPos) Vector3() Vector3 {
+	return Vector3{X: float32(pos.X), Y: float32(pos.Y), Z: float32(pos.Z)}
+}
+
+// Add returns the position moved by given offset. Y is clamped to level height.
+func (pos BlockPos) Add(dx int32, dy int8, dz int32) BlockPos {
+	y := int(pos.Y) + int(dy)
+	if y < 0 {
+		y = 0
+	} else if y > WorldHeight-1 {
+		y = WorldHeight - 1
+	}
+	return BlockPos{X: pos.X + dx, Y: byte(y), Z: pos.Z + dz}
+}
+
 // LevelReader is a level interface which allows Get* operations.
 type LevelReader interface {
 	Available(BlockPos) bool
 	Get(BlockPos) Block
 	GetID(BlockPos) byte
 	GetMeta(BlockPos) byte
+	GetLight(BlockPos) byte
 }
 
 // LevelWriter is a level interface which allows both Get/Set operations.
@@ -45,11 +97,21 @@ func NewStagedWriter(wrap LevelReadWriter) *StagedWriter {
 }
 
 // Commit batches all staged write operations and flushes the stage.
-func (sw *StagedWriter) Commit() {
+// It returns records of committed blocks, which can be used for UpdateBlock packet.
+func (sw *StagedWriter) Commit() []BlockRecord {
+	records := make([]BlockRecord, 0, len(sw.stage))
 	for pos, block := range sw.stage {
 		sw.wrap.Set(pos, block)
+		records = append(records, BlockRecord{
+			X:     uint32(pos.X),
+			Y:     pos.Y,
+			Z:     uint32(pos.Z),
+			Block: block,
+			Flags: UpdateAllPriority,
+		})
 	}
 	sw.stage = make(map[BlockPos]Block)
+	return records
 }
 
 // Set wraps Level.Set method.
@@ -105,25 +167,245 @@ type chunkRequest struct {
 type Level struct {
 	LoadedChunks map[ChunkPos]*Chunk
 
-	Name     string
-	Server   *Server
-	Provider LevelProvider
+	Name      string
+	Server    *Server
+	Provider  LevelProvider
+	Generator Generator // Generates chunks not saved on provider. If nil, FlatGenerator is used.
+
+	// RandomTickSpeed is a count of random block ticks on every 16*16*16 chunk sections per tick.
+	// Set 0 to disable random ticks.
+	RandomTickSpeed int
+
+	// TileEntities are block entities on the level, which are updated every tick.
+	TileEntities map[BlockPos]TileEntity
+
+	// Items are dropped item entities on the level, by entity ID. Access them with the level locked.
+	Items map[uint64]*ItemEntity
+
+	// Difficulty is a difficulty of the level, sent to players on spawn.
+	// Use SetDifficulty to change it while players are online.
+	Difficulty uint32
+
+	// MaxLoadedChunks is a memory budget of the level, in chunks. 0 means unlimited.
+	// If exceeded, least recently used chunks without references are saved and evicted.
+	MaxLoadedChunks int
+	accessClock     uint64
+
+	// AutosaveInterval is how often modified chunks are saved with level provider.
+	// If zero, DefaultAutosaveInterval is used. Negative value disables autosave.
+	AutosaveInterval time.Duration
+	closed           chan struct{}
+
+	// ChunkUnloadDelay is a grace period before unloading chunks without references.
+	// If zero, DefaultChunkUnloadDelay is used. Negative value disables unloading.
+	ChunkUnloadDelay time.Duration
+	pendingRefs      map[ChunkPos]uint64 // References on chunks not loaded yet
 
 	roChan       chan func(LevelReader)
 	rwChan       chan func(LevelReadWriter)
 	chunkRequest chan chunkRequest
 	mutex        *sync.RWMutex
+
+	currentTick      uint64
+	scheduledUpdates map[BlockPos]uint64 // Position: tick to be updated
+	updateMutex      *sync.Mutex
 }
 
+// TileEntity is a block entity with its own state, such as brewing stands.
+type TileEntity interface {
+	// Tick is called on every level ticks, with level write-locked.
+	Tick(lv *Level)
+}
+
+// DefaultRandomTickSpeed is a default value for Level.RandomTickSpeed.
+const DefaultRandomTickSpeed = 3
+
+// DefaultAutosaveInterval is a default value for Level.AutosaveInterval.
+const DefaultAutosaveInterval = time.Minute * 5
+
+// DefaultChunkUnloadDelay is a default value for Level.ChunkUnloadDelay.
+const DefaultChunkUnloadDelay = time.Second * 30
+
 // Init initializes the level.
+// If the level has no provider, chunks are always generated and never saved.
 func (lv *Level) Init() {
 	lv.LoadedChunks = make(map[ChunkPos]*Chunk)
-	lv.Provider.Init("default")
+	if lv.Provider != nil {
+		lv.Provider.Init("default")
+	}
+	lv.RandomTickSpeed = DefaultRandomTickSpeed
+	lv.scheduledUpdates = make(map[BlockPos]uint64)
+	lv.TileEntities = make(map[BlockPos]TileEntity)
+	lv.Items = make(map[uint64]*ItemEntity)
+	lv.updateMutex = new(sync.Mutex)
 
 	lv.roChan = make(chan func(LevelReader), chanBufsize)
 	lv.rwChan = make(chan func(LevelReadWriter), chanBufsize)
 	lv.chunkRequest = make(chan chunkRequest, chanBufsize)
 	lv.mutex = new(sync.RWMutex)
+	if lv.Generator == nil {
+		lv.Generator = DefaultFlatGenerator
+	}
+	if lv.AutosaveInterval == 0 {
+		lv.AutosaveInterval = DefaultAutosaveInterval
+	}
+	if lv.ChunkUnloadDelay == 0 {
+		lv.ChunkUnloadDelay = DefaultChunkUnloadDelay
+	}
+	lv.pendingRefs = make(map[ChunkPos]uint64)
+	lv.closed = make(chan struct{})
+	go lv.process()
+	if lv.Provider != nil && lv.AutosaveInterval > 0 {
+		go lv.autosave()
+	}
+	if lv.ChunkUnloadDelay > 0 {
+		go lv.sweep()
+	}
+}
+
+func (lv *Level) sweep() {
+	ticker := time.NewTicker(lv.ChunkUnloadDelay / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lv.closed:
+			return
+		case <-ticker.C:
+			lv.SweepChunks(lv.ChunkUnloadDelay)
+		}
+	}
+}
+
+// SweepChunks unloads chunks which have no references for longer than delay.
+// Modified chunks are saved with level provider first. If the level has no provider, modified chunks are kept loaded.
+// Level should not be locked.
+func (lv *Level) SweepChunks(delay time.Duration) {
+	lv.Lock()
+	defer lv.Unlock()
+	now := time.Now()
+	for pos, chunk := range lv.LoadedChunks {
+		if atomic.LoadUint64(&chunk.Refs) > 0 || now.Sub(chunk.released) < delay {
+			continue
+		}
+		if chunk.dirty && lv.Provider == nil {
+			continue
+		}
+		if err := lv.unloadChunk(pos, chunk); err != nil {
+			log.Println("Error while unloading chunk:", err)
+		}
+	}
+}
+
+// UnloadChunk saves the chunk if modified, and removes it from loaded chunks.
+// It fails if the chunk is referenced by players. Level should not be locked.
+func (lv *Level) UnloadChunk(pos ChunkPos) error {
+	lv.Lock()
+	defer lv.Unlock()
+	chunk, ok := lv.LoadedChunks[pos]
+	if !ok {
+		return nil
+	}
+	if refs := atomic.LoadUint64(&chunk.Refs); refs > 0 {
+		return fmt.Errorf("chunk %d:%d has %d references", pos.X, pos.Z, refs)
+	}
+	return lv.unloadChunk(pos, chunk)
+}
+
+func (lv *Level) unloadChunk(pos ChunkPos, chunk *Chunk) error {
+	if chunk.dirty && lv.Provider != nil {
+		if err := lv.Provider.WriteChunk(pos, chunk); err != nil {
+			return err
+		}
+		chunk.dirty = false
+	}
+	delete(lv.LoadedChunks, pos)
+	return nil
+}
+
+// RefChunk adds a reference on the chunk, so it won't be unloaded.
+// The chunk does not need to be loaded: the reference is applied once it is loaded. Level should not be locked.
+func (lv *Level) RefChunk(pos ChunkPos) {
+	lv.Lock()
+	defer lv.Unlock()
+	if chunk, ok := lv.LoadedChunks[pos]; ok {
+		atomic.AddUint64(&chunk.Refs, 1)
+		return
+	}
+	lv.pendingRefs[pos]++
+}
+
+// UnrefChunk removes a reference added with RefChunk. Level should not be locked.
+func (lv *Level) UnrefChunk(pos ChunkPos) {
+	lv.Lock()
+	defer lv.Unlock()
+	chunk, ok := lv.LoadedChunks[pos]
+	if !ok {
+		if lv.pendingRefs[pos] > 1 {
+			lv.pendingRefs[pos]--
+		} else {
+			delete(lv.pendingRefs, pos)
+		}
+		return
+	}
+	if atomic.LoadUint64(&chunk.Refs) == 0 {
+		return
+	}
+	if atomic.AddUint64(&chunk.Refs, ^uint64(0)) == 0 {
+		chunk.released = time.Now()
+	}
+}
+
+func (lv *Level) autosave() {
+	ticker := time.NewTicker(lv.AutosaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lv.closed:
+			return
+		case <-ticker.C:
+			if err := lv.Save(); err != nil {
+				log.Println("Error while autosaving level", lv.Name+":", err)
+			}
+		}
+	}
+}
+
+// Save saves modified chunks with level provider. Level should not be locked.
+func (lv *Level) Save() error {
+	if lv.Provider == nil {
+		return nil
+	}
+	lv.Lock()
+	defer lv.Unlock()
+	dirty := make(map[ChunkPos]*Chunk)
+	for pos, chunk := range lv.LoadedChunks {
+		if chunk.dirty {
+			dirty[pos] = chunk
+		}
+	}
+	if len(dirty) == 0 {
+		return nil
+	}
+	if err := lv.Provider.SaveAll(dirty); err != nil {
+		return err
+	}
+	for _, chunk := range dirty {
+		chunk.dirty = false
+	}
+	return nil
+}
+
+// Close stops autosave, and saves modified chunks.
+func (lv *Level) Close() error {
+	if lv.closed != nil {
+		select {
+		case <-lv.closed:
+			return nil
+		default:
+		}
+		close(lv.closed)
+	}
+	return lv.Save()
 }
 
 func (lv *Level) process() {
@@ -143,41 +425,239 @@ func (lv *Level) process() {
 				lv.RW(callback)
 		*/
 		case req := <-lv.chunkRequest:
+			lv.RLock()
+			chunk := lv.GetChunk(req.pos)
+			lv.RUnlock()
+			if chunk != nil { // Loaded after the request was sent
+				req.reply <- chunk
+				continue
+			}
 			replyChans[req.pos] = append(replyChans[req.pos], req.reply)
+			if len(replyChans[req.pos]) > 1 { // Already requested to workers
+				continue
+			}
 			req.reply = replyChan
+			go func(req chunkRequest) { // Workers may be blocked on replyChan
+				requestChan <- req
+			}(req)
 		case rep := <-replyChan:
-			if chs, ok := replyChans[rep.Position]; ok {
-				for _, ch := range chs {
-					ch <- rep
-				}
-			} else {
+			chs, ok := replyChans[rep.Position]
+			if !ok {
 				panic("Reply chunk position is invalid")
 			}
+			lv.Lock()
+			if loaded, ok := lv.LoadedChunks[rep.Position]; ok { // Added while loading: it may have unsaved changes
+				rep = loaded
+			} else {
+				lv.AddChunk(rep.Position, rep)
+			}
+			lv.Unlock()
+			for _, ch := range chs {
+				ch <- rep
+			}
+			delete(replyChans, rep.Position)
 		}
 	}
 }
 
 func (lv *Level) chunkWorker(request chan chunkRequest) {
 	for req := range request {
-                if dir, ok := lv.Provider.Loadable(req.pos); ok { // file exists
-			chunk, err := lv.Provider.LoadChunk(req.pos, dir)
-			if err != nil {
-				panic("Chunk load error")
+		chunk := lv.loadChunk(req.pos)
+		if chunk == nil {
+			chunk = lv.Generator.Generate(req.pos)
+			if lv.Provider == nil {
+				chunk.dirty = false // Can't be saved, so it will be generated again after unload
 			}
-			req.reply <- chunk
-		} else {
-			// Create chunk
-			req.reply <- nil // TODO
 		}
+		chunk.Position = req.pos
+		req.reply <- chunk
 	}
 }
 
+// loadChunk loads the chunk from level provider.
+// It returns nil if the level has no provider, the chunk is not saved, or loading fails.
+func (lv *Level) loadChunk(pos ChunkPos) *Chunk {
+	if lv.Provider == nil {
+		return nil
+	}
+	dir, ok := lv.Provider.Loadable(pos)
+	if !ok {
+		return nil
+	}
+	chunk, err := lv.Provider.LoadChunk(pos, dir)
+	if err != nil {
+		log.Println("Chunk load error:", err)
+		return nil
+	}
+	chunk.dirty = false // Same with saved one
+	return chunk
+}
+
+// ScheduleUpdate schedules block update on given position after delay ticks.
+// If the update is already scheduled on the position, earlier one is kept.
+func (lv *Level) ScheduleUpdate(pos BlockPos, delay uint64) {
+	lv.updateMutex.Lock()
+	defer lv.updateMutex.Unlock()
+	due := atomic.LoadUint64(&lv.currentTick) + delay
+	if t, ok := lv.scheduledUpdates[pos]; !ok || t > due {
+		lv.scheduledUpdates[pos] = due
+	}
+}
+
+// Tick processes scheduled block updates, random ticks on loaded chunks, and tile entities.
+// Changes from block handlers are batched with StagedWriter, and returned as block records to broadcast.
+func (lv *Level) Tick() []BlockRecord {
+	if lv.LoadedChunks == nil { // Not initialized
+		return nil
+	}
+	tick := atomic.AddUint64(&lv.currentTick, 1)
+	var due []BlockPos
+	lv.updateMutex.Lock()
+	for pos, t := range lv.scheduledUpdates {
+		if t <= tick {
+			due = append(due, pos)
+			delete(lv.scheduledUpdates, pos)
+		}
+	}
+	lv.updateMutex.Unlock()
+
+	lv.mutex.Lock()
+	defer lv.mutex.Unlock()
+	sw := NewStagedWriter(lv)
+	for _, pos := range due {
+		if !lv.Available(pos) {
+			continue
+		}
+		block := lv.Get(pos)
+		if handler, ok := ScheduledUpdateHandlers[block.ID]; ok {
+			handler(pos, block, lv, sw)
+		}
+	}
+	if lv.RandomTickSpeed > 0 {
+		for cpos, chunk := range lv.LoadedChunks {
+			for section := 0; section < 8; section++ {
+				for i := 0; i < lv.RandomTickSpeed; i++ {
+					x, y, z := byte(rand.Intn(16)), byte(section<<4|rand.Intn(16)), byte(rand.Intn(16))
+					id := chunk.GetBlock(x, y, z)
+					if handler, ok := RandomTickHandlers[id]; ok {
+						handler(BlockPos{
+							X: cpos.X<<4 | int32(x),
+							Y: y,
+							Z: cpos.Z<<4 | int32(z),
+						}, Block{
+							ID:   id,
+							Meta: chunk.GetBlockMeta(x, y, z),
+						}, lv, sw)
+					}
+				}
+			}
+		}
+	}
+	for _, te := range lv.TileEntities {
+		te.Tick(lv)
+	}
+	return sw.Commit()
+}
+
+// SetDifficulty changes the difficulty of the level, and sends it to players on the level.
+// It must not be called on server goroutine.
+func (lv *Level) SetDifficulty(difficulty uint32) {
+	lv.Difficulty = difficulty
+	if lv.Server == nil {
+		return
+	}
+	lv.Server.BroadcastPacket(&SetDifficulty{Difficulty: difficulty}, func(p *player) bool {
+		return p.Level == lv
+	})
+}
+
+// Peaceful returns whether the level is on DifficultyPeaceful.
+// The server has no mobs or hunger yet, so it only affects clients.
+func (lv *Level) Peaceful() bool {
+	return lv.Difficulty == DifficultyPeaceful
+}
+
 // Available returns whether given block is loaded.
 func (lv *Level) Available(pos BlockPos) bool {
 	_, ok := lv.LoadedChunks[GetChunkPos(pos)]
 	return ok
 }
 
+// GetChunk returns loaded chunk on given position, or nil if it is not loaded.
+// The chunk is marked as recently used. Level should be locked(read or write).
+func (lv *Level) GetChunk(pos ChunkPos) *Chunk {
+	chunk, ok := lv.LoadedChunks[pos]
+	if !ok {
+		return nil
+	}
+	atomic.StoreUint64(&chunk.accessed, atomic.AddUint64(&lv.accessClock, 1))
+	return chunk
+}
+
+// AddChunk adds loaded chunk to the level, and evicts chunks if MaxLoadedChunks is exceeded.
+// Level should be write-locked.
+func (lv *Level) AddChunk(pos ChunkPos, chunk *Chunk) {
+	chunk.Position = pos
+	chunk.released = time.Now()
+	atomic.StoreUint64(&chunk.accessed, atomic.AddUint64(&lv.accessClock, 1))
+	if refs, ok := lv.pendingRefs[pos]; ok {
+		atomic.AddUint64(&chunk.Refs, refs)
+		delete(lv.pendingRefs, pos)
+	}
+	lv.LoadedChunks[pos] = chunk
+	if lv.MaxLoadedChunks > 0 && len(lv.LoadedChunks) > lv.MaxLoadedChunks {
+		lv.evictChunks(len(lv.LoadedChunks) - lv.MaxLoadedChunks)
+	}
+}
+
+// evictChunks unloads n least recently used chunks without references.
+// Modified chunks are saved with level provider before eviction, and kept loaded if saving fails.
+// Level should be write-locked.
+func (lv *Level) evictChunks(n int) {
+	candidates := make([]*Chunk, 0, len(lv.LoadedChunks))
+	for _, chunk := range lv.LoadedChunks {
+		if atomic.LoadUint64(&chunk.Refs) == 0 {
+			candidates = append(candidates, chunk)
+		}
+	}
+	sort.Sort(chunksByAccess(candidates))
+	for _, chunk := range candidates {
+		if n <= 0 {
+			break
+		}
+		if chunk.dirty && lv.Provider != nil {
+			if err := lv.Provider.WriteChunk(chunk.Position, chunk); err != nil {
+				log.Println("Error while saving evicted chunk:", err)
+				continue
+			}
+			chunk.dirty = false
+		}
+		delete(lv.LoadedChunks, chunk.Position)
+		n--
+	}
+}
+
+type chunksByAccess []*Chunk
+
+func (c chunksByAccess) Len() int      { return len(c) }
+func (c chunksByAccess) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c chunksByAccess) Less(i, j int) bool {
+	return atomic.LoadUint64(&c[i].accessed) < atomic.LoadUint64(&c[j].accessed)
+}
+
+// EachLoadedChunk calls fn for every loaded chunks, holding read lock of the level.
+// Use this instead of ranging LoadedChunks directly from other goroutines. Do not write to the level in fn.
+func (lv *Level) EachLoadedChunk(fn func(ChunkPos, *Chunk)) {
+	if lv.LoadedChunks == nil { // Not initialized
+		return
+	}
+	lv.mutex.RLock()
+	defer lv.mutex.RUnlock()
+	for pos, chunk := range lv.LoadedChunks {
+		fn(pos, chunk)
+	}
+}
+
 // Lock is a wrapping func for RWMutex.Lock()
 func (lv *Level) Lock() {
 	lv.mutex.Lock()
@@ -199,6 +679,7 @@ func (lv *Level) RUnlock() {
 }
 
 // Get returns Block from level.
+// Like other Get/Set methods, blocks above WorldHeight are air and can't be set.
 func (lv *Level) Get(p BlockPos) Block {
 	return Block{
 		ID:   lv.LoadedChunks[GetChunkPos(p)].GetBlock(byte(p.X&0xf), p.Y, byte(p.Z&0xf)),
@@ -216,6 +697,46 @@ func (lv *Level) GetMeta(p BlockPos) byte {
 	return lv.LoadedChunks[GetChunkPos(p)].GetBlockMeta(byte(p.X&0xf), p.Y, byte(p.Z&0xf))
 }
 
+// GetLight returns light level from level, which is brighter one of block light and sky light.
+func (lv *Level) GetLight(p BlockPos) byte {
+	chunk := lv.LoadedChunks[GetChunkPos(p)]
+	x, z := byte(p.X&0xf), byte(p.Z&0xf)
+	bl, sl := chunk.GetBlockLight(x, p.Y, z), chunk.GetBlockSkyLight(x, p.Y, z)
+	if bl > sl {
+		return bl
+	}
+	return sl
+}
+
+// CollidesAt returns whether any solid block intersects with given box.
+// Blocks in unloaded chunks are considered solid, and the box is clipped to level height.
+// Level should be locked(read or write).
+func (lv *Level) CollidesAt(bb AABB) bool {
+	minY, maxY := math.Floor(float64(bb.Min.Y)), math.Ceil(float64(bb.Max.Y))-1
+	if minY < 0 {
+		minY = 0
+	}
+	if maxY > WorldHeight-1 {
+		maxY = WorldHeight - 1
+	}
+	minX, maxX := int32(math.Floor(float64(bb.Min.X))), int32(math.Ceil(float64(bb.Max.X)))-1
+	minZ, maxZ := int32(math.Floor(float64(bb.Min.Z))), int32(math.Ceil(float64(bb.Max.Z)))-1
+	for x := minX; x <= maxX; x++ {
+		for z := minZ; z <= maxZ; z++ {
+			for y := int(minY); y <= int(maxY); y++ {
+				pos := BlockPos{X: x, Y: byte(y), Z: z}
+				if !lv.Available(pos) {
+					return true
+				}
+				if lv.Get(pos).IsSolid() && BlockAABB(pos).Intersects(bb) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 // Set sets block ID/Meta to level.
 func (lv *Level) Set(p BlockPos, b Block) {
 	lv.LoadedChunks[GetChunkPos(p)].SetBlock(byte(p.X&0xf), p.Y, byte(p.Z&0xf), b.ID)
@@ -229,7 +750,7 @@ func (lv *Level) SetID(p BlockPos, i byte) {
 
 // SetMeta sets block Meta to level.
 func (lv *Level) SetMeta(p BlockPos, m byte) {
-	lv.LoadedChunks[GetChunkPos(p)].SetBlock(byte(p.X&0xf), p.Y, byte(p.Z&0xf), m)
+	lv.LoadedChunks[GetChunkPos(p)].SetBlockMeta(byte(p.X&0xf), p.Y, byte(p.Z&0xf), m)
 }
 
 // RO executes given level callback in Read-Only mode.
@@ -258,8 +779,16 @@ func (lv *Level) RWAsync(callback func(LevelReadWriter)) {
 }
 */
 
-// CreateChunk creates the chunk on given ChunkPos.
+// CreateChunk returns the chunk on given ChunkPos.
+// If the chunk is not loaded, it is loaded from provider or generated with level generator, and added to loaded chunks.
+// Level should not be locked.
 func (lv *Level) CreateChunk(pos ChunkPos) *Chunk {
+	lv.RLock()
+	chunk := lv.GetChunk(pos)
+	lv.RUnlock()
+	if chunk != nil {
+		return chunk
+	}
 	ch := make(chan *Chunk, 1)
 	lv.chunkRequest <- chunkRequest{
 		pos:   pos,
@@ -0,0 +1,327 @@
+package highmc
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLevel returns a level without goroutines, with an empty chunk on (0, 0) loaded.
+func testLevel() *Level {
+	lv := &Level{
+		LoadedChunks:     map[ChunkPos]*Chunk{},
+		RandomTickSpeed:  3,
+		mutex:            new(sync.RWMutex),
+		updateMutex:      new(sync.Mutex),
+		scheduledUpdates: map[BlockPos]uint64{},
+		Items:            map[uint64]*ItemEntity{},
+		TileEntities:     map[BlockPos]TileEntity{},
+	}
+	c := new(Chunk)
+	for i := range c.SkyLightData {
+		c.SkyLightData[i] = 0xff
+	}
+	lv.LoadedChunks[ChunkPos{}] = c
+	return lv
+}
+
+// testProvider is a LevelProvider which records saved chunks on memory.
+type testProvider struct {
+	written map[ChunkPos]*Chunk // Chunks saved with WriteChunk
+	saved   map[ChunkPos]*Chunk // Chunks passed to last SaveAll
+}
+
+func newTestProvider() *testProvider {
+	return &testProvider{written: map[ChunkPos]*Chunk{}}
+}
+
+func (tp *testProvider) Init(string) {}
+
+func (tp *testProvider) Loadable(ChunkPos) (string, bool) { return "", false }
+
+func (tp *testProvider) LoadChunk(ChunkPos, string) (*Chunk, error) { return nil, nil }
+
+func (tp *testProvider) WriteChunk(pos ChunkPos, chunk *Chunk) error {
+	tp.written[pos] = chunk
+	return nil
+}
+
+func (tp *testProvider) SaveAll(chunks map[ChunkPos]*Chunk) error {
+	tp.saved = chunks
+	return nil
+}
+
+func TestChunkRequestLoaded(t *testing.T) {
+	lv := &Level{ChunkUnloadDelay: -1}
+	lv.Init()
+	defer lv.Close()
+	pos := ChunkPos{X: 7, Z: 7}
+	chunk := new(Chunk)
+	chunk.dirty = true
+	lv.Lock()
+	lv.AddChunk(pos, chunk)
+	lv.Unlock()
+	reply := make(chan *Chunk, 1)
+	lv.chunkRequest <- chunkRequest{pos: pos, reply: reply} // Requested before the chunk was loaded
+	if c := <-reply; c != chunk || lv.GetChunk(pos) != chunk {
+		t.Fatal("Loaded chunk is overwritten by chunk request")
+	}
+}
+
+func TestScheduledUpdate(t *testing.T) {
+	ScheduledUpdateHandlers[byte(WheatBlock)] = func(pos BlockPos, block Block, rd LevelReader, wr LevelWriter) {
+		wr.SetMeta(pos, block.Meta+1)
+	}
+	defer delete(ScheduledUpdateHandlers, byte(WheatBlock))
+	lv := testLevel()
+	lv.RandomTickSpeed = 0
+	pos := BlockPos{X: 3, Y: 11, Z: 3}
+	lv.Set(pos, Block{ID: byte(WheatBlock)})
+	lv.ScheduleUpdate(pos, 5)
+	lv.ScheduleUpdate(pos, 10) // Earlier one is kept
+	for tick := 1; tick < 5; tick++ {
+		if records := lv.Tick(); len(records) != 0 || lv.GetMeta(pos) != 0 {
+			t.Fatal("Crop advanced before scheduled tick:", tick)
+		}
+	}
+	if records := lv.Tick(); len(records) != 1 || lv.GetMeta(pos) != 1 {
+		t.Fatal("Crop did not advance on scheduled tick")
+	}
+	for tick := 0; tick < 10; tick++ {
+		lv.Tick()
+	}
+	if lv.GetMeta(pos) != 1 {
+		t.Fatal("Update is run more than once")
+	}
+}
+
+func TestEachLoadedChunk(t *testing.T) { // Run with -race
+	lv := testLevel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for x := int32(1); x <= 1000; x++ {
+			lv.Lock()
+			lv.AddChunk(ChunkPos{X: x}, new(Chunk))
+			delete(lv.LoadedChunks, ChunkPos{X: x - 1})
+			lv.Unlock()
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		n := 0
+		lv.EachLoadedChunk(func(ChunkPos, *Chunk) { n++ })
+		if n != 1 && n != 2 {
+			t.Fatal("Unexpected loaded chunks while iterating:", n)
+		}
+	}
+	wg.Wait()
+}
+
+func TestChunkBudget(t *testing.T) {
+	lv := testLevel()
+	delete(lv.LoadedChunks, ChunkPos{})
+	provider := newTestProvider()
+	lv.Provider = provider
+	lv.MaxLoadedChunks = 3
+	referenced, dirty := new(Chunk), new(Chunk)
+	referenced.Refs = 1
+	dirty.SetBlock(0, 0, 0, Stone.Block())
+	lv.AddChunk(ChunkPos{X: 0}, referenced)
+	lv.AddChunk(ChunkPos{X: 1}, dirty)
+	lv.AddChunk(ChunkPos{X: 2}, new(Chunk))
+	lv.GetChunk(ChunkPos{X: 1}) // Chunk 2 is least recently used now
+	lv.AddChunk(ChunkPos{X: 3}, new(Chunk))
+	if _, ok := lv.LoadedChunks[ChunkPos{X: 2}]; ok || len(lv.LoadedChunks) != 3 {
+		t.Fatal("Least recently used chunk is not evicted")
+	}
+	lv.AddChunk(ChunkPos{X: 4}, new(Chunk))
+	if _, ok := lv.LoadedChunks[ChunkPos{X: 1}]; ok || provider.written[ChunkPos{X: 1}] != dirty {
+		t.Fatal("Modified chunk is not evicted and saved")
+	}
+	if _, ok := provider.written[ChunkPos{X: 2}]; ok {
+		t.Fatal("Unmodified chunk is saved")
+	}
+	if lv.LoadedChunks[ChunkPos{X: 0}] != referenced {
+		t.Fatal("Referenced chunk is evicted")
+	}
+}
+
+func TestChunkUnload(t *testing.T) {
+	lv := &Level{ChunkUnloadDelay: -1}
+	lv.Init()
+	defer lv.Close()
+	pos := ChunkPos{X: 5, Z: 5}
+	loaded := func() *Chunk {
+		lv.RLock()
+		defer lv.RUnlock()
+		return lv.GetChunk(pos)
+	}
+	lv.RefChunk(pos) // Before the chunk is loaded
+	chunk := lv.CreateChunk(pos)
+	if chunk.Refs != 1 {
+		t.Fatal("Pending reference is not applied on load:", chunk.Refs)
+	}
+	if err := lv.UnloadChunk(pos); err == nil {
+		t.Fatal("Referenced chunk is unloaded")
+	}
+	lv.SweepChunks(0)
+	if loaded() == nil {
+		t.Fatal("Referenced chunk is swept")
+	}
+	lv.UnrefChunk(pos)
+	lv.SweepChunks(time.Hour)
+	if loaded() == nil {
+		t.Fatal("Chunk is swept within grace period")
+	}
+	lv.SweepChunks(0)
+	if loaded() != nil {
+		t.Fatal("Chunk without viewers is not unloaded")
+	}
+	if reloaded := lv.CreateChunk(pos); reloaded == chunk || loaded() != reloaded {
+		t.Fatal("Unloaded chunk is not loaded again on demand")
+	}
+	lv.UnrefChunk(pos)
+	if refs := loaded().Refs; refs != 0 {
+		t.Fatal("Chunk references underflow:", refs)
+	}
+}
+
+func TestLevelSave(t *testing.T) {
+	lv := testLevel()
+	provider := newTestProvider()
+	lv.Provider = provider
+	lv.AddChunk(ChunkPos{X: 1}, new(Chunk))
+	lv.Set(BlockPos{X: 1, Y: 2, Z: 3}, Block{ID: Stone.Block()})
+	if err := lv.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.saved) != 1 || provider.saved[ChunkPos{}] != lv.LoadedChunks[ChunkPos{}] {
+		t.Fatal("Unexpected chunks are saved:", provider.saved)
+	}
+	provider.saved = nil
+	if err := lv.Save(); err != nil || provider.saved != nil {
+		t.Fatal("Saved chunk is saved again:", provider.saved)
+	}
+	lv.Set(BlockPos{X: 16, Y: 2, Z: 3}, Block{ID: Stone.Block()})
+	if err := lv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.saved) != 1 || provider.saved[ChunkPos{X: 1}] == nil {
+		t.Fatal("Modified chunk is not saved on close:", provider.saved)
+	}
+}
+
+func TestSetDifficulty(t *testing.T) {
+	s := NewServer()
+	s.Start()
+	s.GetDefaultLevel().Difficulty = DifficultyHard
+	p := loginPlayer(t, s, "Steve", 1)
+	s.Stop()
+	sent := false
+	for _, pk := range sentPackets(t, p) {
+		if difficulty, ok := pk.(*SetDifficulty); ok {
+			sent = difficulty.Difficulty == DifficultyHard
+		}
+	}
+	if !sent {
+		t.Fatal("Difficulty of the level is not sent on join")
+	}
+
+	s = NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	broadcasts := serveTest(s, stop)
+	lv := testLevel()
+	lv.Server = s
+	lv.SetDifficulty(DifficultyPeaceful)
+	req := <-broadcasts
+	if difficulty, ok := req.packet.(*SetDifficulty); !ok || difficulty.Difficulty != DifficultyPeaceful {
+		t.Fatal("SetDifficulty is not broadcasted:", req.packet)
+	}
+	if !req.filter(&player{Level: lv}) || req.filter(&player{Level: testLevel()}) {
+		t.Fatal("SetDifficulty is not filtered by level")
+	}
+	if !lv.Peaceful() {
+		t.Fatal("Level is not peaceful")
+	}
+}
+
+func TestBlockPosSide(t *testing.T) {
+	pos := BlockPos{X: 5, Y: 10, Z: 5}
+	for side, expected := range map[byte]BlockPos{
+		SideDown:  {X: 5, Y: 9, Z: 5},
+		SideUp:    {X: 5, Y: 11, Z: 5},
+		SideNorth: {X: 5, Y: 10, Z: 4},
+		SideSouth: {X: 5, Y: 10, Z: 6},
+		SideWest:  {X: 4, Y: 10, Z: 5},
+		SideEast:  {X: 6, Y: 10, Z: 5},
+	} {
+		if got, ok := pos.Side(side); !ok || got != expected {
+			t.Fatal("Unexpected position on side", side, ":", got)
+		}
+	}
+	for _, c := range []struct {
+		pos  BlockPos
+		side byte
+	}{
+		{BlockPos{Y: 0}, SideDown},
+		{BlockPos{Y: WorldHeight - 1}, SideUp},
+		{pos, 6},
+	} {
+		if _, ok := c.pos.Side(c.side); ok {
+			t.Fatal("Invalid side", c.side, "of", c.pos, "is accepted")
+		}
+	}
+}
+
+func TestBlockPosConversion(t *testing.T) {
+	pos := BlockPos{X: -17, Y: 5, Z: 33}
+	if pos.Vector3() != (Vector3{X: -17, Y: 5, Z: 33}) || pos.Vector3().BlockPos() != pos {
+		t.Fatal("Block position is not preserved:", pos.Vector3().BlockPos())
+	}
+	if moved := pos.Add(1, -10, -1); moved != (BlockPos{X: -16, Y: 0, Z: 32}) {
+		t.Fatal("Unexpected position below the world:", moved)
+	}
+	if moved := pos.Add(0, 127, 0); moved.Y != WorldHeight-1 {
+		t.Fatal("Unexpected position above the world:", moved)
+	}
+	for _, v := range []Vector3{{X: -0.1, Z: -16}, {X: -16.5, Z: 15.99}, {X: -17, Z: -33}, {X: 31.5, Z: 0}} {
+		expected := ChunkPos{X: int32(math.Floor(float64(v.X) / 16)), Z: int32(math.Floor(float64(v.Z) / 16))}
+		if got := GetChunkPos(v.BlockPos()); got != expected {
+			t.Fatal("Unexpected chunk position of", v, ":", got)
+		}
+	}
+}
+
+func TestCollidesAt(t *testing.T) {
+	lv := testLevel()
+	lv.LoadedChunks[ChunkPos{X: 1}] = new(Chunk)
+	stone := BlockPos{X: 15, Y: 10, Z: 3}
+	lv.Set(stone, Block{ID: Stone.Block()})
+	lv.Set(BlockPos{X: 16, Y: 10, Z: 3}, Block{ID: Water.Block()})
+	lv.Set(BlockPos{X: 14, Y: 10, Z: 3}, Block{ID: Torch.Block()})
+	box := func(x0, y0, z0, x1, y1, z1 float32) AABB {
+		return AABB{Min: Vector3{X: x0, Y: y0, Z: z0}, Max: Vector3{X: x1, Y: y1, Z: z1}}
+	}
+	for _, c := range []struct {
+		bb       AABB
+		collides bool
+	}{
+		{BlockAABB(stone), true},
+		{box(15.2, 10.2, 3.2, 15.8, 10.8, 3.8), true}, // Inside the stone
+		{box(16, 10, 3, 17, 11, 4), false},            // Water, touching the stone
+		{box(14, 10, 3, 15, 11, 4), false},            // Torch, touching the stone
+		{box(15, 11, 3, 16, 12, 4), false},            // Above the stone
+		{box(14.5, 10, 3, 16.5, 11, 4), true},         // Spanning two chunks
+		{box(40, 10, 3, 41, 11, 4), true},             // Unloaded chunk
+	} {
+		if got := lv.CollidesAt(c.bb); got != c.collides {
+			t.Fatal("Unexpected collision with", c.bb, ":", got)
+		}
+	}
+	if !(Block{ID: Stone.Block()}).IsSolid() || (Block{}).IsSolid() || (Block{ID: Water.Block()}).IsSolid() || (Block{ID: Torch.Block()}).IsSolid() {
+		t.Fatal("Unexpected block solidity")
+	}
+}
@@ -0,0 +1,367 @@
+package highmc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	RegisterProvider(new(AnvilProvider))
+}
+
+// AnvilProvider is a level provider for PC Anvil region files(.mca), registered as "anvil".
+// Anvil chunks are 256 blocks high, so sections above WorldHeight are dropped on load.
+type AnvilProvider struct {
+	dir string
+}
+
+// Name returns the provider name. AnvilProvider is not named Anvil, as it collides with the block ID.
+func (a *AnvilProvider) Name() string {
+	return "anvil"
+}
+
+// Anvil region file constants
+const (
+	anvilSectorSize    = 4096
+	anvilSectionBlocks = 16 * 16 * 16
+	anvilCompressGzip  = 1
+	anvilCompressZlib  = 2
+)
+
+// Init implements LevelProvider interface.
+func (a *AnvilProvider) Init(name string) {
+	a.dir = filepath.Join("worlds", name, "region")
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		panic(err)
+	}
+}
+
+func (a *AnvilProvider) path(pos ChunkPos) string {
+	return filepath.Join(a.dir, fmt.Sprintf("r.%d.%d.mca", pos.X>>5, pos.Z>>5))
+}
+
+// anvilIndex returns index of the chunk on region header.
+func anvilIndex(pos ChunkPos) int {
+	return int(pos.X&31) + int(pos.Z&31)*32
+}
+
+// Loadable implements LevelProvider interface.
+func (a *AnvilProvider) Loadable(pos ChunkPos) (string, bool) {
+	path := a.path(pos)
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	loc := make([]byte, 4)
+	if _, err := f.ReadAt(loc, int64(anvilIndex(pos)*4)); err != nil {
+		return "", false
+	}
+	if loc[0] == 0 && loc[1] == 0 && loc[2] == 0 {
+		return "", false
+	}
+	return path, true
+}
+
+// LoadChunk implements LevelProvider interface.
+func (a *AnvilProvider) LoadChunk(pos ChunkPos, path string) (*Chunk, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < anvilSectorSize*2 {
+		return nil, Overflow{Need: anvilSectorSize * 2, Got: len(data)}
+	}
+	i := anvilIndex(pos) * 4
+	offset := (int(data[i])<<16 | int(data[i+1])<<8 | int(data[i+2])) * anvilSectorSize
+	if offset == 0 {
+		return nil, fmt.Errorf("chunk %d:%d is not saved on region", pos.X, pos.Z)
+	}
+	if offset+5 > len(data) {
+		return nil, Overflow{Need: offset + 5, Got: len(data)}
+	}
+	length := int(ReadInt(bytes.NewBuffer(data[offset : offset+4])))
+	if length < 1 || offset+4+length > len(data) {
+		return nil, Overflow{Need: offset + 4 + length, Got: len(data)}
+	}
+	var rd io.Reader
+	switch raw := bytes.NewReader(data[offset+5 : offset+4+length]); data[offset+4] {
+	case anvilCompressGzip:
+		rd, err = gzip.NewReader(raw)
+	case anvilCompressZlib:
+		rd, err = zlib.NewReader(raw)
+	default:
+		err = fmt.Errorf("unknown compression type %d", data[offset+4])
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	root, err := readNBT(bytes.NewBuffer(raw))
+	if err != nil {
+		return nil, err
+	}
+	level, ok := root["Level"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("chunk %d:%d has no Level compound", pos.X, pos.Z)
+	}
+	return anvilChunk(pos, level), nil
+}
+
+// anvilChunk converts Anvil Level compound to chunk.
+// Block orders of Anvil sections are YZX, same as the chunk, so section arrays are copied directly.
+func anvilChunk(pos ChunkPos, level map[string]interface{}) *Chunk {
+	chunk := new(Chunk)
+	chunk.Position = pos
+	for i := range chunk.SkyLightData {
+		chunk.SkyLightData[i] = 0xff
+	}
+	sections, _ := level["Sections"].([]interface{})
+	for _, s := range sections {
+		section, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		y, _ := section["Y"].(int8)
+		if y < 0 || int(y) >= WorldHeight/16 {
+			continue
+		}
+		for _, arr := range []struct {
+			dst  []byte
+			name string
+			size int
+		}{
+			{chunk.BlockData[:], "Blocks", anvilSectionBlocks},
+			{chunk.MetaData[:], "Data", anvilSectionBlocks / 2},
+			{chunk.LightData[:], "BlockLight", anvilSectionBlocks / 2},
+			{chunk.SkyLightData[:], "SkyLight", anvilSectionBlocks / 2},
+		} {
+			if src, ok := section[arr.name].([]byte); ok && len(src) == arr.size {
+				copy(arr.dst[int(y)*arr.size:], src)
+			}
+		}
+	}
+	if biomes, ok := level["Biomes"].([]byte); ok && len(biomes) == 256 {
+		for i, id := range biomes {
+			chunk.SetBiomeID(byte(i&0xf), byte(i>>4), id)
+		}
+	}
+	chunk.PopulateHeight()
+	return chunk
+}
+
+// WriteChunk implements LevelProvider interface.
+// The chunk is overwritten in place if it fits on previously used sectors, or appended to the end of region file.
+func (a *AnvilProvider) WriteChunk(pos ChunkPos, chunk *Chunk) error {
+	payload := new(bytes.Buffer)
+	zw := zlib.NewWriter(payload)
+	writeAnvilChunk(zw, pos, chunk)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	sector := new(bytes.Buffer)
+	WriteInt(sector, uint32(payload.Len()+1))
+	WriteByte(sector, anvilCompressZlib)
+	sector.Write(payload.Bytes())
+	count := (sector.Len() + anvilSectorSize - 1) / anvilSectorSize
+	if count > 0xff {
+		return fmt.Errorf("chunk %d:%d is too large for region file", pos.X, pos.Z)
+	}
+	sector.Write(make([]byte, count*anvilSectorSize-sector.Len()))
+
+	f, err := os.OpenFile(a.path(pos), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+	if size < anvilSectorSize*2 { // New region file
+		if _, err := f.WriteAt(make([]byte, anvilSectorSize*2), 0); err != nil {
+			return err
+		}
+		size = anvilSectorSize * 2
+	}
+	i := int64(anvilIndex(pos) * 4)
+	loc := make([]byte, 4)
+	if _, err := f.ReadAt(loc, i); err != nil {
+		return err
+	}
+	offset := int64(loc[0])<<16 | int64(loc[1])<<8 | int64(loc[2])
+	if offset == 0 || int(loc[3]) < count {
+		offset = (size + anvilSectorSize - 1) / anvilSectorSize
+	}
+	if _, err := f.WriteAt(sector.Bytes(), offset*anvilSectorSize); err != nil {
+		return err
+	}
+	loc = []byte{byte(offset >> 16), byte(offset >> 8), byte(offset), byte(count)}
+	if _, err := f.WriteAt(loc, i); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SaveAll implements LevelProvider interface.
+func (a *AnvilProvider) SaveAll(chunks map[ChunkPos]*Chunk) error {
+	for pos, chunk := range chunks {
+		if err := a.WriteChunk(pos, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeAnvilChunk writes the chunk as Anvil chunk NBT.
+func writeAnvilChunk(wr io.Writer, pos ChunkPos, chunk *Chunk) {
+	writeNBTHeader(wr, nbtCompound, "")
+	writeNBTHeader(wr, nbtCompound, "Level")
+	writeNBTHeader(wr, nbtInt, "xPos")
+	WriteInt(wr, uint32(pos.X))
+	writeNBTHeader(wr, nbtInt, "zPos")
+	WriteInt(wr, uint32(pos.Z))
+
+	biomes := make([]byte, 256)
+	for i := range biomes {
+		biomes[i] = chunk.GetBiomeID(byte(i&0xf), byte(i>>4))
+	}
+	writeNBTHeader(wr, nbtByteArray, "Biomes")
+	WriteInt(wr, uint32(len(biomes)))
+	Write(wr, biomes)
+
+	writeNBTHeader(wr, nbtList, "Sections")
+	WriteByte(wr, nbtCompound)
+	WriteInt(wr, WorldHeight/16)
+	for y := 0; y < WorldHeight/16; y++ {
+		writeNBTHeader(wr, nbtByte, "Y")
+		WriteByte(wr, byte(y))
+		for _, arr := range []struct {
+			src  []byte
+			name string
+			size int
+		}{
+			{chunk.BlockData[:], "Blocks", anvilSectionBlocks},
+			{chunk.MetaData[:], "Data", anvilSectionBlocks / 2},
+			{chunk.LightData[:], "BlockLight", anvilSectionBlocks / 2},
+			{chunk.SkyLightData[:], "SkyLight", anvilSectionBlocks / 2},
+		} {
+			writeNBTHeader(wr, nbtByteArray, arr.name)
+			WriteInt(wr, uint32(arr.size))
+			Write(wr, arr.src[y*arr.size:(y+1)*arr.size])
+		}
+		WriteByte(wr, nbtEnd) // Section
+	}
+	WriteByte(wr, nbtEnd) // Level
+	WriteByte(wr, nbtEnd) // Root
+}
+
+// NBT tag types used by Anvil provider
+const (
+	nbtEnd byte = iota
+	nbtByte
+	nbtShort
+	nbtInt
+	nbtLong
+	nbtFloat
+	nbtDouble
+	nbtByteArray
+	nbtString
+	nbtList
+	nbtCompound
+	nbtIntArray
+)
+
+func writeNBTHeader(wr io.Writer, typ byte, name string) {
+	WriteByte(wr, typ)
+	WriteString(wr, name)
+}
+
+// readNBT reads named root compound from decompressed data, and converts it to generic map.
+// Values are int8, int16, int32, int64, float32, float64, []byte, string, []interface{}, map[string]interface{} or []int32.
+// Anvil chunks are much simpler than generic NBT, so this avoids depending on NBT tag structs.
+func readNBT(rd *bytes.Buffer) (root map[string]interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			root, err = nil, fmt.Errorf("malformed NBT: %v", r)
+		}
+	}()
+	if typ := ReadByte(rd); typ != nbtCompound {
+		return nil, fmt.Errorf("NBT root should be compound, got %d", typ)
+	}
+	ReadString(rd)
+	return readNBTPayload(rd, nbtCompound).(map[string]interface{}), nil
+}
+
+// readNBTPayload reads tag payload with given type. It panics on read errors.
+func readNBTPayload(rd io.Reader, typ byte) interface{} {
+	switch typ {
+	case nbtByte:
+		return int8(ReadByte(rd))
+	case nbtShort:
+		return int16(ReadShort(rd))
+	case nbtInt:
+		return int32(ReadInt(rd))
+	case nbtLong:
+		return int64(ReadLong(rd))
+	case nbtFloat:
+		return ReadFloat(rd)
+	case nbtDouble:
+		return ReadDouble(rd)
+	case nbtByteArray:
+		n := int(int32(ReadInt(rd)))
+		if n < 0 || n > math.MaxUint16 {
+			panic(fmt.Errorf("invalid byte array length %d", n))
+		}
+		b, err := Read(rd, n)
+		if err != nil {
+			panic(err)
+		}
+		return b
+	case nbtString:
+		return ReadString(rd)
+	case nbtList:
+		elem := ReadByte(rd)
+		n := int(int32(ReadInt(rd)))
+		if n < 0 || n > math.MaxUint16 {
+			panic(fmt.Errorf("invalid list length %d", n))
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = readNBTPayload(rd, elem)
+		}
+		return list
+	case nbtCompound:
+		c := make(map[string]interface{})
+		for {
+			t := ReadByte(rd)
+			if t == nbtEnd {
+				return c
+			}
+			name := ReadString(rd)
+			c[name] = readNBTPayload(rd, t)
+		}
+	case nbtIntArray:
+		n := int(int32(ReadInt(rd)))
+		if n < 0 || n > math.MaxUint16 {
+			panic(fmt.Errorf("invalid int array length %d", n))
+		}
+		arr := make([]int32, n)
+		for i := range arr {
+			arr[i] = int32(ReadInt(rd))
+		}
+		return arr
+	}
+	panic(fmt.Errorf("unknown NBT tag type %d", typ))
+}
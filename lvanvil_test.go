@@ -0,0 +1,126 @@
+package highmc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// sampleAnvilChunk returns Level compound of a 256 blocks high Anvil chunk.
+// Section 1 has stone with meta 5 on (1, 17, 3), and section 10, which is above WorldHeight, is filled with glass.
+func sampleAnvilChunk(pos ChunkPos) []byte {
+	buf := new(bytes.Buffer)
+	writeNBTHeader(buf, nbtCompound, "")
+	writeNBTHeader(buf, nbtCompound, "Level")
+	writeNBTHeader(buf, nbtInt, "xPos")
+	WriteInt(buf, uint32(pos.X))
+	writeNBTHeader(buf, nbtInt, "zPos")
+	WriteInt(buf, uint32(pos.Z))
+	writeNBTHeader(buf, nbtList, "Sections")
+	WriteByte(buf, nbtCompound)
+	WriteInt(buf, 2)
+	for _, y := range []byte{1, 10} {
+		blocks, data := make([]byte, anvilSectionBlocks), make([]byte, anvilSectionBlocks/2)
+		if y == 1 {
+			i := 1<<8 | 3<<4 | 1
+			blocks[i] = byte(Stone)
+			data[i/2] = 5 << 4 // Odd index: upper nibble
+		} else {
+			for i := range blocks {
+				blocks[i] = byte(Glass)
+			}
+		}
+		writeNBTHeader(buf, nbtByte, "Y")
+		WriteByte(buf, y)
+		writeNBTHeader(buf, nbtByteArray, "Blocks")
+		WriteInt(buf, uint32(len(blocks)))
+		buf.Write(blocks)
+		writeNBTHeader(buf, nbtByteArray, "Data")
+		WriteInt(buf, uint32(len(data)))
+		buf.Write(data)
+		WriteByte(buf, nbtEnd) // Section
+	}
+	WriteByte(buf, nbtEnd) // Level
+	WriteByte(buf, nbtEnd) // Root
+	return buf.Bytes()
+}
+
+func TestAnvilSampleRegion(t *testing.T) {
+	a := &AnvilProvider{dir: t.TempDir()}
+	gzipped, zlibbed := ChunkPos{X: -1, Z: 2}, ChunkPos{X: -2, Z: 2}
+	region := make([]byte, anvilSectorSize*2)
+	for sector, c := range []struct {
+		pos         ChunkPos
+		compression byte
+		compress    func(io.Writer) io.WriteCloser
+	}{
+		{gzipped, anvilCompressGzip, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+		{zlibbed, anvilCompressZlib, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+	} {
+		compressed := new(bytes.Buffer)
+		wr := c.compress(compressed)
+		wr.Write(sampleAnvilChunk(c.pos))
+		wr.Close()
+		i := anvilIndex(c.pos) * 4
+		region[i+2], region[i+3] = byte(sector+2), 1
+		chunk := new(bytes.Buffer)
+		WriteInt(chunk, uint32(compressed.Len()+1))
+		WriteByte(chunk, c.compression)
+		chunk.Write(compressed.Bytes())
+		region = append(region, chunk.Bytes()...)
+		region = append(region, make([]byte, anvilSectorSize-chunk.Len())...)
+	}
+	if err := ioutil.WriteFile(filepath.Join(a.dir, "r.-1.0.mca"), region, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetProvider("anvil").(*AnvilProvider); !ok {
+		t.Fatal("Anvil provider is not registered")
+	}
+
+	if _, ok := a.Loadable(ChunkPos{X: -3, Z: 2}); ok {
+		t.Fatal("Chunk not on the region is loadable")
+	}
+	for _, pos := range []ChunkPos{gzipped, zlibbed} {
+		path, ok := a.Loadable(pos)
+		if !ok {
+			t.Fatal("Chunk on the region is not loadable:", pos)
+		}
+		c, err := a.LoadChunk(pos, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.GetBlock(1, 17, 3) != byte(Stone) || c.GetBlockMeta(1, 17, 3) != 5 || c.GetBlock(1, 16, 3) != 0 {
+			t.Fatal("Unexpected blocks on", pos)
+		}
+		if c.GetHeightMap(1, 3) != 17 || c.GetHeightMap(0, 0) != 0 {
+			t.Fatal("Section above WorldHeight is not dropped on", pos)
+		}
+	}
+}
+
+func TestAnvilRoundTrip(t *testing.T) {
+	a := &AnvilProvider{dir: t.TempDir()}
+	pos := ChunkPos{X: -1, Z: 2}
+	chunk := DefaultFlatGenerator.Generate(pos)
+	chunk.SetBlock(15, WorldHeight-1, 15, byte(Glass))
+	chunk.SetBlockMeta(1, 20, 3, 5)
+	chunk.SetBiomeID(4, 5, 6)
+	if err := a.WriteChunk(pos, chunk); err != nil {
+		t.Fatal(err)
+	}
+	path, ok := a.Loadable(pos)
+	if !ok {
+		t.Fatal("Written chunk is not loadable")
+	}
+	loaded, err := a.LoadChunk(pos, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.BlockData != chunk.BlockData || loaded.MetaData != chunk.MetaData || loaded.GetBiomeID(4, 5) != 6 {
+		t.Fatal("Chunk is not preserved")
+	}
+}
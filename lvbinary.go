@@ -0,0 +1,114 @@
+package highmc
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	RegisterProvider(new(Binary))
+}
+
+// Binary is a default level provider, which saves each chunk to a single file.
+// Chunk file consists of CRC32 checksum of chunk data, and raw chunk data arrays.
+type Binary struct {
+	dir string
+}
+
+// ChecksumError is an error indicates the saved chunk is corrupted.
+type ChecksumError struct {
+	Pos      ChunkPos
+	Expected uint32
+	Got      uint32
+}
+
+// Error implements the error interface.
+func (e ChecksumError) Error() string {
+	return fmt.Sprintf("Chunk %d:%d checksum mismatch: expected %08x, got %08x", e.Pos.X, e.Pos.Z, e.Expected, e.Got)
+}
+
+const binaryChunkSize = 16*16*WorldHeight + 16*16*64*3 + 16*16 + 16*16*4
+
+// Init implements LevelProvider interface.
+func (b *Binary) Init(name string) {
+	b.dir = filepath.Join("worlds", name, "chunks")
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		panic(err)
+	}
+}
+
+func (b *Binary) path(pos ChunkPos) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%d.%d.chunk", pos.X, pos.Z))
+}
+
+// Loadable implements LevelProvider interface.
+func (b *Binary) Loadable(pos ChunkPos) (string, bool) {
+	path := b.path(pos)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// LoadChunk implements LevelProvider interface.
+// It returns ChecksumError if the chunk data does not match with saved checksum.
+func (b *Binary) LoadChunk(pos ChunkPos, path string) (*Chunk, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 4+binaryChunkSize {
+		return nil, Overflow{Need: 4 + binaryChunkSize, Got: len(data)}
+	}
+	buf := bytes.NewBuffer(data)
+	sum := ReadInt(buf)
+	if got := crc32.ChecksumIEEE(buf.Bytes()); got != sum {
+		return nil, ChecksumError{Pos: pos, Expected: sum, Got: got}
+	}
+	chunk := new(Chunk)
+	chunk.Position = pos
+	for _, arr := range [][]byte{
+		chunk.BlockData[:],
+		chunk.MetaData[:],
+		chunk.LightData[:],
+		chunk.SkyLightData[:],
+		chunk.HeightMap[:],
+		chunk.BiomeData[:],
+	} {
+		copy(arr, buf.Next(len(arr)))
+	}
+	return chunk, nil
+}
+
+// WriteChunk implements LevelProvider interface.
+func (b *Binary) WriteChunk(pos ChunkPos, chunk *Chunk) error {
+	data := new(bytes.Buffer)
+	for _, arr := range [][]byte{
+		chunk.BlockData[:],
+		chunk.MetaData[:],
+		chunk.LightData[:],
+		chunk.SkyLightData[:],
+		chunk.HeightMap[:],
+		chunk.BiomeData[:],
+	} {
+		data.Write(arr)
+	}
+	buf := new(bytes.Buffer)
+	WriteInt(buf, crc32.ChecksumIEEE(data.Bytes()))
+	buf.Write(data.Bytes())
+	return ioutil.WriteFile(b.path(pos), buf.Bytes(), 0644)
+}
+
+// SaveAll implements LevelProvider interface.
+func (b *Binary) SaveAll(chunks map[ChunkPos]*Chunk) error {
+	for pos, chunk := range chunks {
+		if err := b.WriteChunk(pos, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package highmc
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestBinaryChecksum(t *testing.T) {
+	b := &Binary{dir: t.TempDir()}
+	chunk := DefaultFlatGenerator.Generate(ChunkPos{})
+	pos := ChunkPos{X: -1, Z: 2}
+	if err := b.WriteChunk(pos, chunk); err != nil {
+		t.Fatal(err)
+	}
+	path, ok := b.Loadable(pos)
+	if !ok {
+		t.Fatal("Written chunk is not loadable")
+	}
+	loaded, err := b.LoadChunk(pos, path)
+	if err != nil || loaded.BlockData != chunk.BlockData || loaded.MetaData != chunk.MetaData {
+		t.Fatal("Chunk is not loaded intact:", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[1000] ^= 1
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.LoadChunk(pos, path); err == nil {
+		t.Fatal("Corrupted chunk is loaded")
+	} else if _, ok := err.(ChecksumError); !ok {
+		t.Fatal("Expected ChecksumError, got", err)
+	}
+}
@@ -17,9 +17,15 @@ type LevelProvider interface {
 }
 
 // RegisterProvider adds level format provider for server.
+// The provider is registered with lowercased type name, or Name() if the provider has the method.
 func RegisterProvider(provider LevelProvider) {
 	typsl := strings.Split(reflect.TypeOf(provider).String(), ".")
 	name := strings.ToLower(typsl[len(typsl)-1])
+	if named, ok := provider.(interface {
+		Name() string
+	}); ok {
+		name = named.Name()
+	}
 	if _, ok := levelProviders[name]; !ok {
 		levelProviders[name] = provider
 	}
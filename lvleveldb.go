@@ -0,0 +1,213 @@
+package highmc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func init() {
+	RegisterProvider(new(Leveldb))
+}
+
+// Leveldb is a level provider for native MCPE worlds, which are stored on LevelDB.
+// Each chunk is saved as 16-block high subchunks, keyed with chunk coordinates, tag and subchunk Y.
+type Leveldb struct {
+	dir string
+	db  *leveldb.DB
+}
+
+// LevelDB key tags
+const (
+	leveldbTagData2D   byte = 0x2d // Height map and biome IDs
+	leveldbTagSubChunk byte = 0x2f
+	leveldbTagVersion  byte = 0x76
+)
+
+// LevelDB value constants
+const (
+	leveldbChunkVersion    = 3
+	leveldbSubChunks       = WorldHeight / 16
+	leveldbSubChunkBlocks  = 16 * 16 * 16
+	leveldbSubChunkSize    = 1 + leveldbSubChunkBlocks + leveldbSubChunkBlocks/2*3 // Version, blocks, meta, sky light, block light
+	leveldbData2DSize      = 16*16*2 + 16*16                                       // Height map(little-endian shorts), biome IDs
+	leveldbSubChunkVersion = 0
+)
+
+// Init implements LevelProvider interface.
+func (l *Leveldb) Init(name string) {
+	l.dir = filepath.Join("worlds", name, "db")
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		panic(err)
+	}
+	db, err := leveldb.OpenFile(l.dir, nil)
+	if err != nil {
+		panic(err)
+	}
+	l.db = db
+}
+
+// leveldbKey returns LevelDB key for given chunk and tag.
+// Subchunk keys have one more byte for subchunk Y.
+func leveldbKey(pos ChunkPos, tag byte, sub ...byte) []byte {
+	key := new(bytes.Buffer)
+	WriteLInt(key, uint32(pos.X))
+	WriteLInt(key, uint32(pos.Z))
+	WriteByte(key, tag)
+	key.Write(sub)
+	return key.Bytes()
+}
+
+// Loadable implements LevelProvider interface.
+func (l *Leveldb) Loadable(pos ChunkPos) (string, bool) {
+	if l.db == nil {
+		return "", false
+	}
+	if _, err := os.Stat(l.dir); err != nil {
+		return "", false
+	}
+	if ok, err := l.db.Has(leveldbKey(pos, leveldbTagVersion), nil); err != nil || !ok {
+		return "", false
+	}
+	return l.dir, true
+}
+
+// LoadChunk implements LevelProvider interface.
+// Subchunks above WorldHeight are ignored, and missing subchunks are filled with air.
+func (l *Leveldb) LoadChunk(pos ChunkPos, path string) (*Chunk, error) {
+	chunk := new(Chunk)
+	chunk.Position = pos
+	for i := range chunk.SkyLightData {
+		chunk.SkyLightData[i] = 0xff
+	}
+	for sub := byte(0); sub < leveldbSubChunks; sub++ {
+		data, err := l.db.Get(leveldbKey(pos, leveldbTagSubChunk, sub), nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if len(data) < leveldbSubChunkSize {
+			return nil, Overflow{Need: leveldbSubChunkSize, Got: len(data)}
+		}
+		readSubChunk(chunk, sub, data[1:])
+	}
+	data, err := l.db.Get(leveldbKey(pos, leveldbTagData2D), nil)
+	if err == nil && len(data) >= leveldbData2DSize {
+		for i := 0; i < 16*16; i++ {
+			x, z := byte(i&0xf), byte(i>>4)
+			chunk.SetBiomeID(x, z, data[16*16*2+i])
+		}
+	} else if err != nil && err != leveldb.ErrNotFound {
+		return nil, err
+	}
+	chunk.PopulateHeight()
+	return chunk, nil
+}
+
+// readSubChunk copies subchunk data to the chunk.
+// Subchunk arrays are XZY ordered, while the chunk arrays are YZX ordered.
+func readSubChunk(chunk *Chunk, sub byte, data []byte) {
+	blocks := data[:leveldbSubChunkBlocks]
+	nibbles := [3][]byte{}
+	for i := range nibbles {
+		offset := leveldbSubChunkBlocks + leveldbSubChunkBlocks/2*i
+		nibbles[i] = data[offset : offset+leveldbSubChunkBlocks/2]
+	}
+	for x := byte(0); x < 16; x++ {
+		for z := byte(0); z < 16; z++ {
+			for y := byte(0); y < 16; y++ {
+				i := int(x)<<8 | int(z)<<4 | int(y)
+				cy := sub<<4 | y
+				chunk.BlockData[uint16(cy)<<8|uint16(z)<<4|uint16(x)] = blocks[i]
+				nibbleSet(chunk.MetaData[:], x, cy, z, subChunkNibble(nibbles[0], i))
+				nibbleSet(chunk.SkyLightData[:], x, cy, z, subChunkNibble(nibbles[1], i))
+				nibbleSet(chunk.LightData[:], x, cy, z, subChunkNibble(nibbles[2], i))
+			}
+		}
+	}
+}
+
+// subChunkNibble reads 4-bit value of given index. Even index is stored on low nibble.
+func subChunkNibble(arr []byte, i int) byte {
+	if i&1 == 0 {
+		return arr[i>>1] & 0x0f
+	}
+	return arr[i>>1] >> 4
+}
+
+// WriteChunk implements LevelProvider interface.
+// Empty subchunks are deleted instead of being written.
+func (l *Leveldb) WriteChunk(pos ChunkPos, chunk *Chunk) error {
+	if l.db == nil {
+		return fmt.Errorf("leveldb provider is not initialized")
+	}
+	batch := new(leveldb.Batch)
+	for sub := byte(0); sub < leveldbSubChunks; sub++ {
+		key := leveldbKey(pos, leveldbTagSubChunk, sub)
+		if data, ok := writeSubChunk(chunk, sub); ok {
+			batch.Put(key, data)
+		} else {
+			batch.Delete(key)
+		}
+	}
+	data2D := new(bytes.Buffer)
+	for i := 0; i < 16*16; i++ {
+		WriteLShort(data2D, uint16(chunk.GetHeightMap(byte(i&0xf), byte(i>>4))))
+	}
+	for i := 0; i < 16*16; i++ {
+		WriteByte(data2D, chunk.GetBiomeID(byte(i&0xf), byte(i>>4)))
+	}
+	batch.Put(leveldbKey(pos, leveldbTagData2D), data2D.Bytes())
+	batch.Put(leveldbKey(pos, leveldbTagVersion), []byte{leveldbChunkVersion})
+	return l.db.Write(batch, nil)
+}
+
+// writeSubChunk returns subchunk data of the chunk. It returns false if the subchunk is empty.
+func writeSubChunk(chunk *Chunk, sub byte) ([]byte, bool) {
+	data := make([]byte, leveldbSubChunkSize)
+	data[0] = leveldbSubChunkVersion
+	blocks := data[1 : 1+leveldbSubChunkBlocks]
+	nibbles := [3][]byte{}
+	for i := range nibbles {
+		offset := 1 + leveldbSubChunkBlocks + leveldbSubChunkBlocks/2*i
+		nibbles[i] = data[offset : offset+leveldbSubChunkBlocks/2]
+	}
+	empty := true
+	for x := byte(0); x < 16; x++ {
+		for z := byte(0); z < 16; z++ {
+			for y := byte(0); y < 16; y++ {
+				i := int(x)<<8 | int(z)<<4 | int(y)
+				cy := sub<<4 | y
+				if blocks[i] = chunk.GetBlock(x, cy, z); blocks[i] != 0 {
+					empty = false
+				}
+				for n, v := range []byte{
+					chunk.GetBlockMeta(x, cy, z),
+					chunk.GetBlockSkyLight(x, cy, z),
+					chunk.GetBlockLight(x, cy, z),
+				} {
+					if i&1 == 0 {
+						nibbles[n][i>>1] |= v & 0x0f
+					} else {
+						nibbles[n][i>>1] |= v << 4
+					}
+				}
+			}
+		}
+	}
+	return data, !empty
+}
+
+// SaveAll implements LevelProvider interface.
+func (l *Leveldb) SaveAll(chunks map[ChunkPos]*Chunk) error {
+	for pos, chunk := range chunks {
+		if err := l.WriteChunk(pos, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package highmc
+
+import (
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestLeveldbRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	l := &Leveldb{dir: dir, db: db}
+	if _, ok := GetProvider("leveldb").(*Leveldb); !ok {
+		t.Fatal("Leveldb provider is not registered")
+	}
+
+	pos := ChunkPos{X: -3, Z: 7}
+	if _, ok := l.Loadable(pos); ok {
+		t.Fatal("Chunk is loadable before written")
+	}
+	chunk := DefaultFlatGenerator.Generate(pos)
+	chunk.SetBlock(3, 100, 9, byte(Glass))
+	chunk.SetBlockMeta(3, 100, 9, 7)
+	chunk.SetBlockMeta(4, 100, 9, 2)
+	chunk.SetBlockLight(5, 10, 6, 11)
+	chunk.SetBiomeID(2, 3, 4)
+	if err := l.WriteChunk(pos, chunk); err != nil {
+		t.Fatal(err)
+	}
+	path, ok := l.Loadable(pos)
+	if !ok {
+		t.Fatal("Written chunk is not loadable")
+	}
+	loaded, err := l.LoadChunk(pos, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.BlockData != chunk.BlockData || loaded.MetaData != chunk.MetaData || loaded.LightData != chunk.LightData {
+		t.Fatal("Block data is not preserved")
+	}
+	if loaded.HeightMap != chunk.HeightMap || loaded.GetBiomeID(2, 3) != 4 {
+		t.Fatal("Height map and biomes are not preserved")
+	}
+}
@@ -7,21 +7,31 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 )
 
 func main() {
-	highmc.ServerName = "HighMC in-dev server"
 	router, err := highmc.CreateRouter(19132)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	router.Start()
-	server := highmc.NewServer()
+	config := highmc.DefaultConfig
+	config.MOTD = "HighMC in-dev server"
+	server := highmc.NewServer(config)
 	router.Owner = server
 	server.Router = router
+	router.Start()
 	server.Start()
 	log.Println("Server running on :19132")
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		log.Println("Stopping server")
+		server.Stop()
+		os.Exit(0)
+	}()
 	for {
 		fmt.Scanln()
 		var b [1024 * 1024 * 16]byte
@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"math"
 	"reflect"
+	"strings"
 	"sync/atomic"
-	"unsafe"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Packet IDs
@@ -144,10 +148,43 @@ type Handleable interface {
 }
 
 // GetMCPEPacket returns MCPEPacket struct with given pid.
+// If there is no such packet, returns nil.
 func GetMCPEPacket(pid byte) MCPEPacket {
-	return reflect.New(packets[pid]).Interface().(MCPEPacket)
+	if v, ok := packets[pid]; ok {
+		return reflect.New(v).Interface().(MCPEPacket)
+	}
+	return nil
+}
+
+// RegisterMCPEPacket adds custom MCPE packet type for given pid, with its prototype value.
+// New packets are created with reflect.New on the prototype's type, so pointer receivers are allowed.
+// It returns error if the pid is already used.
+// Call this before starting servers: registry is not goroutine-safe.
+func RegisterMCPEPacket(pid byte, proto MCPEPacket) error {
+	if _, ok := packets[pid]; ok {
+		return fmt.Errorf("MCPE packet 0x%02x is already registered", pid)
+	}
+	typ := reflect.TypeOf(proto)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if _, ok := reflect.New(typ).Interface().(MCPEPacket); !ok {
+		return fmt.Errorf("%s does not implement MCPEPacket with pointer receiver", typ)
+	}
+	packets[pid] = typ
+	return nil
 }
 
+// Packet-specific constants
+const (
+	SkinSize      = 64 * 32 * 4
+	SkinSizeLarge = 64 * 64 * 4
+
+	// Login layout changes by protocol versions
+	LoginUUIDProtocol     = 34 // 0.12: client UUID, server address and secret added
+	LoginSkinNameProtocol = 38 // 0.13: slim flag replaced with skin name
+)
+
 // Login needs to be documented.
 type Login struct {
 	Username       string
@@ -158,80 +195,101 @@ type Login struct {
 	ClientSecret   string
 	SkinName       string
 	Skin           []byte
+
+	err error // Decode error
 }
 
 // Pid implements MCPEPacket interface.
 func (i Login) Pid() byte { return LoginHead } // 0x8f
 
 // Read implements MCPEPacket interface.
+// Username and protocol are read first, and rest fields are read with the layout of the protocol.
+// Protocols older than LoginUUIDProtocol are not decoded further.
 func (i *Login) Read(buf *bytes.Buffer) {
 	BatchRead(buf, &i.Username, &i.Proto1)
-	if i.Proto1 < MinecraftProtocol { // Old protocol
+	if i.Proto1 < LoginUUIDProtocol { // Too old protocol
 		return
 	}
 	BatchRead(buf, &i.Proto2, &i.ClientID)
 	copy(i.RawUUID[:], buf.Next(16))
-	BatchRead(buf, &i.ServerAddress, &i.ClientSecret, &i.SkinName)
-	i.Skin = []byte(ReadString(buf))
+	BatchRead(buf, &i.ServerAddress, &i.ClientSecret)
+	if i.Proto1 < LoginSkinNameProtocol {
+		if ReadByte(buf) > 0 { // Slim flag
+			i.SkinName = "Standard_Alex"
+		} else {
+			i.SkinName = "Standard_Steve"
+		}
+	} else {
+		i.SkinName = ReadString(buf)
+	}
+	if i.Skin, i.err = ParseBytes(buf); i.err != nil {
+		return
+	}
+	if len(i.Skin) != SkinSize && len(i.Skin) != SkinSizeLarge {
+		i.err = fmt.Errorf("invalid skin length %d", len(i.Skin))
+	}
 }
 
 // Write implements MCPEPacket interface.
+// Fields are written with the layout of Proto1, same as Read.
 func (i Login) Write() *bytes.Buffer {
 	buf := Pool.NewBuffer([]byte{i.Pid()})
-	BatchWrite(buf, i.Username, i.Proto1, i.Proto2,
-		i.ClientID, i.RawUUID[:], i.ServerAddress,
-		i.ClientSecret, i.SkinName, string(i.Skin))
+	BatchWrite(buf, i.Username, i.Proto1)
+	if i.Proto1 < LoginUUIDProtocol {
+		return buf
+	}
+	BatchWrite(buf, i.Proto2, i.ClientID, i.RawUUID[:],
+		i.ServerAddress, i.ClientSecret)
+	if i.Proto1 < LoginSkinNameProtocol {
+		WriteBool(buf, i.SkinName == "Standard_Alex")
+	} else {
+		WriteString(buf, i.SkinName)
+	}
+	WriteBytes(buf, i.Skin)
 	return buf
 }
 
 // Handle implements Handleable interface.
 func (i Login) Handle(p *player) (err error) {
+	if p.loggedIn {
+		log.Println(p.Username, "sent Login packet twice, ignoring")
+		return nil
+	}
 	p.Username = i.Username
 	ret := new(PlayStatus)
-	if i.Proto1 > MinecraftProtocol {
-		ret.Status = LoginFailedServer
-		p.SendPacket(ret)
-		p.Disconnect("Outdated server")
-		log.Printf("Client protocol: %d, Server protocol: %d", i.Proto1, MinecraftProtocol)
+	if i.err != nil {
+		p.Disconnect("Invalid login packet", "Login decode error: "+i.err.Error())
 		return
-	} else if i.Proto1 < MinecraftProtocol {
-		ret.Status = LoginFailedClient
-		p.SendPacket(ret)
-		p.Disconnect("Outdated client")
-		log.Printf("Client protocol: %d, Server protocol: %d", i.Proto1, MinecraftProtocol)
+	}
+	if _, ok := SupportedProtocols[i.Proto1]; !ok {
+		min, max := protocolRange()
+		if i.Proto1 > max {
+			ret.Status = LoginFailedServer
+			p.SendPacket(ret)
+			p.Disconnect("Outdated server")
+		} else {
+			ret.Status = LoginFailedClient
+			p.SendPacket(ret)
+			p.Disconnect("Outdated client")
+		}
+		log.Printf("Client protocol: %d, Server protocols: %d-%d", i.Proto1, min, max)
 		return
 	}
+	p.Protocol = i.Proto1
 	ret.Status = LoginSuccess
 	log.Println("PlayStatus LoginSuccess")
 	p.SendPacket(ret)
 	p.ID, p.UUID, p.Secret, p.EntityID, p.Skin, p.SkinName =
 		i.ClientID, i.RawUUID, i.ClientSecret, atomic.AddUint64(&lastEntityID, 1), i.Skin, i.SkinName
-	// Init pos, etc.
-	if err := p.Server.RegisterPlayer(p); err != nil {
+	p.Level = p.Server.GetDefaultLevel()
+	p.Position = p.Server.Config.SpawnPosition
+	p.Gamemode = p.Server.Config.Gamemode
+	p.loggedIn = true
+	if err := p.spawn(); err != nil {
 		p.Disconnect("Authentication failure", err.Error())
 		return nil
 	}
-	p.Position = Vector3{X: 0, Y: 80, Z: 0}
-	// Auth success!
-	p.SendPacket(&StartGame{
-		Seed:      0xffffffff, // -1
-		Dimension: 0,
-		Generator: 1, // 0: old, 1: infinite, 2: flat
-		Gamemode:  1, // 0: Survival, 1: Creative
-		EntityID:  0, // Player eid set to 0
-		SpawnX:    0,
-		SpawnY:    uint32(80),
-		SpawnZ:    0,
-		X:         p.Position.X,
-		Y:         p.Position.Y,
-		Z:         p.Position.Z,
-	})
-	p.loggedIn = true
-	p.inventory.Holder = p
-	p.inventory.Init()
-
-	p.firstSpawn()
-	p.Server.Message(p.Username + " joined the game")
+	p.joinMessage()
 	// TODO
 
 	return
@@ -308,8 +366,10 @@ func (i *Batch) Read(buf *bytes.Buffer) {
 		return
 	}
 	for b.Len() > 4 {
-		size := ReadInt(b)
-		pk := b.Next(int(size))
+		pk := b.Next(int(ReadCount(b, 1)))
+		if len(pk) == 0 {
+			continue
+		}
 		if pk[0] == 0x92 {
 			panic("Invalid BatchPacket inside BatchPacket")
 		}
@@ -405,13 +465,46 @@ func (i Text) Write() *bytes.Buffer {
 }
 
 // Handle implements Handleable interface.
+// Chat messages are sanitized, and broadcasted with sender's username as source.
 func (i Text) Handle(p *player) (err error) {
-	if i.TextType == TextTypeChat {
-		p.Server.BroadcastPacket(&i, nil)
+	if i.TextType != TextTypeChat {
+		return nil
+	}
+	if strings.HasPrefix(i.Message, "/") {
+		p.ExecuteCommand(i.Message[1:])
+		return nil
 	}
+	msg := sanitizeChat(i.Message)
+	if msg == "" {
+		return nil
+	}
+	p.Server.BroadcastPacket(&Text{
+		TextType: TextTypeChat,
+		Source:   p.Username,
+		Message:  msg,
+	}, nil)
+	log.Printf("<%s> %s", p.Username, msg)
 	return nil
 }
 
+// MaxChatLength is a maximum length of chat messages, in characters. Longer messages are truncated.
+const MaxChatLength = 255
+
+// sanitizeChat removes control characters from the chat message, and truncates it to MaxChatLength.
+func sanitizeChat(msg string) string {
+	runes := make([]rune, 0, len(msg))
+	for _, r := range msg {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			continue
+		}
+		if len(runes) >= MaxChatLength {
+			break
+		}
+		runes = append(runes, r)
+	}
+	return strings.TrimSpace(string(runes))
+}
+
 // Packet-specific constants
 const (
 	DayTime     = 0
@@ -444,6 +537,14 @@ func (i SetTime) Write() *bytes.Buffer {
 	return buf
 }
 
+// Packet-specific constants
+const (
+	GamemodeSurvival uint32 = iota
+	GamemodeCreative
+	GamemodeAdventure
+	GamemodeSpectator
+)
+
 // StartGame needs to be documented.
 type StartGame struct {
 	Seed                   uint32
@@ -488,7 +589,7 @@ type AddPlayer struct {
 	X, Y, Z                float32
 	SpeedX, SpeedY, SpeedZ float32
 	BodyYaw, Yaw, Pitch    float32
-	Metadata               []byte
+	Metadata               EntityMetadata
 }
 
 // Pid implements MCPEPacket interface.
@@ -501,7 +602,7 @@ func (i *AddPlayer) Read(buf *bytes.Buffer) {
 		&i.X, &i.Y, &i.Z,
 		&i.SpeedX, &i.SpeedY, &i.SpeedZ,
 		&i.BodyYaw, &i.Yaw, &i.Pitch)
-	i.Metadata = buf.Bytes()
+	i.Metadata = ReadMetadata(buf)
 }
 
 // Write implements MCPEPacket interface.
@@ -510,8 +611,8 @@ func (i AddPlayer) Write() *bytes.Buffer {
 	BatchWrite(buf, i.RawUUID[:], i.Username, i.EntityID,
 		i.X, i.Y, i.Z,
 		i.SpeedX, i.SpeedY, i.SpeedZ,
-		i.BodyYaw, i.Yaw, i.Pitch, i.Metadata)
-	WriteByte(buf, 0x7f) // Temporal, TODO: implement metadata functions
+		i.BodyYaw, i.Yaw, i.Pitch)
+	WriteMetadata(buf, i.Metadata)
 	return buf
 }
 
@@ -545,7 +646,7 @@ type AddEntity struct {
 	X, Y, Z                float32
 	SpeedX, SpeedY, SpeedZ float32
 	Yaw, Pitch             float32
-	Metadata               []byte
+	Metadata               EntityMetadata
 	Link1, Link2           uint64
 	Link3                  byte
 }
@@ -559,8 +660,8 @@ func (i *AddEntity) Read(buf *bytes.Buffer) {
 		&i.X, &i.Y, &i.Z,
 		&i.SpeedX, &i.SpeedY, &i.SpeedZ,
 		&i.Yaw, &i.Pitch)
-	i.Metadata = buf.Bytes()
-	// TODO
+	i.Metadata = ReadMetadata(buf)
+	BatchRead(buf, &i.Link1, &i.Link2, &i.Link3)
 }
 
 // Write implements MCPEPacket interface.
@@ -570,7 +671,7 @@ func (i AddEntity) Write() *bytes.Buffer {
 		i.X, i.Y, i.Z,
 		i.SpeedX, i.SpeedY, i.SpeedZ,
 		i.Yaw, i.Pitch)
-	WriteByte(buf, 0x7f)
+	WriteMetadata(buf, i.Metadata)
 	BatchWrite(buf, i.Link1, i.Link2, i.Link3)
 	return buf
 }
@@ -671,7 +772,7 @@ func (i MoveEntity) Pid() byte { return MoveEntityHead }
 
 // Read implements MCPEPacket interface.
 func (i *MoveEntity) Read(buf *bytes.Buffer) {
-	entityCnt := ReadInt(buf)
+	entityCnt := ReadCount(buf, 8+4*6)
 	i.EntityIDs = make([]uint64, entityCnt)
 	i.EntityPos = make([][6]float32, entityCnt)
 	for j := uint32(0); j < entityCnt; j++ {
@@ -705,6 +806,10 @@ const (
 	ModeRotation byte = 2
 )
 
+// MaxMoveDistance is a maximum distance which player can move with single MovePlayer packet.
+// Moves farther than this are rejected and player is pushed back to previous position.
+const MaxMoveDistance = 10
+
 // MovePlayer needs to be documented.
 type MovePlayer struct {
 	EntityID uint64
@@ -750,15 +855,21 @@ func (i MovePlayer) Write() *bytes.Buffer {
 }
 
 // Handle implements Handleable interface.
+// The move is broadcasted to players who have this player shown.
 func (i MovePlayer) Handle(p *player) (err error) {
-	x, y, z := unsafe.Pointer(&p.Position.X), unsafe.Pointer(&p.Position.Y), unsafe.Pointer(&p.Position.Z)
-	atomic.StorePointer(&x, unsafe.Pointer(&i.X))
-	atomic.StorePointer(&y, unsafe.Pointer(&i.Y))
-	atomic.StorePointer(&z, unsafe.Pointer(&i.Z))
-	i.EntityID = p.EntityID
-	p.Server.BroadcastPacket(&i, func(t *player) bool {
-		return t.UUID != p.UUID
-	})
+	pos := Vector3{X: i.X, Y: i.Y, Z: i.Z}
+	if p.loggedIn && pos.Distance(p.Position) > MaxMoveDistance {
+		log.Println(p.Username, "moved too quickly")
+		p.ForcePosition(p.Position, p.Yaw, p.Pitch)
+		return nil
+	}
+	p.Position = pos
+	p.Yaw, p.BodyYaw, p.Pitch = i.Yaw, i.BodyYaw, i.Pitch
+	if p.loggedIn {
+		p.updateChunk()
+		p.pickupItems()
+	}
+	p.Server.AddEntityMovement(p.EntityID, i.X, i.Y, i.Z, i.Yaw, i.BodyYaw, i.Pitch)
 	return nil
 }
 
@@ -819,7 +930,7 @@ func (i UpdateBlock) Pid() byte { return UpdateBlockHead }
 
 // Read implements MCPEPacket interface.
 func (i *UpdateBlock) Read(buf *bytes.Buffer) {
-	records := ReadInt(buf)
+	records := ReadCount(buf, 4+4+1+1+1)
 	i.BlockRecords = make([]BlockRecord, records)
 	for k := uint32(0); k < records; k++ {
 		x := ReadInt(buf)
@@ -896,7 +1007,7 @@ func (i Explode) Pid() byte { return ExplodeHead }
 // Read implements MCPEPacket interface.
 func (i *Explode) Read(buf *bytes.Buffer) {
 	BatchRead(buf, &i.X, &i.Y, &i.Z, &i.Radius)
-	cnt := ReadInt(buf)
+	cnt := ReadCount(buf, 3)
 	i.Records = make([][3]byte, cnt)
 	for k := uint32(0); k < cnt; k++ {
 		BatchRead(buf, &i.Records[k][0], &i.Records[k][1], &i.Records[k][2])
@@ -1149,6 +1260,41 @@ func (i MobEquipment) Write() *bytes.Buffer {
 	return buf
 }
 
+// Handle implements Handleable interface.
+// Creative players may hold any creative item, and other players may hold items on their inventory.
+// Rejected equipment is corrected with current hand item, and accepted one is broadcasted to players who see the player.
+func (i MobEquipment) Handle(p *player) (err error) {
+	if i.Item == nil {
+		return
+	}
+	if err := p.inventory.Equip(int(i.SelectedSlot), int(i.Slot), *i.Item); err != nil {
+		log.Println("Rejected equipment from", p.Username+":", err)
+		hand := p.inventory.Hand
+		slot := byte(0xff) // Unlinked
+		if link := p.inventory.HotbarLinks[p.inventory.SelectedSlot]; link > HotbarUnlinked {
+			slot = byte(link + HotbarSize)
+		}
+		p.SendPacket(&MobEquipment{
+			EntityID:     0, // Player eid set to 0
+			Item:         &hand,
+			Slot:         slot,
+			SelectedSlot: byte(p.inventory.SelectedSlot),
+		})
+		return nil
+	}
+	hand := p.inventory.Hand
+	p.Server.BroadcastPacket(&MobEquipment{
+		EntityID:     p.EntityID,
+		Item:         &hand,
+		Slot:         i.Slot,
+		SelectedSlot: i.SelectedSlot,
+	}, func(t *player) bool {
+		_, shown := t.playerShown[p.EntityID]
+		return shown && t.EntityID != p.EntityID
+	})
+	return
+}
+
 // MobArmorEquipment needs to be documented.
 type MobArmorEquipment struct {
 	EntityID uint64
@@ -1177,6 +1323,50 @@ func (i MobArmorEquipment) Write() *bytes.Buffer {
 	return buf
 }
 
+// Handle implements Handleable interface.
+// Each item should be an armor for its slot: helmet, chestplate, leggings and boots.
+// Non-creative players' changes are validated with PlayerInventory.SetSlot.
+// Rejected armor is corrected with current armor contents, and accepted one is broadcasted to players who see the player.
+func (i MobArmorEquipment) Handle(p *player) (err error) {
+	armor := p.inventory.Armor
+	for j, item := range i.Slots {
+		if item == nil || item.ID == 0 {
+			armor[j] = Item{}
+			continue
+		}
+		if slot, ok := ArmorSlot(item.ID); !ok || slot != j {
+			log.Println("Rejected armor from", p.Username+": item", item.ID, "on slot", j)
+			p.inventory.SendArmor()
+			return nil
+		}
+		armor[j] = *item
+	}
+	if p.Gamemode == GamemodeCreative {
+		p.inventory.Armor = armor
+	} else {
+		for j, item := range armor {
+			if item == p.inventory.Armor[j] {
+				continue
+			}
+			if err := p.inventory.SetSlot(ArmorWindow, j, item); err != nil {
+				log.Println("Rejected armor from", p.Username+":", err)
+				p.inventory.SendArmor()
+				return nil
+			}
+		}
+	}
+	pk := &MobArmorEquipment{EntityID: p.EntityID}
+	for j := range pk.Slots {
+		item := p.inventory.Armor[j]
+		pk.Slots[j] = &item
+	}
+	p.Server.BroadcastPacket(pk, func(t *player) bool {
+		_, shown := t.playerShown[p.EntityID]
+		return shown && t.EntityID != p.EntityID
+	})
+	return
+}
+
 // Interact needs to be documented.
 type Interact struct {
 	Action byte
@@ -1230,6 +1420,132 @@ func (i UseItem) Write() *bytes.Buffer {
 	return buf
 }
 
+// Handle implements Handleable interface.
+// Block items are placed on the clicked face. Non-creative players can only place the block on their hand,
+// and adventure/spectator players can't place blocks. If the placement is illegal, actual blocks are sent back to the client.
+func (i UseItem) Handle(p *player) (err error) {
+	if i.Item == nil || i.Face == UseItemAir {
+		return nil
+	}
+	lv, err := p.GetLevel()
+	if err != nil {
+		return
+	}
+	clicked := BlockPos{X: int32(i.X), Y: byte(i.Y), Z: int32(i.Z)}
+	if i.Y < WorldHeight {
+		var chest *ChestTile
+		lv.RW(func(LevelReadWriter) {
+			chest = chestAt(lv, clicked)
+		})
+		if chest != nil {
+			if _, err := p.OpenContainer(&chest.Inventory, ContainerChest, clicked); err != nil {
+				log.Println("Error while opening chest:", err)
+			}
+			return nil
+		}
+	}
+	if i.Item.ID == Air || !i.Item.IsBlock() {
+		return nil
+	}
+	target, ok := clicked.Side(i.Face)
+	if !ok || i.Y >= WorldHeight || !lv.Available(clicked) || !lv.Available(target) {
+		log.Println(p.Username, "tried to place a block on invalid position")
+		return nil
+	}
+	item := *i.Item
+	if p.Gamemode != GamemodeCreative { // Place from server-side hand, not the item client claims
+		hand := p.inventory.Hand
+		if p.Gamemode != GamemodeSurvival || hand.Amount == 0 || hand.ID != item.ID || hand.Meta != item.Meta {
+			log.Println(p.Username, "tried to place a block not on hand")
+			var records []BlockRecord
+			lv.RO(func(rd LevelReader) {
+				records = blockRecords(rd, clicked, target)
+			})
+			p.SendPacket(&UpdateBlock{BlockRecords: records})
+			return nil
+		}
+		item = hand
+	}
+	roster := p.Server.Roster()
+	block := Block{ID: item.ID.Block(), Meta: byte(item.Meta)}
+	var records []BlockRecord
+	lv.RW(func(rw LevelReadWriter) {
+		if err := checkPlacement(rw, clicked, target, roster); err != nil {
+			log.Println(p.Username, "placement rejected:", err)
+			records = blockRecords(rw, clicked, target)
+			return
+		}
+		rw.Set(target, block)
+	})
+	if len(records) > 0 {
+		p.SendPacket(&UpdateBlock{BlockRecords: records})
+		return nil
+	}
+	p.Server.BroadcastPacket(&UpdateBlock{BlockRecords: []BlockRecord{{
+		X:     uint32(target.X),
+		Y:     target.Y,
+		Z:     uint32(target.Z),
+		Block: block,
+		Flags: UpdateAllPriority,
+	}}}, func(t *player) bool {
+		return t.Level == lv
+	})
+	if p.Gamemode == GamemodeSurvival {
+		hand := &p.inventory.Hand
+		if hand.Amount--; hand.Amount == 0 {
+			*hand = Item{}
+		}
+		if slot := p.inventory.HandSlot(); slot != nil {
+			*slot = *hand
+		}
+	}
+	return nil
+}
+
+// blockRecords returns UpdateBlock records with actual blocks on given positions, to correct client-side blocks.
+func blockRecords(rd LevelReader, pos ...BlockPos) []BlockRecord {
+	records := make([]BlockRecord, len(pos))
+	for j, p := range pos {
+		records[j] = BlockRecord{
+			X:     uint32(p.X),
+			Y:     p.Y,
+			Z:     uint32(p.Z),
+			Block: rd.Get(p),
+			Flags: UpdateAllPriority,
+		}
+	}
+	return records
+}
+
+// Packet-specific constants
+const (
+	UseItemAir byte = 0xff // Face value when the item is used on air
+)
+
+// ReplaceableBlocks is a set of blocks which can be replaced by placing another block on it.
+var ReplaceableBlocks = map[byte]struct{}{
+	byte(Air):        {},
+	byte(Water):      {},
+	byte(StillWater): {},
+	byte(TallGrass):  {},
+}
+
+// checkPlacement returns error if a block can't be placed on target position, by clicking clicked block.
+func checkPlacement(rd LevelReader, clicked, target BlockPos, roster []RosterEntry) error {
+	if rd.GetID(clicked) == byte(Air) {
+		return fmt.Errorf("clicked block is air")
+	}
+	if _, ok := ReplaceableBlocks[rd.GetID(target)]; !ok {
+		return fmt.Errorf("target block %d is not replaceable", rd.GetID(target))
+	}
+	for _, e := range roster {
+		if intersectsPlayer(target, e.Position) {
+			return fmt.Errorf("target block intersects with player %s", e.Username)
+		}
+	}
+	return nil
+}
+
 // Packet-specific constants
 const (
 	ActionStartBreak uint32 = iota
@@ -1283,6 +1599,25 @@ func (i PlayerAction) Write() *bytes.Buffer {
 	return buf
 }
 
+// Handle implements Handleable interface.
+func (i PlayerAction) Handle(p *player) (err error) {
+	pos := BlockPos{X: int32(i.X), Y: byte(i.Y), Z: int32(i.Z)}
+	switch i.Action {
+	case ActionRespawn:
+		p.ForcePosition(p.Server.Config.SpawnPosition, 0, 0)
+	case ActionStartBreak:
+		p.breaking, p.breakStart = pos, time.Now()
+	case ActionAbortBreak:
+		p.breakStart = time.Time{}
+	case ActionStopBreak:
+		if i.Y >= WorldHeight {
+			return nil
+		}
+		p.breakBlock(pos)
+	}
+	return nil
+}
+
 // HurtArmor needs to be documented.
 type HurtArmor struct {
 	Health byte
@@ -1303,18 +1638,125 @@ func (i HurtArmor) Write() *bytes.Buffer {
 	return buf
 }
 
+// EntityMetadata is a set of entity properties, keyed by field index.
+// Values should be one of byte, uint16, uint32, float32, string, Item, [3]int32(position) or uint64.
+type EntityMetadata map[byte]interface{}
+
+// Entity metadata value types
+const (
+	MetadataByte byte = iota
+	MetadataShort
+	MetadataInt
+	MetadataFloat
+	MetadataString
+	MetadataSlot
+	MetadataPos
+	MetadataLong
+	metadataEnd byte = 0x7f
+)
+
+// ReadMetadata reads entity metadata from buffer, until the terminator.
+func ReadMetadata(buf *bytes.Buffer) EntityMetadata {
+	meta := make(EntityMetadata)
+	for {
+		head := ReadByte(buf)
+		if head == metadataEnd {
+			return meta
+		}
+		key := head & 0x1f
+		switch head >> 5 {
+		case MetadataByte:
+			meta[key] = ReadByte(buf)
+		case MetadataShort:
+			meta[key] = ReadLShort(buf)
+		case MetadataInt:
+			meta[key] = ReadLInt(buf)
+		case MetadataFloat:
+			meta[key] = math.Float32frombits(ReadLInt(buf))
+		case MetadataString:
+			b, err := Read(buf, int(ReadLShort(buf)))
+			if err != nil {
+				panic(err)
+			}
+			meta[key] = string(b)
+		case MetadataSlot:
+			item := Item{ID: ID(ReadLShort(buf))}
+			item.Amount = ReadByte(buf)
+			item.Meta = ReadLShort(buf)
+			meta[key] = item
+		case MetadataPos:
+			meta[key] = [3]int32{int32(ReadLInt(buf)), int32(ReadLInt(buf)), int32(ReadLInt(buf))}
+		case MetadataLong:
+			meta[key] = ReadLLong(buf)
+		default:
+			panic(fmt.Errorf("unknown entity metadata type %d", head>>5))
+		}
+	}
+}
+
+// WriteMetadata writes entity metadata to buffer, with the terminator.
+// It panics if the metadata contains unsupported value types.
+func WriteMetadata(buf *bytes.Buffer, meta EntityMetadata) {
+	for _, k := range GetSortedKeys(meta) {
+		key := byte(k) & 0x1f
+		switch v := meta[byte(k)].(type) {
+		case byte:
+			WriteByte(buf, MetadataByte<<5|key)
+			WriteByte(buf, v)
+		case uint16:
+			WriteByte(buf, MetadataShort<<5|key)
+			WriteLShort(buf, v)
+		case uint32:
+			WriteByte(buf, MetadataInt<<5|key)
+			WriteLInt(buf, v)
+		case float32:
+			WriteByte(buf, MetadataFloat<<5|key)
+			WriteLInt(buf, math.Float32bits(v))
+		case string:
+			WriteByte(buf, MetadataString<<5|key)
+			WriteLShort(buf, uint16(len(v)))
+			Write(buf, []byte(v))
+		case Item:
+			WriteByte(buf, MetadataSlot<<5|key)
+			WriteLShort(buf, uint16(v.ID))
+			WriteByte(buf, v.Amount)
+			WriteLShort(buf, v.Meta)
+		case [3]int32:
+			WriteByte(buf, MetadataPos<<5|key)
+			WriteLInt(buf, uint32(v[0]))
+			WriteLInt(buf, uint32(v[1]))
+			WriteLInt(buf, uint32(v[2]))
+		case uint64:
+			WriteByte(buf, MetadataLong<<5|key)
+			WriteLLong(buf, v)
+		default:
+			panic(fmt.Errorf("unsupported entity metadata type %T", v))
+		}
+	}
+	WriteByte(buf, metadataEnd)
+}
+
 // SetEntityData needs to be documented.
-type SetEntityData struct{} // TODO Metadata
+type SetEntityData struct {
+	EntityID uint64
+	Metadata EntityMetadata
+}
 
 // Pid implements MCPEPacket interface.
 func (i SetEntityData) Pid() byte { return SetEntityDataHead }
 
 // Read implements MCPEPacket interface.
-func (i *SetEntityData) Read(buf *bytes.Buffer) {}
+func (i *SetEntityData) Read(buf *bytes.Buffer) {
+	i.EntityID = ReadLong(buf)
+	i.Metadata = ReadMetadata(buf)
+}
 
 // Write implements MCPEPacket interface.
 func (i SetEntityData) Write() *bytes.Buffer {
-	return nil
+	buf := Pool.NewBuffer([]byte{i.Pid()})
+	WriteLong(buf, i.EntityID)
+	WriteMetadata(buf, i.Metadata)
+	return buf
 }
 
 // SetEntityMotion needs to be documented.
@@ -1328,7 +1770,7 @@ func (i SetEntityMotion) Pid() byte { return SetEntityMotionHead }
 
 // Read implements MCPEPacket interface.
 func (i *SetEntityMotion) Read(buf *bytes.Buffer) {
-	entityCnt := ReadInt(buf)
+	entityCnt := ReadCount(buf, 8+4*6)
 	i.EntityIDs = make([]uint64, entityCnt)
 	i.EntityMotion = make([][6]float32, entityCnt)
 	for j := uint32(0); j < entityCnt; j++ {
@@ -1554,8 +1996,15 @@ func (i ContainerClose) Write() *bytes.Buffer {
 	return buf
 }
 
+// Handle implements Handleable interface.
+// The container is removed from open containers, so its slots can't be modified anymore.
+func (i ContainerClose) Handle(p *player) (err error) {
+	delete(p.openContainers, i.WindowID)
+	return
+}
+
 // ContainerSetSlot needs to be documented.
-type ContainerSetSlot struct { // TODO: implement this after slots
+type ContainerSetSlot struct {
 	Windowid   byte
 	Slot       uint16
 	HotbarSlot uint16
@@ -1584,6 +2033,58 @@ func (i ContainerSetSlot) Write() *bytes.Buffer {
 	return buf
 }
 
+// Handle implements Handleable interface.
+// Creative players can put creative items on hotbar and container slots.
+// Other players' changes are validated not to create items, with PlayerInventory.SetSlot.
+// Rejected slots are reverted on client. Container slots are shared with other viewers,
+// so they are modified with player's level locked.
+func (i ContainerSetSlot) Handle(p *player) (err error) {
+	if i.Item == nil {
+		return
+	}
+	p.lockContainers(func() {
+		i.handle(p)
+	})
+	return nil
+}
+
+// handle applies the slot change. Open containers should be locked with lockContainers.
+func (i ContainerSetSlot) handle(p *player) {
+	if p.Gamemode == GamemodeCreative {
+		if i.Windowid != InventoryWindow {
+			slot := p.inventory.Slot(i.Windowid, int(i.Slot))
+			if slot == nil || i.Windowid == ArmorWindow {
+				return
+			}
+			if !IsCreativeItem(*i.Item) {
+				log.Printf("Rejected creative item from %s: item %d:%d is not a creative item", p.Username, i.Item.ID, i.Item.Meta)
+				p.inventory.resendSlot(i.Windowid, int(i.Slot))
+				return
+			}
+			*slot = *i.Item
+			return
+		}
+		if err := p.inventory.GiveCreative(int(i.Slot), *i.Item); err != nil {
+			log.Println("Rejected creative item from", p.Username+":", err)
+			revert := new(Item)
+			if int(i.Slot) < len(p.inventory.Hotbars) {
+				*revert = p.inventory.Hotbars[i.Slot]
+			}
+			p.SendPacket(&ContainerSetSlot{
+				Windowid:   i.Windowid,
+				Slot:       i.Slot,
+				HotbarSlot: i.HotbarSlot,
+				Item:       revert,
+			})
+		}
+		return
+	}
+	if err := p.inventory.SetSlot(i.Windowid, int(i.Slot), *i.Item); err != nil {
+		log.Println("Rejected slot change from", p.Username+":", err)
+		p.inventory.resendSlot(i.Windowid, int(i.Slot))
+	}
+}
+
 // ContainerSetData needs to be documented.
 type ContainerSetData struct {
 	WindowID byte
@@ -1612,6 +2113,10 @@ func (i ContainerSetData) Write() *bytes.Buffer {
 
 // Packet-specific constants
 const (
+	ContainerChest byte = 0 // ContainerOpen type for chests
+
+	MinContainerWindow byte = 2 // Window IDs from MinContainerWindow to ArmorWindow-1 are assigned for containers
+
 	InventoryWindow byte = 0
 	ArmorWindow     byte = 0x78
 	CreativeWindow  byte = 0x79
@@ -1630,7 +2135,7 @@ func (i ContainerSetContent) Pid() byte { return ContainerSetContentHead }
 // Read implements MCPEPacket interface.
 func (i *ContainerSetContent) Read(buf *bytes.Buffer) {
 	i.WindowID = ReadByte(buf)
-	count := ReadShort(buf)
+	count := ReadShortCount(buf, 2)
 	i.Slots = make([]Item, count)
 	for j := range i.Slots {
 		if buf.Len() < 7 {
@@ -1640,7 +2145,7 @@ func (i *ContainerSetContent) Read(buf *bytes.Buffer) {
 		(&i.Slots[j]).Read(buf)
 	}
 	if i.WindowID == InventoryWindow {
-		count := ReadShort(buf)
+		count := ReadShortCount(buf, 4)
 		i.Hotbar = make([]uint32, count)
 		for j := range i.Hotbar {
 			i.Hotbar[j] = ReadInt(buf)
@@ -1657,6 +2162,7 @@ func (i ContainerSetContent) Write() *bytes.Buffer {
 		Write(buf, slot.Write())
 	}
 	if i.WindowID == InventoryWindow {
+		WriteShort(buf, uint16(len(i.Hotbar)))
 		for _, h := range i.Hotbar {
 			WriteInt(buf, h)
 		}
@@ -1666,17 +2172,47 @@ func (i ContainerSetContent) Write() *bytes.Buffer {
 	return buf
 }
 
-// CraftingData needs to be documented.
-type CraftingData struct{} // TODO
+// CraftingData is a list of crafting recipes for client.
+type CraftingData struct {
+	Recipes      []Recipe
+	CleanRecipes bool
+}
 
 // Pid implements MCPEPacket interface.
 func (i CraftingData) Pid() byte { return CraftingDataHead }
 
 // Read implements MCPEPacket interface.
-func (i *CraftingData) Read(buf *bytes.Buffer) {}
+// Unknown recipe entries are skipped.
+func (i *CraftingData) Read(buf *bytes.Buffer) {
+	count := ReadCount(buf, 8)
+	i.Recipes = make([]Recipe, 0, count)
+	for j := uint32(0); j < count; j++ {
+		typ := ReadInt(buf)
+		entry := Pool.NewBuffer(buf.Next(int(ReadCount(buf, 1))))
+		if typ == uint32(RecipeShapeless) || typ == uint32(RecipeShaped) {
+			i.Recipes = append(i.Recipes, readRecipe(entry, byte(typ)))
+		}
+		Pool.Recycle(entry)
+	}
+	i.CleanRecipes = ReadBool(buf)
+}
 
 // Write implements MCPEPacket interface.
-func (i CraftingData) Write() *bytes.Buffer { return nil }
+func (i CraftingData) Write() *bytes.Buffer {
+	buf := Pool.NewBuffer([]byte{i.Pid()})
+	WriteInt(buf, uint32(len(i.Recipes)))
+	entry := Pool.NewBuffer(nil)
+	for _, recipe := range i.Recipes {
+		entry.Reset()
+		writeRecipe(entry, recipe)
+		WriteInt(buf, uint32(recipe.Type))
+		WriteInt(buf, uint32(entry.Len()))
+		Write(buf, entry.Bytes())
+	}
+	Pool.Recycle(entry)
+	WriteBool(buf, i.CleanRecipes)
+	return buf
+}
 
 // CraftingEvent needs to be documented.
 type CraftingEvent struct{} // TODO
@@ -1690,6 +2226,12 @@ func (i *CraftingEvent) Read(buf *bytes.Buffer) {}
 // Write implements MCPEPacket interface.
 func (i CraftingEvent) Write() *bytes.Buffer { return nil }
 
+// Packet-specific constants
+const (
+	PermissionOperator uint32 = 0x01
+	PermissionNormal   uint32 = 0x02
+)
+
 // AdventureSettings needs to be documented.
 type AdventureSettings struct {
 	Flags            uint32
@@ -1775,6 +2317,14 @@ func (i FullChunkData) Write() *bytes.Buffer {
 	return buf
 }
 
+// Packet-specific constants
+const (
+	DifficultyPeaceful uint32 = iota
+	DifficultyEasy
+	DifficultyNormal
+	DifficultyHard
+)
+
 // SetDifficulty needs to be documented.
 type SetDifficulty struct {
 	Difficulty uint32
@@ -1841,7 +2391,7 @@ func (i PlayerList) Pid() byte { return PlayerListHead }
 // Read implements MCPEPacket interface.
 func (i *PlayerList) Read(buf *bytes.Buffer) {
 	i.Type = ReadByte(buf)
-	entryCnt := ReadInt(buf)
+	entryCnt := ReadCount(buf, 16)
 	i.PlayerEntries = make([]PlayerListEntry, entryCnt)
 	for k := uint32(0); k < entryCnt; k++ {
 		entry := PlayerListEntry{}
@@ -1897,6 +2447,24 @@ func (i RequestChunkRadius) Write() *bytes.Buffer {
 	return buf
 }
 
+// Handle implements Handleable interface.
+// The radius is clamped to Server.MaxChunkRadius, and granted radius is sent back with ChunkRadiusUpdate.
+func (i RequestChunkRadius) Handle(p *player) (err error) {
+	radius := p.Server.MaxChunkRadius
+	if i.Radius < uint32(radius) {
+		radius = int32(i.Radius)
+	}
+	if radius < 1 {
+		radius = 1
+	}
+	p.chunkRadius = radius
+	p.SendPacket(&ChunkRadiusUpdate{Radius: uint32(radius)})
+	if p.spawned {
+		p.updateChunks(p.chunkCenter)
+	}
+	return
+}
+
 // ChunkRadiusUpdate needs to be documented.
 type ChunkRadiusUpdate struct {
 	Radius uint32
@@ -0,0 +1,578 @@
+package highmc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPlayer returns a spawned player on given level, which is not registered to the server.
+// Sent packets are buffered on EncapsulatedChan.
+func testPlayer(s *Server, lv *Level, gamemode uint32) *player {
+	return &player{
+		session:   &session{EncapsulatedChan: make(chan *EncapsulatedPacket, 64), Server: s},
+		Level:     lv,
+		Gamemode:  gamemode,
+		inventory: &PlayerInventory{},
+		spawned:   true,
+	}
+}
+
+// loginPlayer returns a player logged in to the started server with given name.
+// Sent packets are buffered on EncapsulatedChan.
+func loginPlayer(t *testing.T, s *Server, username string, port int) *player {
+	p := NewPlayer(&session{
+		EncapsulatedChan: make(chan *EncapsulatedPacket, 512),
+		Server:           s,
+		Address:          &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+		closed:           make(chan struct{}),
+	})
+	Login{Username: username, Proto1: MinecraftProtocol}.Handle(p)
+	if !p.spawned {
+		t.Fatal("Player is not spawned after login")
+	}
+	return p
+}
+
+// sentPackets takes every packets sent to the player, unpacking batches.
+func sentPackets(t *testing.T, p *player) (pks []MCPEPacket) {
+	var decode func(payload []byte)
+	decode = func(payload []byte) {
+		pk := GetMCPEPacket(payload[0])
+		if pk == nil {
+			t.Fatalf("Unknown packet 0x%02x is sent", payload[0])
+		}
+		pk.Read(bytes.NewBuffer(payload[1:]))
+		if batch, ok := pk.(*Batch); ok {
+			for _, payload := range batch.Payloads {
+				decode(payload)
+			}
+			return
+		}
+		pks = append(pks, pk)
+	}
+	for len(p.EncapsulatedChan) > 0 {
+		decode((<-p.EncapsulatedChan).Buffer.Bytes()[1:]) // Skips 0x8e
+	}
+	return
+}
+
+// broadcast is a broadcast request captured by serveTest.
+type broadcast struct {
+	packet MCPEPacket
+	filter func(*player) bool
+}
+
+// serveTest serves the server which is not started, until stop is closed.
+// It replies empty roster, and passes broadcasts to returned channel instead of sending them.
+func serveTest(s *Server, stop chan struct{}) <-chan broadcast {
+	broadcasts := make(chan broadcast, 64)
+	go func() {
+		for {
+			select {
+			case reply := <-s.rosterRequest:
+				reply <- nil
+			case req := <-s.broadcastRequest:
+				broadcasts <- broadcast{req.packet, req.filter}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return broadcasts
+}
+
+func TestUseItemPlace(t *testing.T) {
+	s := NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	broadcasts := serveTest(s, stop)
+	lv := testLevel()
+	lv.Set(BlockPos{X: 5, Y: 10, Z: 5}, Block{ID: Stone.Block()})
+	p := testPlayer(s, lv, GamemodeSurvival)
+	p.inventory.Hand = Item{ID: Dirt, Amount: 1}
+	UseItem{X: 5, Y: 10, Z: 5, Face: SideUp, Item: &Item{ID: Dirt, Amount: 1}}.Handle(p)
+	if lv.GetID(BlockPos{X: 5, Y: 11, Z: 5}) != Dirt.Block() || p.inventory.Hand.ID != 0 {
+		t.Fatal("Block on hand is not placed")
+	}
+	req := <-broadcasts
+	if !req.filter(&player{Level: lv}) || req.filter(&player{Level: testLevel()}) {
+		t.Fatal("Block update is not filtered by level")
+	}
+
+	UseItem{X: 5, Y: 10, Z: 4, Face: SideSouth, Item: &Item{ID: Dirt, Amount: 1}}.Handle(p)
+	if lv.GetID(BlockPos{X: 5, Y: 10, Z: 5}) != Stone.Block() {
+		t.Fatal("Non-replaceable block is replaced")
+	}
+}
+
+func TestUseItemPlaceNotOnHand(t *testing.T) {
+	s := NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	serveTest(s, stop)
+	lv := testLevel()
+	lv.Set(BlockPos{X: 5, Y: 10, Z: 5}, Block{ID: Stone.Block()})
+	above := BlockPos{X: 5, Y: 11, Z: 5}
+
+	p := testPlayer(s, lv, GamemodeSurvival)
+	p.inventory.Hand = Item{ID: Dirt, Amount: 1}
+	UseItem{X: 5, Y: 10, Z: 5, Face: SideUp, Item: &Item{ID: Obsidian, Amount: 1}}.Handle(p)
+	if lv.GetID(above) != byte(Air) || p.inventory.Hand.Amount != 1 {
+		t.Fatal("Survival player placed a block not on hand")
+	}
+	if len(p.EncapsulatedChan) == 0 {
+		t.Fatal("Rejected placement is not corrected")
+	}
+
+	p = testPlayer(s, lv, GamemodeAdventure)
+	p.inventory.Hand = Item{ID: Dirt, Amount: 1}
+	UseItem{X: 5, Y: 10, Z: 5, Face: SideUp, Item: &Item{ID: Dirt, Amount: 1}}.Handle(p)
+	if lv.GetID(above) != byte(Air) {
+		t.Fatal("Adventure player placed a block")
+	}
+
+	p = testPlayer(s, lv, GamemodeCreative)
+	UseItem{X: 5, Y: 10, Z: 5, Face: SideUp, Item: &Item{ID: Obsidian, Amount: 1}}.Handle(p)
+	if lv.GetID(above) != Obsidian.Block() {
+		t.Fatal("Creative player can't place blocks")
+	}
+}
+
+func TestLoginSkin(t *testing.T) {
+	skin := make([]byte, SkinSize)
+	for i := range skin {
+		skin[i] = byte(i % 7) // Includes zero bytes
+	}
+	login := Login{Username: "Steve", Proto1: MinecraftProtocol, Proto2: MinecraftProtocol, SkinName: "Standard_Custom", Skin: skin}
+	buf := login.Write()
+	buf.Next(1)
+	decoded := new(Login)
+	decoded.Read(buf)
+	if decoded.err != nil || !bytes.Equal(decoded.Skin, skin) || decoded.SkinName != login.SkinName {
+		t.Fatal("Skin is not preserved:", decoded.err)
+	}
+
+	b := login.Write().Bytes()
+	decoded = new(Login)
+	decoded.Read(bytes.NewBuffer(b[1 : len(b)-10])) // Truncated skin
+	if decoded.err == nil {
+		t.Fatal("Truncated skin is accepted")
+	}
+}
+
+func TestLoginLayouts(t *testing.T) {
+	uuid := [16]byte{0x6b, 0x1e, 0x2c, 0x8d, 0x3f, 0x4a, 0x4e, 0x5b, 0x9c, 0x0d, 0x1e, 0x2f, 0x30, 0x41, 0x52, 0x63}
+	header := func(proto byte) []byte {
+		b := []byte{0x00, 0x05, 'S', 't', 'e', 'v', 'e', 0x00, 0x00, 0x00, proto, 0x00, 0x00, 0x00, proto} // Username, Proto1, Proto2
+		b = append(b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x30, 0x39)                                      // ClientID
+		b = append(b, uuid[:]...)
+		b = append(b, 0x00, 0x0f)
+		b = append(b, "127.0.0.1:19132"...)
+		return append(b, 0x00, 0x06, 's', 'e', 'c', 'r', 'e', 't')
+	}
+	skin := append([]byte{SkinSize >> 8, SkinSize & 0xff}, make([]byte, SkinSize)...)
+	captured := map[uint32][]byte{
+		34:                append(append(header(34), 0x01), skin...), // 0.12: slim flag instead of skin name
+		MinecraftProtocol: append(append(header(MinecraftProtocol), 0x00, 0x0e), append([]byte("Standard_Steve"), skin...)...),
+	}
+	skinNames := map[uint32]string{34: "Standard_Alex", MinecraftProtocol: "Standard_Steve"}
+	for proto, b := range captured {
+		login := new(Login)
+		buf := bytes.NewBuffer(b)
+		login.Read(buf)
+		if login.err != nil || login.Proto1 != proto || login.Username != "Steve" || login.RawUUID != uuid || login.ClientID != 12345 {
+			t.Fatal("Unexpected login on protocol", proto, ":", login.err)
+		}
+		if login.SkinName != skinNames[proto] || len(login.Skin) != SkinSize || buf.Len() != 0 {
+			t.Fatal("Unexpected skin on protocol", proto, ":", login.SkinName)
+		}
+	}
+}
+
+func TestCheckPlacement(t *testing.T) {
+	lv := testLevel()
+	clicked, target, block := BlockPos{X: 5, Y: 10, Z: 5}, BlockPos{X: 5, Y: 11, Z: 5}, BlockPos{X: 6, Y: 10, Z: 5}
+	lv.Set(clicked, Block{ID: Stone.Block()})
+	lv.Set(block, Block{ID: Stone.Block()})
+	lv.Set(BlockPos{X: 7, Y: 10, Z: 5}, Block{ID: byte(TallGrass)})
+	someone := RosterEntry{Username: "Steve", Position: Vector3{X: 5.5, Y: 11, Z: 5.5}}
+	for _, c := range []struct {
+		name           string
+		clicked, pos   BlockPos
+		roster         []RosterEntry
+		expectRejected bool
+	}{
+		{"air", clicked, target, nil, false},
+		{"replaceable", block, BlockPos{X: 7, Y: 10, Z: 5}, nil, false},
+		{"existing block", clicked, block, nil, true},
+		{"player", clicked, target, []RosterEntry{someone}, true},
+		{"clicked air", target, BlockPos{X: 5, Y: 12, Z: 5}, nil, true},
+	} {
+		if err := checkPlacement(lv, c.clicked, c.pos, c.roster); (err != nil) != c.expectRejected {
+			t.Fatal("Unexpected placement result on", c.name, ":", err)
+		}
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	meta := EntityMetadata{
+		0: byte(1),
+		1: uint16(300),
+		2: uint32(70000),
+		3: float32(1.5),
+		4: "Steve",
+		5: Item{ID: Stone, Amount: 3, Meta: 2},
+		6: [3]int32{-1, 64, 5},
+		7: uint64(1<<40 + 3),
+	}
+	buf := (&SetEntityData{EntityID: 9, Metadata: meta}).Write()
+	buf.Next(1)
+	data := new(SetEntityData)
+	data.Read(buf)
+	if data.EntityID != 9 || !reflect.DeepEqual(data.Metadata, meta) || buf.Len() != 0 {
+		t.Fatal("Metadata is not preserved:", data.Metadata)
+	}
+
+	buf = (&AddEntity{EntityID: 1, Metadata: EntityMetadata{0: byte(0)}, Link1: 5, Link3: 1}).Write()
+	buf.Next(1)
+	entity := new(AddEntity)
+	entity.Read(buf)
+	if entity.Metadata[0] != byte(0) || entity.Link1 != 5 || entity.Link3 != 1 || buf.Len() != 0 {
+		t.Fatal("Unexpected AddEntity after metadata:", entity)
+	}
+
+	buf = (&AddPlayer{Username: "Steve"}).Write()
+	buf.Next(1)
+	player := new(AddPlayer)
+	player.Read(buf)
+	if len(player.Metadata) != 0 || buf.Len() != 0 {
+		t.Fatal("Unexpected AddPlayer metadata:", player.Metadata)
+	}
+}
+
+func TestLoginProtocols(t *testing.T) {
+	SupportedProtocols[45] = "0.14.0"
+	defer delete(SupportedProtocols, 45)
+	s := NewServer()
+	s.Start()
+	defer s.Stop()
+	if str := GetServerString(); !strings.HasPrefix(str, "MCPE;"+ServerName+";45;0.14.0;") {
+		t.Fatal("Lowest supported protocol is not advertised:", str)
+	}
+	for i, c := range []struct {
+		proto  uint32
+		status uint32
+	}{
+		{45, LoginSuccess},
+		{MinecraftProtocol, LoginSuccess},
+		{30, LoginFailedClient},
+		{MinecraftProtocol + 1, LoginFailedServer},
+	} {
+		p := NewPlayer(&session{
+			EncapsulatedChan: make(chan *EncapsulatedPacket, 512),
+			Server:           s,
+			Address:          &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: i + 1},
+			closed:           make(chan struct{}),
+		})
+		Login{Username: fmt.Sprint("Steve", i), Proto1: c.proto}.Handle(p)
+		pks := sentPackets(t, p)
+		if status, ok := pks[0].(*PlayStatus); !ok || status.Status != c.status {
+			t.Fatal("Unexpected login result on protocol", c.proto, ":", pks[0])
+		}
+		if c.status == LoginSuccess && (!p.spawned || p.Protocol != c.proto) {
+			t.Fatal("Player is not spawned on protocol", c.proto)
+		}
+	}
+}
+
+func TestPlayerActionBreak(t *testing.T) {
+	s := NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	broadcasts := serveTest(s, stop)
+	lv := testLevel()
+	pos := BlockPos{X: 1, Y: 10, Z: 1}
+	lv.Set(pos, Block{ID: Stone.Block()})
+	p := testPlayer(s, lv, GamemodeSurvival)
+
+	PlayerAction{Action: ActionStartBreak, X: 1, Y: 10, Z: 1}.Handle(p)
+	PlayerAction{Action: ActionStopBreak, X: 1, Y: 10, Z: 1}.Handle(p)
+	pks := sentPackets(t, p)
+	if lv.GetID(pos) != Stone.Block() || len(pks) != 1 {
+		t.Fatal("Instant break is not rejected")
+	}
+	if update, ok := pks[0].(*UpdateBlock); !ok || update.BlockRecords[0].Block.ID != Stone.Block() {
+		t.Fatal("Rejected block is not sent back:", pks[0])
+	}
+
+	p.breakStart = time.Now().Add(-time.Minute)
+	PlayerAction{Action: ActionStopBreak, X: 1, Y: 10, Z: 1}.Handle(p)
+	if lv.GetID(pos) != Air.Block() {
+		t.Fatal("Block is not broken")
+	}
+	if _, ok := (<-broadcasts).packet.(*UpdateBlock); !ok {
+		t.Fatal("Broken block is not broadcasted")
+	}
+	if len(lv.Items) != 1 {
+		t.Fatal("Broken block is not dropped")
+	}
+	for _, e := range lv.Items {
+		if e.Item.ID != Stone {
+			t.Fatal("Unexpected drop:", e.Item)
+		}
+	}
+}
+
+func TestUseItemFaces(t *testing.T) {
+	s := NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	serveTest(s, stop)
+	clicked := BlockPos{X: 5, Y: 10, Z: 5}
+	for face, target := range map[byte]BlockPos{
+		SideDown:  {X: 5, Y: 9, Z: 5},
+		SideUp:    {X: 5, Y: 11, Z: 5},
+		SideNorth: {X: 5, Y: 10, Z: 4},
+		SideSouth: {X: 5, Y: 10, Z: 6},
+		SideWest:  {X: 4, Y: 10, Z: 5},
+		SideEast:  {X: 6, Y: 10, Z: 5},
+	} {
+		lv := testLevel()
+		lv.Set(clicked, Block{ID: Stone.Block()})
+		p := testPlayer(s, lv, GamemodeSurvival)
+		p.inventory.Hand = Item{ID: Dirt, Amount: 2}
+		UseItem{X: 5, Y: 10, Z: 5, Face: face, Item: &Item{ID: Dirt, Amount: 2}}.Handle(p)
+		if lv.GetID(target) != Dirt.Block() || p.inventory.Hand.Amount != 1 {
+			t.Fatal("Block is not placed on face", face)
+		}
+	}
+
+	lv := testLevel()
+	lv.Set(clicked, Block{ID: Stone.Block()})
+	p := testPlayer(s, lv, GamemodeSurvival)
+	p.inventory.Hand = Item{ID: DiamondSword, Amount: 1}
+	UseItem{X: 5, Y: 10, Z: 5, Face: SideUp, Item: &Item{ID: DiamondSword, Amount: 1}}.Handle(p)
+	if lv.GetID(BlockPos{X: 5, Y: 11, Z: 5}) != byte(Air) || p.inventory.Hand.Amount != 1 {
+		t.Fatal("Non-block item is placed")
+	}
+}
+
+func TestMovePlayerBroadcast(t *testing.T) {
+	s := NewServer()
+	s.JoinMessage = ""
+	s.Start()
+	defer s.Stop()
+	p, watcher := loginPlayer(t, s, "Steve", 1), loginPlayer(t, s, "Alex", 2)
+	sentPackets(t, watcher)
+	spawn := p.Position
+	MovePlayer{X: spawn.X + 0.5, Y: spawn.Y, Z: spawn.Z, Yaw: 45, BodyYaw: 45}.Handle(p)
+	MovePlayer{X: spawn.X + 1, Y: spawn.Y, Z: spawn.Z, Yaw: 90, BodyYaw: 90, Pitch: 10}.Handle(p)
+	if p.Position != (Vector3{X: spawn.X + 1, Y: spawn.Y, Z: spawn.Z}) || p.Yaw != 90 || p.BodyYaw != 90 || p.Pitch != 10 {
+		t.Fatal("Position is not updated:", p.Position)
+	}
+	var move *MoveEntity
+	for i := 0; move == nil || move.EntityPos[0][0] != spawn.X+1; i++ {
+		if i == 10 {
+			t.Fatal("Last position is not broadcasted")
+		}
+		time.Sleep(TickDuration)
+		for _, pk := range sentPackets(t, watcher) {
+			switch pk := pk.(type) {
+			case *MovePlayer:
+				t.Fatal("Move is broadcasted immediately, instead of being batched")
+			case *MoveEntity:
+				if len(pk.EntityIDs) != 1 || pk.EntityIDs[0] != p.EntityID {
+					t.Fatal("Unexpected move broadcast:", pk.EntityIDs)
+				}
+				move = pk
+			}
+		}
+	}
+
+	s = NewServer()
+	s.JoinMessage = ""
+	s.Start()
+	defer s.Stop()
+	p = loginPlayer(t, s, "Steve", 1)
+	sentPackets(t, p)
+	spawn = p.Position
+	MovePlayer{X: spawn.X + 100, Y: spawn.Y, Z: spawn.Z}.Handle(p)
+	pks := sentPackets(t, p)
+	if p.Position != spawn || len(pks) != 1 {
+		t.Fatal("Teleport-speed movement is accepted")
+	}
+	if move, ok := pks[0].(*MovePlayer); !ok || move.Mode != ModeReset || move.X != spawn.X {
+		t.Fatal("Position is not corrected:", pks[0])
+	}
+}
+
+func TestChat(t *testing.T) {
+	s := NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	broadcasts := serveTest(s, stop)
+	p := testPlayer(s, testLevel(), GamemodeSurvival)
+	p.Username = "Steve"
+	Text{TextType: TextTypeChat, Message: " hi\x00\x1b there \n"}.Handle(p)
+	req := <-broadcasts
+	if text, ok := req.packet.(*Text); !ok || text.TextType != TextTypeChat || text.Source != "Steve" || text.Message != "hi there" || req.filter != nil {
+		t.Fatal("Unexpected chat broadcast:", req.packet)
+	}
+	Text{TextType: TextTypeChat, Message: "\t\n"}.Handle(p)
+	s.Message("sync")
+	if text := (<-broadcasts).packet.(*Text); text.Message != "sync" {
+		t.Fatal("Empty chat message is broadcasted")
+	}
+
+	if msg := sanitizeChat(strings.Repeat("가", MaxChatLength+45)); len([]rune(msg)) != MaxChatLength {
+		t.Fatal("Chat message is not truncated:", len([]rune(msg)))
+	}
+}
+
+func TestRequestChunkRadius(t *testing.T) {
+	s := NewServer()
+	s.MaxChunkRadius = 6
+	p := testPlayer(s, testLevel(), GamemodeSurvival)
+	p.spawned = false // Chunks are sent on spawn
+	for _, c := range []struct{ requested, granted uint32 }{{16, 6}, {2, 2}, {0, 1}} {
+		RequestChunkRadius{Radius: c.requested}.Handle(p)
+		if p.chunkRadius != int32(c.granted) {
+			t.Fatal("Radius", c.requested, "is granted as", p.chunkRadius)
+		}
+		if pks := sentPackets(t, p); len(pks) != 1 || pks[0].(*ChunkRadiusUpdate).Radius != c.granted {
+			t.Fatal("Unexpected reply to radius", c.requested, ":", pks)
+		}
+	}
+}
+
+func TestLoginConfig(t *testing.T) {
+	config := DefaultConfig
+	config.Gamemode = GamemodeSurvival
+	config.SpawnPosition = Vector3{X: 100, Y: 70, Z: -30}
+	config.Seed = 1234
+	s := NewServer(config)
+	s.JoinMessage = ""
+	s.Start()
+	defer s.Stop()
+	p := loginPlayer(t, s, "Steve", 1)
+	start, ok := sentPackets(t, p)[1].(*StartGame)
+	if !ok {
+		t.Fatal("StartGame is not sent after LoginSuccess")
+	}
+	if start.Gamemode != 0 || int32(start.SpawnX) != 100 || start.SpawnY != 70 || int32(start.SpawnZ) != -30 || start.Seed != 1234 {
+		t.Fatal("Unexpected StartGame:", start)
+	}
+	if start.X != 100 || start.Y != 70 || start.Z != -30 || p.Gamemode != GamemodeSurvival {
+		t.Fatal("Player is not spawned with config")
+	}
+	if NewServer().Config.Gamemode != GamemodeCreative {
+		t.Fatal("Default config is not used with NewServer()")
+	}
+}
+
+func TestContainerSetSlot(t *testing.T) {
+	p := testPlayer(NewServer(), testLevel(), GamemodeSurvival)
+	inv := make(Inventory, 4)
+	inv[0] = Item{ID: Stone, Amount: 10}
+	inv[1] = Item{ID: Dirt, Amount: 5}
+	p.inventory.Inventory, p.inventory.Holder = &inv, p
+	ContainerSetSlot{Windowid: InventoryWindow, Slot: 0, Item: &Item{ID: Dirt, Amount: 5}}.Handle(p)
+	ContainerSetSlot{Windowid: InventoryWindow, Slot: 1, Item: &Item{ID: Stone, Amount: 10}}.Handle(p)
+	if inv[0].ID != Dirt || inv[1].ID != Stone || len(sentPackets(t, p)) != 0 {
+		t.Fatal("Swap is not applied:", inv)
+	}
+
+	ContainerSetSlot{Windowid: InventoryWindow, Slot: 2, Item: &Item{ID: DiamondSword, Amount: 1}}.Handle(p)
+	if inv[2].ID != 0 {
+		t.Fatal("Item injection is accepted in survival")
+	}
+	if pks := sentPackets(t, p); len(pks) != 1 || pks[0].(*ContainerSetSlot).Slot != 2 || pks[0].(*ContainerSetSlot).Item.ID != 0 {
+		t.Fatal("Authoritative slot is not resent:", pks)
+	}
+
+	ContainerSetSlot{Windowid: 50, Slot: 0, Item: &Item{ID: Stone, Amount: 1}}.Handle(p) // Not opened
+	if inv[0].ID != Dirt || inv[0].Amount != 5 {
+		t.Fatal("Slot change on unknown window is applied:", inv)
+	}
+}
+
+func TestMobEquipment(t *testing.T) {
+	s := NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	broadcasts := serveTest(s, stop)
+	p := testPlayer(s, testLevel(), GamemodeSurvival)
+	p.EntityID = 7
+	inv := make(Inventory, PlayerInventorySize)
+	inv[3] = Item{ID: Stone, Amount: 5}
+	p.inventory.Inventory, p.inventory.Holder = &inv, p
+	MobEquipment{Item: &Item{ID: Stone, Amount: 5}, Slot: 3 + HotbarSize, SelectedSlot: 2}.Handle(p)
+	if p.inventory.Hand.ID != Stone || p.inventory.SelectedSlot != 2 || p.inventory.HotbarLinks[2] != 3 {
+		t.Fatal("Hand is not updated:", p.inventory.Hand, p.inventory.SelectedSlot)
+	}
+	req := <-broadcasts
+	if equip, ok := req.packet.(*MobEquipment); !ok || equip.EntityID != 7 || equip.Item.ID != Stone {
+		t.Fatal("Unexpected equipment broadcast:", req.packet)
+	}
+	viewer := &player{EntityID: 8, playerShown: map[uint64]struct{}{7: {}}}
+	if !req.filter(viewer) || req.filter(&player{EntityID: 8}) || req.filter(p) {
+		t.Fatal("Equipment broadcast is not filtered by viewers")
+	}
+
+	for _, equip := range []MobEquipment{
+		{Item: &Item{ID: Stone, Amount: 5}, Slot: 3 + HotbarSize, SelectedSlot: HotbarSize}, // Out of hotbar
+		{Item: &Item{ID: DiamondSword, Amount: 1}, Slot: 4 + HotbarSize, SelectedSlot: 1},   // Not in inventory
+	} {
+		equip.Handle(p)
+		pks := sentPackets(t, p)
+		if len(pks) != 1 || pks[0].(*MobEquipment).Item.ID != Stone || pks[0].(*MobEquipment).SelectedSlot != 2 {
+			t.Fatal("Client is not corrected:", pks)
+		}
+	}
+	if p.inventory.SelectedSlot != 2 || p.inventory.Hand.ID != Stone {
+		t.Fatal("Invalid equipment is applied")
+	}
+}
+
+func TestMobArmorEquipment(t *testing.T) {
+	s := NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	broadcasts := serveTest(s, stop)
+	p := testPlayer(s, testLevel(), GamemodeCreative)
+	p.EntityID = 7
+	inv := make(Inventory, PlayerInventorySize)
+	p.inventory.Inventory, p.inventory.Holder = &inv, p
+	MobArmorEquipment{Slots: [4]*Item{{ID: IronHelmet, Amount: 1}, nil, nil, {ID: GoldBoots, Amount: 1}}}.Handle(p)
+	if p.inventory.Armor[0].ID != IronHelmet || p.inventory.Armor[3].ID != GoldBoots {
+		t.Fatal("Armor is not stored:", p.inventory.Armor)
+	}
+	if armor, ok := (<-broadcasts).packet.(*MobArmorEquipment); !ok || armor.EntityID != 7 || armor.Slots[0].ID != IronHelmet {
+		t.Fatal("Armor is not broadcasted")
+	}
+
+	expectResend := func() {
+		pks := sentPackets(t, p)
+		if len(pks) != 1 {
+			t.Fatal("Armor is not resent:", pks)
+		}
+		if content, ok := pks[0].(*ContainerSetContent); !ok || content.WindowID != ArmorWindow || content.Slots[0].ID != IronHelmet {
+			t.Fatal("Unexpected armor resend:", pks[0])
+		}
+	}
+	MobArmorEquipment{Slots: [4]*Item{{ID: DiamondSword, Amount: 1}, nil, nil, nil}}.Handle(p)
+	expectResend()
+	MobArmorEquipment{Slots: [4]*Item{nil, {ID: DiamondBoots, Amount: 1}, nil, nil}}.Handle(p) // Boots on chestplate slot
+	expectResend()
+	p.Gamemode = GamemodeSurvival
+	MobArmorEquipment{Slots: [4]*Item{{ID: DiamondHelmet, Amount: 1}, nil, nil, {ID: GoldBoots, Amount: 1}}}.Handle(p) // Not in inventory
+	expectResend()
+	if p.inventory.Armor[0].ID != IronHelmet || p.inventory.Armor[3].ID != GoldBoots {
+		t.Fatal("Invalid armor is stored:", p.inventory.Armor)
+	}
+}
@@ -2,6 +2,7 @@ package highmc
 
 import (
 	"bytes"
+	"fmt"
 	"net"
 	"time"
 )
@@ -31,6 +32,16 @@ type EncapsulatedPacket struct {
 	SplitIndex   uint32
 }
 
+// MTU and packet size limits
+const (
+	MinMTU        = 400  // Smaller MTU sizes from clients are raised to this
+	MaxMTU        = 1492 // Larger MTU sizes from clients are lowered to this
+	MaxPacketSize = 1 << 21
+	// MaxSplitCount is a max count of splits per packet, which is max packet size divided by min split size.
+	MaxSplitCount = MaxPacketSize / (MinMTU - splitOverhead)
+	splitOverhead = 34 // Datagram and encapsulation header size of split packets
+)
+
 // NewEncapsulated returns decoded EncapsulatedPacket struct from given binary.
 // Do NOT set buf with *Packet struct. It could cause panic.
 func NewEncapsulated(buf *bytes.Buffer) (ep *EncapsulatedPacket) {
@@ -56,6 +67,9 @@ func NewEncapsulated(buf *bytes.Buffer) (ep *EncapsulatedPacket) {
 		ep.SplitCount = ReadInt(buf)
 		ep.SplitID = ReadShort(buf)
 		ep.SplitIndex = ReadInt(buf)
+		if ep.SplitCount == 0 || ep.SplitCount > MaxSplitCount {
+			panic(fmt.Sprintf("invalid split count %d", ep.SplitCount))
+		}
 	}
 	b, err := Read(buf, int(length))
 	if err != nil {
@@ -0,0 +1,136 @@
+package highmc
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OpsFile is a path to the file where op levels are saved.
+var OpsFile = "ops.txt"
+
+// Op levels
+const (
+	OpLevelNone       = 0 // Not an operator
+	OpLevelModerator  = 1
+	OpLevelGamemaster = 2
+	OpLevelAdmin      = 3
+	OpLevelOwner      = 4
+	DefaultOpLevel    = OpLevelOwner
+)
+
+// opList is a goroutine-safe set of op levels, keyed by lowercase username.
+type opList struct {
+	levels map[string]int
+	mutex  *sync.RWMutex
+}
+
+func newOpList() *opList {
+	return &opList{
+		levels: make(map[string]int),
+		mutex:  new(sync.RWMutex),
+	}
+}
+
+// load reads op levels from OpsFile. Each line is formatted as "username level".
+// Missing file is not an error.
+func (ol *opList) load() error {
+	f, err := os.Open(OpsFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	ol.mutex.Lock()
+	defer ol.mutex.Unlock()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		level := DefaultOpLevel
+		if len(fields) > 1 {
+			if level, err = strconv.Atoi(fields[1]); err != nil {
+				return fmt.Errorf("invalid op level for %s: %v", fields[0], err)
+			}
+		}
+		ol.levels[strings.ToLower(fields[0])] = level
+	}
+	return sc.Err()
+}
+
+// save writes op levels to OpsFile.
+// Caller should hold the lock.
+func (ol *opList) save() error {
+	f, err := os.Create(OpsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for name, level := range ol.levels {
+		if _, err := fmt.Fprintf(f, "%s %d\n", name, level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ol *opList) get(name string) int {
+	ol.mutex.RLock()
+	defer ol.mutex.RUnlock()
+	return ol.levels[strings.ToLower(name)]
+}
+
+func (ol *opList) set(name string, level int) error {
+	ol.mutex.Lock()
+	defer ol.mutex.Unlock()
+	if level <= OpLevelNone {
+		delete(ol.levels, strings.ToLower(name))
+	} else {
+		ol.levels[strings.ToLower(name)] = level
+	}
+	return ol.save()
+}
+
+// OpLevel returns op level of the player with given name.
+func (s *Server) OpLevel(name string) int {
+	return s.ops.get(name)
+}
+
+// IsOp returns whether the player with given name is an operator.
+func (s *Server) IsOp(name string) bool {
+	return s.OpLevel(name) > OpLevelNone
+}
+
+// SetOpLevel sets op level of the player with given name, and saves it to OpsFile.
+// Set OpLevelNone to deop the player.
+// If the player is online, new permission is sent to the player.
+func (s *Server) SetOpLevel(name string, level int) error {
+	if err := s.ops.set(name, level); err != nil {
+		log.Println("Error while saving ops:", err)
+		return err
+	}
+	s.BroadcastPacket(s.adventureSettings(name), func(p *player) bool {
+		return strings.EqualFold(p.Username, name)
+	})
+	return nil
+}
+
+// adventureSettings returns AdventureSettings packet with permissions of the player with given name.
+func (s *Server) adventureSettings(name string) *AdventureSettings {
+	perm := PermissionNormal
+	if s.IsOp(name) {
+		perm = PermissionOperator
+	}
+	return &AdventureSettings{
+		Flags:            0,
+		UserPermission:   perm,
+		GlobalPermission: PermissionNormal,
+	}
+}
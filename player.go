@@ -2,6 +2,7 @@ package highmc
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"log"
 	"sync"
@@ -30,36 +31,66 @@ type player struct {
 	EntityID uint64
 	Skin     []byte
 	SkinName string
+	Protocol uint32 // Negotiated protocol version, one of SupportedProtocols
 
 	Position            Vector3
 	Level               *Level
 	Yaw, BodyYaw, Pitch float32
+	Gamemode            uint32
 
 	playerShown map[uint64]struct{}
 
-	inventory *PlayerInventory
+	inventory      *PlayerInventory
+	openContainers map[byte]*Inventory // Window ID: container inventory opened with OpenContainer
 
 	SendRequest           chan MCPEPacket
 	SendCompressedRequest chan []MCPEPacket
+	SendRawRequest        chan *EncapsulatedPacket // Shared between recipients: do not modify
 
-	chunkUpdate *time.Ticker
 	chunkResult chan chunkResult
+	sentChunks  map[ChunkPos]struct{} // Chunks sent to client
+	chunkCenter ChunkPos              // Center of sentChunks
+	chunkRadius int32
+
+	breaking   BlockPos  // Block position the player started breaking
+	breakStart time.Time // Zero if the player is not breaking any block
 
 	loggedIn bool
 	spawned  bool
+	pending  []Handleable // Inventory packets received before spawn
 	once     *sync.Once
 }
 
+// MaxPendingPackets is a maximum count of inventory packets deferred until the player spawns.
+// Exceeding packets are dropped.
+const MaxPendingPackets = 16
+
+// spawnGatedPackets is a set of inventory packet IDs which are deferred until the player spawns.
+var spawnGatedPackets = map[byte]struct{}{
+	MobEquipmentHead:        {},
+	MobArmorEquipmentHead:   {},
+	ContainerSetSlotHead:    {},
+	ContainerSetContentHead: {},
+	ContainerCloseHead:      {},
+	DropItemHead:            {},
+	CraftingEventHead:       {},
+}
+
 // NewPlayer creates new player struct.
 func NewPlayer(session *session) *player {
 	p := new(player)
 	p.session = session
-	// p.Level = p.Server.GetDefaultLevel()
 	p.EntityID = atomic.AddUint64(&lastEntityID, 1)
+	p.Gamemode = GamemodeCreative
 
 	p.SendRequest = make(chan MCPEPacket, chanBufsize)
 	p.SendCompressedRequest = make(chan []MCPEPacket, chanBufsize)
+	p.SendRawRequest = make(chan *EncapsulatedPacket, chanBufsize)
 	p.inventory = new(PlayerInventory)
+	p.openContainers = make(map[byte]*Inventory)
+	p.sentChunks = make(map[ChunkPos]struct{})
+	p.chunkResult = make(chan chunkResult, chanBufsize)
+	p.chunkRadius = DefaultChunkRadius
 
 	p.once = new(sync.Once)
 	return p
@@ -79,6 +110,14 @@ func (p *player) HandlePacket(buf *bytes.Buffer) error {
 		return nil // There is no handler for the packet
 	}
 	handler.Read(buf)
+	if _, ok := spawnGatedPackets[head]; ok && !p.spawned {
+		if len(p.pending) >= MaxPendingPackets {
+			log.Printf("Dropping inventory packet 0x%02x from %s: not spawned yet", head, p.Username)
+			return nil
+		}
+		p.pending = append(p.pending, handler) // buf is not recycled, as handler may refer to it
+		return nil
+	}
 	if err := handler.Handle(p); err != nil {
 		log.Println("Error while handling packet:", err)
 		return err
@@ -87,44 +126,112 @@ func (p *player) HandlePacket(buf *bytes.Buffer) error {
 	return nil
 }
 
-func (p *player) firstSpawn() {
-	chunk := new(Chunk)
-	for x := byte(0); x < byte(16); x++ {
-		for z := byte(0); z < byte(16); z++ {
-			for y := byte(0); y < byte(56); y++ {
-				chunk.SetBlock(x, y, z, Dirt.Block())
-			}
-			chunk.SetBlock(x, 56, z, Grass.Block())
-			chunk.SetBiomeColor(x, z, 20, 128, 10)
-		}
-	}
-	payload := chunk.FullChunkData()
-	radius := int32(3)
-	for cx := int32(0) - radius; cx <= radius; cx++ {
-		for cz := int32(0) - radius; cz <= radius; cz++ {
-			p.SendCompressed(&FullChunkData{
-				ChunkX:  uint32(cx),
-				ChunkZ:  uint32(cz),
-				Order:   OrderLayered,
-				Payload: payload,
-			})
-		}
+// tick runs periodic player tasks on packet handling goroutine, such as reverting expired slot transactions.
+func (p *player) tick() {
+	p.lockContainers(func() {
+		p.inventory.ExpireTransaction(time.Now())
+	})
+}
+
+// DefaultChunkRadius is a default view radius of players, in chunks.
+const DefaultChunkRadius = 3
+
+// DefaultMaxChunkRadius is a default value for Config.ViewDistance and Server.MaxChunkRadius.
+const DefaultMaxChunkRadius = 8
+
+// Player bounding box sizes. Player position is on the eye level.
+const (
+	PlayerWidth     = 0.6
+	PlayerHeight    = 1.8
+	PlayerEyeHeight = 1.62
+)
+
+// intersectsPlayer returns whether the block on given position intersects with player bounding box.
+// pos is a player position, same as the one on MovePlayer packet.
+func intersectsPlayer(block BlockPos, pos Vector3) bool {
+	feet := pos.Y - PlayerEyeHeight
+	return float32(block.X) < pos.X+PlayerWidth/2 && float32(block.X+1) > pos.X-PlayerWidth/2 &&
+		float32(block.Y) < feet+PlayerHeight && float32(block.Y)+1 > feet &&
+		float32(block.Z) < pos.Z+PlayerWidth/2 && float32(block.Z+1) > pos.Z-PlayerWidth/2
+}
+
+// spawn sends the spawn sequence to the logged in player, and registers the player to the server.
+// The sequence is StartGame, SetTime, SetSpawnPosition, SetDifficulty, AdventureSettings, inventory contents,
+// CraftingData, chunks and PlayStatus(PlayerSpawn). Registration sends player list and nearby players,
+// and the player is marked spawned after that. If registration fails, sent chunks are released
+// and deferred inventory packets are dropped.
+func (p *player) spawn() error {
+	if p.spawned {
+		return fmt.Errorf("player %s is already spawned", p.Username)
 	}
-	p.SendPacket(&AdventureSettings{
-		Flags:            0,
-		UserPermission:   0x02,
-		GlobalPermission: 0x02,
+	spawn := p.Server.Config.SpawnPosition
+	p.SendPacket(&StartGame{
+		Seed:      p.Server.Config.Seed,
+		Dimension: 0,
+		Generator: 1, // 0: old, 1: infinite, 2: flat
+		Gamemode:  p.Gamemode,
+		EntityID:  0, // Player eid set to 0
+		SpawnX:    uint32(int32(spawn.X)),
+		SpawnY:    uint32(int32(spawn.Y)),
+		SpawnZ:    uint32(int32(spawn.Z)),
+		X:         p.Position.X,
+		Y:         p.Position.Y,
+		Z:         p.Position.Z,
+	})
+	p.SendPacket(&SetTime{Started: true})
+	p.SendPacket(&SetSpawnPosition{
+		X: uint32(int32(spawn.X)),
+		Y: uint32(int32(spawn.Y)),
+		Z: uint32(int32(spawn.Z)),
 	})
+	p.SendPacket(&SetDifficulty{Difficulty: p.Level.Difficulty})
+	p.SendPacket(p.Server.adventureSettings(p.Username))
+	p.inventory.Holder = p
+	p.inventory.Init()
+	p.SendCompressed(&CraftingData{
+		Recipes:      RecipeRegistry,
+		CleanRecipes: true,
+	})
+	for _, pos := range p.unsentChunks(GetChunkPos(p.Position.BlockPos())) {
+		p.sendChunk(pos.X, pos.Z, p.Level.CreateChunk(pos))
+	}
+	p.Level.RLock()
+	items := make([]*AddItemEntity, 0, len(p.Level.Items))
+	for _, e := range p.Level.Items {
+		items = append(items, e.AddPacket())
+	}
+	p.Level.RUnlock()
+	for _, pk := range items {
+		p.SendPacket(pk)
+	}
 	p.SendPacket(&PlayStatus{
 		Status: PlayerSpawn,
 	})
 	log.Println("PlayStatus PlayerSpawn")
+	if err := p.Server.RegisterPlayer(p); err != nil {
+		p.releaseChunks()
+		p.pending = nil
+		return err
+	}
+	p.setSpawned()
+	return nil
+}
+
+// setSpawned marks the player spawned, and handles deferred inventory packets.
+func (p *player) setSpawned() {
+	p.spawned = true
+	pending := p.pending
+	p.pending = nil
+	for _, handler := range pending {
+		if err := handler.Handle(p); err != nil {
+			log.Println("Error while handling deferred packet:", err)
+		}
+	}
 }
 
 func (p *player) process() {
-	p.chunkUpdate = time.NewTicker(time.Millisecond * 200)
-	p.chunkResult = make(chan chunkResult, chanBufsize)
-	// chunkReq := make(chan [2]int32, chanBufsize)
+	atomic.AddInt64(&sessionGoroutines, 1)
+	defer atomic.AddInt64(&sessionGoroutines, -1)
 	for {
 		select {
 		case <-p.closed:
@@ -133,30 +240,159 @@ func (p *player) process() {
 			}
 			return
 		case res := <-p.chunkResult:
-			if res.chunk == nil {
-				log.Println("Chunk gen on", res.cx, res.cz, "failed")
-				continue
-			}
-			// TODO: mark sent chunks
-			p.SendCompressed(&FullChunkData{
-				ChunkX:  uint32(res.cx),
-				ChunkZ:  uint32(res.cz),
-				Order:   OrderLayered,
-				Payload: res.chunk.FullChunkData(),
-			})
+			p.sendChunk(res.cx, res.cz, res.chunk)
 		case pk := <-p.SendRequest:
 			p.SendPacket(pk)
 		case pks := <-p.SendCompressedRequest:
 			p.SendCompressed(pks...)
-
-			// case <-p.chunkUpdate.C:
-			// 	    p.updateChunk()
+		case ep := <-p.SendRawRequest:
+			p.EncapsulatedChan <- ep
 		}
 	}
 }
 
+// updateChunk sends chunks newly entered view radius, if the player moved to other chunk.
+// It must be called on packet handling goroutine.
 func (p *player) updateChunk() {
-	// TODO
+	center := GetChunkPos(p.Position.BlockPos())
+	if center == p.chunkCenter && len(p.sentChunks) > 0 {
+		return
+	}
+	p.updateChunks(center)
+}
+
+// updateChunks requests chunks within view radius from center, which are not sent yet.
+// Chunks are loaded from player's level on other goroutines, and sent on player process goroutine.
+func (p *player) updateChunks(center ChunkPos) {
+	for _, pos := range p.unsentChunks(center) {
+		go p.requestChunk(pos)
+	}
+}
+
+// unsentChunks returns chunks within view radius from center which are not sent yet, and marks them sent.
+// Chunks out of view radius are removed from loaded chunks, so they will be sent again when player comes back.
+// Sent chunks are referenced on the level, so they are not unloaded while the player sees them.
+// MCPE protocol has no packet to unload chunks: client frees them by itself, once server stops refreshing.
+func (p *player) unsentChunks(center ChunkPos) []ChunkPos {
+	p.chunkCenter = center
+	r := p.chunkRadius
+	for pos := range p.sentChunks {
+		if pos.X < center.X-r || pos.X > center.X+r || pos.Z < center.Z-r || pos.Z > center.Z+r {
+			delete(p.sentChunks, pos)
+			p.Level.UnrefChunk(pos)
+		}
+	}
+	var unsent []ChunkPos
+	for cx := center.X - r; cx <= center.X+r; cx++ {
+		for cz := center.Z - r; cz <= center.Z+r; cz++ {
+			pos := ChunkPos{X: cx, Z: cz}
+			if _, ok := p.sentChunks[pos]; ok {
+				continue
+			}
+			unsent = append(unsent, pos)
+			p.sentChunks[pos] = struct{}{}
+			p.Level.RefChunk(pos)
+		}
+	}
+	return unsent
+}
+
+// releaseChunks removes references on every chunks sent to the player.
+// It must be called on packet handling goroutine.
+func (p *player) releaseChunks() {
+	for pos := range p.sentChunks {
+		delete(p.sentChunks, pos)
+		p.Level.UnrefChunk(pos)
+	}
+}
+
+// requestChunk loads the chunk from player's level, and passes it to player process goroutine.
+func (p *player) requestChunk(pos ChunkPos) {
+	chunk := p.Level.CreateChunk(pos)
+	select {
+	case p.chunkResult <- chunkResult{cx: pos.X, cz: pos.Z, chunk: chunk}:
+	case <-p.closed:
+	}
+}
+
+// sendChunk sends FullChunkData of the chunk.
+func (p *player) sendChunk(cx, cz int32, chunk *Chunk) {
+	if chunk == nil {
+		log.Println("Chunk gen on", cx, cz, "failed")
+		return
+	}
+	p.SendCompressed(&FullChunkData{
+		ChunkX:  uint32(cx),
+		ChunkZ:  uint32(cz),
+		Order:   OrderLayered,
+		Payload: chunk.FullChunkData(),
+	})
+}
+
+// GetLevel returns the level where player is.
+// Level is assigned when the player logs in, so it returns error before that.
+func (p *player) GetLevel() (*Level, error) {
+	if p.Level == nil {
+		return nil, fmt.Errorf("player %s is not in any level", p.Username)
+	}
+	return p.Level, nil
+}
+
+// ForcePosition moves the player to given position with MovePlayer ModeReset packet, which makes client hard-set its position.
+// Use this for teleports, respawns, or rejecting invalid moves. It must be called on packet handling goroutine.
+func (p *player) ForcePosition(pos Vector3, yaw, pitch float32) {
+	p.Position = pos
+	p.Yaw, p.BodyYaw, p.Pitch = yaw, yaw, pitch
+	p.SendPacket(&MovePlayer{
+		EntityID: 0, // Player eid set to 0
+		X:        pos.X,
+		Y:        pos.Y,
+		Z:        pos.Z,
+		Yaw:      yaw,
+		BodyYaw:  yaw,
+		Pitch:    pitch,
+		Mode:     ModeReset,
+	})
+	if p.loggedIn {
+		p.updateChunk()
+	}
+	p.Server.AddEntityMovement(p.EntityID, pos.X, pos.Y, pos.Z, yaw, yaw, pitch)
+}
+
+// RosterEntry returns roster information of the player, except Position which is tracked by the server.
+func (p *player) RosterEntry() RosterEntry {
+	return RosterEntry{
+		RawUUID:  p.UUID,
+		Username: p.Username,
+		EntityID: p.EntityID,
+		SkinName: p.SkinName,
+		Skin:     p.Skin,
+		Ping:     p.Ping(),
+	}
+}
+
+// SendMessage sends raw text message to the player.
+func (p *player) SendMessage(msg string) {
+	p.SendPacket(&Text{
+		TextType: TextTypeRaw,
+		Message:  msg,
+	})
+}
+
+// SendPopup sends popup message to the player, shown above the hotbar.
+func (p *player) SendPopup(msg string) {
+	p.SendPacket(&Text{
+		TextType: TextTypePopup,
+		Message:  msg,
+	})
+}
+
+// SendTip sends tip message to the player, shown above the hotbar for a short time.
+func (p *player) SendTip(msg string) {
+	p.SendPacket(&Text{
+		TextType: TextTypeTip,
+		Message:  msg,
+	})
 }
 
 // BroadcastOthers sends message to all other players.
@@ -174,6 +410,16 @@ func (p *player) BroadcastOthers(msg string) {
 // If you supply nothing, or "" for ToSend, it'll be set to default.
 // Similarly, if you supply "" or nothing for ToLog, it'll be same as ToSend.
 func (p *player) Disconnect(opts ...string) {
+	p.disconnect(false, opts...)
+}
+
+// Kick disconnects player with given reason.
+// If silent is true, quit message will not be broadcasted, but QuitHandlers are still called.
+func (p *player) Kick(reason string, silent bool) {
+	p.disconnect(silent, reason)
+}
+
+func (p *player) disconnect(silent bool, opts ...string) {
 	var msg, log string
 	if len(opts) == 0 || opts[0] == "" {
 		msg = "Generic reason"
@@ -188,19 +434,235 @@ func (p *player) Disconnect(opts ...string) {
 	p.SendPacket(&Disconnect{
 		Message: msg,
 	})
-	p.BroadcastOthers(p.Username + " quit the game")
-	p.Close(log)
+	if p.spawned {
+		p.quitMessage(msg, silent)
+	}
+	p.CloseGraceful(log, DisconnectDrain)
+}
+
+// breakBlock removes the block if the player has broken it long enough, and drops it as an item in survival mode.
+// Adventure and spectator players can't break blocks. Rejected blocks are sent back to the player,
+// and removed blocks are sent to players on the same level.
+func (p *player) breakBlock(pos BlockPos) {
+	lv, err := p.GetLevel()
+	if err != nil {
+		return
+	}
+	start := p.breakStart
+	p.breakStart = time.Time{}
+	var block Block
+	var rejected bool
+	lv.RW(func(rw LevelReadWriter) {
+		if !rw.Available(pos) {
+			rejected = true
+			return
+		}
+		block = rw.Get(pos)
+		if block.ID == byte(Air) {
+			return
+		}
+		if p.Gamemode == GamemodeAdventure || p.Gamemode == GamemodeSpectator {
+			log.Println(p.Username, "tried to break block", block.ID, "in gamemode", p.Gamemode)
+			rejected = true
+			return
+		}
+		if p.Gamemode != GamemodeCreative {
+			min, ok := BreakTime(block.ID)
+			if !ok || start.IsZero() || p.breaking != pos || time.Since(start) < min {
+				log.Println(p.Username, "tried to break block", block.ID, "too fast")
+				rejected = true
+				return
+			}
+		}
+		rw.Set(pos, Block{})
+	})
+	record := BlockRecord{
+		X:     uint32(pos.X),
+		Y:     pos.Y,
+		Z:     uint32(pos.Z),
+		Flags: UpdateAllPriority,
+	}
+	if rejected {
+		record.Block = block
+		p.SendPacket(&UpdateBlock{BlockRecords: []BlockRecord{record}})
+		return
+	}
+	if block.ID == byte(Air) {
+		return
+	}
+	p.Server.BroadcastPacket(&UpdateBlock{BlockRecords: []BlockRecord{record}}, func(t *player) bool {
+		return t.Level == lv
+	})
+	if p.Gamemode == GamemodeSurvival {
+		lv.SpawnItem(Item{ID: ID(block.ID), Meta: uint16(block.Meta), Amount: 1}, Vector3{
+			X: float32(pos.X) + 0.5,
+			Y: float32(pos.Y) + 0.5,
+			Z: float32(pos.Z) + 0.5,
+		}, Vector3{Y: 0.1})
+	}
 }
 
-// SendCompressed sends packed BatchPacket with given packets.
+// pickupItems picks up dropped items near the player into the inventory.
+// It must be called on packet handling goroutine.
+func (p *player) pickupItems() {
+	if !p.spawned || p.Gamemode == GamemodeCreative || p.Gamemode == GamemodeSpectator {
+		return
+	}
+	taken := p.Level.PickupItems(p.Position, p.inventory.AddItem)
+	if len(taken) == 0 {
+		return
+	}
+	for _, e := range taken {
+		p.SendPacket(&TakeItemEntity{Target: e.EntityID, EntityID: 0}) // Player eid set to 0
+		p.Server.BroadcastPacket(&TakeItemEntity{Target: e.EntityID, EntityID: p.EntityID}, func(t *player) bool {
+			return t.Level == p.Level && t.EntityID != p.EntityID
+		})
+		p.Server.BroadcastPacket(&RemoveEntity{EntityID: e.EntityID}, func(t *player) bool {
+			return t.Level == p.Level
+		})
+	}
+	p.SendCompressed(&ContainerSetContent{
+		WindowID: InventoryWindow,
+		Slots:    *p.inventory.Inventory,
+		Hotbar:   p.inventory.HotbarLink(),
+	})
+}
+
+// OpenContainer opens the container inventory on client, and returns assigned window ID.
+// If the container is already open, its window ID is reused.
+// pos is a position of the container block. It must be called on packet handling goroutine.
+func (p *player) OpenContainer(inv *Inventory, typ byte, pos BlockPos) (byte, error) {
+	id, ok := p.containerWindow(inv)
+	for next := MinContainerWindow; !ok && next < ArmorWindow; next++ {
+		if _, used := p.openContainers[next]; !used {
+			id, ok = next, true
+		}
+	}
+	if !ok {
+		return 0, fmt.Errorf("player %s has too many open containers", p.Username)
+	}
+	p.openContainers[id] = inv
+	p.SendPacket(&ContainerOpen{
+		WindowID: id,
+		Type:     typ,
+		Slots:    uint16(len(*inv)),
+		X:        uint32(pos.X),
+		Y:        uint32(pos.Y),
+		Z:        uint32(pos.Z),
+	})
+	var slots []Item
+	p.lockContainers(func() {
+		slots = make([]Item, len(*inv))
+		copy(slots, *inv)
+	})
+	p.SendCompressed(&ContainerSetContent{
+		WindowID: id,
+		Slots:    slots,
+	})
+	return id, nil
+}
+
+// containerWindow returns window ID of the container inventory, if it is open.
+func (p *player) containerWindow(inv *Inventory) (byte, bool) {
+	for id, open := range p.openContainers {
+		if open == inv {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// lockContainers runs fn with player's level write-locked, if the player has open containers.
+// Container inventories such as chests are owned by the level, and shared with other viewers.
+// It must be called on packet handling goroutine, without level locked.
+func (p *player) lockContainers(fn func()) {
+	if len(p.openContainers) == 0 || p.Level == nil {
+		fn()
+		return
+	}
+	p.Level.Lock()
+	defer p.Level.Unlock()
+	fn()
+}
+
+// joinMessage runs JoinHandlers and broadcasts join message.
+func (p *player) joinMessage() {
+	ev := &PlayerJoinEvent{
+		Username: p.Username,
+	}
+	if p.Server.JoinMessage != "" {
+		ev.Message = fmt.Sprintf(p.Server.JoinMessage, p.Username)
+	}
+	for _, handler := range p.Server.JoinHandlers {
+		handler(ev)
+	}
+	if ev.Cancelled || ev.Message == "" {
+		return
+	}
+	p.Server.Message(ev.Message)
+}
+
+// quitMessage runs QuitHandlers and broadcasts quit message to other players.
+func (p *player) quitMessage(reason string, silent bool) {
+	ev := &PlayerQuitEvent{
+		Username: p.Username,
+		Reason:   reason,
+		Silent:   silent,
+	}
+	if p.Server.QuitMessage != "" {
+		ev.Message = fmt.Sprintf(p.Server.QuitMessage, p.Username)
+	}
+	for _, handler := range p.Server.QuitHandlers {
+		handler(ev)
+	}
+	if ev.Cancelled || ev.Silent || ev.Message == "" {
+		return
+	}
+	p.BroadcastOthers(ev.Message)
+}
+
+// BatchThreshold is a minimum total packet size for SendCompressed to pack packets into BatchPacket.
+// Smaller packets are not worth compressing, so they are sent as-is.
+const BatchThreshold = 512
+
+// SendCompressed sends given packets, packed into BatchPacket if their total size reaches BatchThreshold.
 func (p *player) SendCompressed(pks ...MCPEPacket) {
-	batch := &Batch{
-		Payloads: make([][]byte, len(pks)),
+	bufs := make([]*bytes.Buffer, len(pks))
+	size := 0
+	for i, pk := range pks {
+		bufs[i] = pk.Write()
+		size += bufs[i].Len()
+	}
+	if size < BatchThreshold {
+		for _, buf := range bufs {
+			p.SendRaw(buf)
+			Pool.Recycle(buf)
+		}
+		return
 	}
+	p.sendBatch(bufs)
+}
+
+// SendBatch sends packed BatchPacket with given packets, regardless of their size.
+func (p *player) SendBatch(pks ...MCPEPacket) {
+	bufs := make([]*bytes.Buffer, len(pks))
 	for i, pk := range pks {
-		batch.Payloads[i] = pk.Write().Bytes()
+		bufs[i] = pk.Write()
+	}
+	p.sendBatch(bufs)
+}
+
+func (p *player) sendBatch(bufs []*bytes.Buffer) {
+	batch := &Batch{
+		Payloads: make([][]byte, len(bufs)),
+	}
+	for i, buf := range bufs {
+		batch.Payloads[i] = buf.Bytes()
 	}
 	p.SendPacket(batch)
+	for _, buf := range bufs {
+		Pool.Recycle(buf)
+	}
 }
 
 func (p *player) SendPacket(pk MCPEPacket) {
@@ -0,0 +1,408 @@
+package highmc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSpawnRegisterFailure(t *testing.T) {
+	s := NewServer()
+	go func() {
+		req := <-s.registerRequest
+		req.ok <- fmt.Errorf("player exists with same address:port")
+	}()
+	lv := testLevel()
+	p := NewPlayer(&session{
+		EncapsulatedChan: make(chan *EncapsulatedPacket, 512),
+		Server:           s,
+		Address:          &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132},
+		mtuSize:          1400,
+	})
+	p.Level = lv
+	p.chunkRadius = 0
+	p.pending = append(p.pending, &ContainerSetSlot{Windowid: InventoryWindow, Item: &Item{ID: Dirt, Amount: 1}})
+	if err := p.spawn(); err == nil {
+		t.Fatal("Expected registration error")
+	}
+	if p.spawned || len(p.pending) != 0 {
+		t.Fatal("Player is spawned, or deferred packets are kept after registration failure")
+	}
+	if refs := atomic.LoadUint64(&lv.LoadedChunks[ChunkPos{}].Refs); refs != 0 || len(p.sentChunks) != 0 {
+		t.Fatal("Sent chunks are not released after registration failure:", refs)
+	}
+}
+
+func TestBreakBlock(t *testing.T) {
+	s := NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	broadcasts := serveTest(s, stop)
+	lv := testLevel()
+	pos := BlockPos{X: 1, Y: 10, Z: 1}
+	lv.Set(pos, Block{ID: Stone.Block()})
+
+	p := testPlayer(s, lv, GamemodeAdventure)
+	p.breaking, p.breakStart = pos, time.Now().Add(-time.Minute)
+	p.breakBlock(pos)
+	if lv.GetID(pos) != Stone.Block() || len(p.EncapsulatedChan) != 1 {
+		t.Fatal("Adventure player broke the block, or the block is not sent back")
+	}
+
+	p = testPlayer(s, lv, GamemodeSurvival)
+	p.breaking, p.breakStart = pos, time.Now().Add(-time.Minute)
+	p.breakBlock(pos)
+	if lv.GetID(pos) != Air.Block() {
+		t.Fatal("Block is not broken")
+	}
+	req := <-broadcasts
+	if !req.filter(&player{Level: lv}) || req.filter(&player{Level: testLevel()}) {
+		t.Fatal("Block update is not filtered by level")
+	}
+}
+
+func TestPlayerLevel(t *testing.T) {
+	s := NewServer()
+	s.Start()
+	defer s.Stop()
+	p := NewPlayer(&session{
+		EncapsulatedChan: make(chan *EncapsulatedPacket, 512),
+		Server:           s,
+		Address:          &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132},
+		closed:           make(chan struct{}),
+	})
+	if _, err := p.GetLevel(); err == nil {
+		t.Fatal("Player has level before registration")
+	}
+	if err := (UseItem{X: 1, Y: 1, Z: 1, Face: SideUp, Item: &Item{ID: Dirt, Amount: 1}}).Handle(p); err == nil {
+		t.Fatal("Level access before registration succeeded")
+	}
+	Login{Username: "Steve", Proto1: MinecraftProtocol}.Handle(p)
+	if lv, err := p.GetLevel(); err != nil || lv != s.GetDefaultLevel() {
+		t.Fatal("Registered player is not on default level:", err)
+	}
+}
+
+func TestChunkTracking(t *testing.T) {
+	lv := &Level{ChunkUnloadDelay: -1}
+	lv.Init()
+	defer lv.Close()
+	p := NewPlayer(&session{})
+	p.Level, p.chunkRadius = lv, 2
+	far := ChunkPos{X: -2}
+	if unsent := p.unsentChunks(ChunkPos{}); len(unsent) != 25 || len(p.sentChunks) != 25 {
+		t.Fatal("Unexpected initial chunks:", len(unsent))
+	}
+	p.unsentChunks(ChunkPos{X: 10})
+	if _, ok := p.sentChunks[far]; ok || len(p.sentChunks) != 25 {
+		t.Fatal("Chunk out of view radius is still tracked")
+	}
+	found := false
+	for _, pos := range p.unsentChunks(ChunkPos{}) {
+		found = found || pos == far
+	}
+	if _, ok := p.sentChunks[far]; !ok || !found {
+		t.Fatal("Chunk is not sent again on return")
+	}
+}
+
+func TestChunkDelta(t *testing.T) {
+	lv := &Level{ChunkUnloadDelay: -1}
+	lv.Init()
+	defer lv.Close()
+	p := NewPlayer(&session{})
+	p.Level, p.chunkRadius = lv, 2
+	p.unsentChunks(ChunkPos{})
+	delta := p.unsentChunks(ChunkPos{X: 1}) // Crossed the boundary to east
+	if len(delta) != 5 {
+		t.Fatal("Unexpected newly entered chunks:", delta)
+	}
+	for _, pos := range delta {
+		if pos.X != 3 {
+			t.Fatal("Chunk already sent is sent again:", pos)
+		}
+	}
+	if len(p.sentChunks) != 25 {
+		t.Fatal("Unexpected tracked chunks:", len(p.sentChunks))
+	}
+	lv.RLock()
+	defer lv.RUnlock()
+	for z := int32(-2); z <= 2; z++ {
+		if _, ok := p.sentChunks[ChunkPos{X: -2, Z: z}]; ok || lv.pendingRefs[ChunkPos{X: -2, Z: z}] != 0 {
+			t.Fatal("Left chunk is still tracked on", z)
+		}
+		if lv.pendingRefs[ChunkPos{X: 3, Z: z}] != 1 {
+			t.Fatal("Entered chunk is not referenced on", z)
+		}
+	}
+}
+
+func TestForcePosition(t *testing.T) {
+	s := NewServer()
+	s.Start()
+	defer s.Stop()
+	p := testPlayer(s, testLevel(), GamemodeSurvival)
+	p.ForcePosition(Vector3{X: 1, Y: 2, Z: -3}, 90, 45)
+	pks := sentPackets(t, p)
+	if len(pks) != 1 {
+		t.Fatal("Expected one MovePlayer, got", len(pks), "packets")
+	}
+	move, ok := pks[0].(*MovePlayer)
+	if !ok || move.Mode != ModeReset || move.X != 1 || move.Y != 2 || move.Z != -3 || move.Yaw != 90 || move.Pitch != 45 {
+		t.Fatal("Unexpected teleport packet:", pks[0])
+	}
+	if p.Position != (Vector3{X: 1, Y: 2, Z: -3}) {
+		t.Fatal("Player position is not updated")
+	}
+}
+
+func TestTextHelpers(t *testing.T) {
+	s := NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	broadcasts := serveTest(s, stop)
+	p := testPlayer(s, testLevel(), GamemodeSurvival)
+	for _, c := range []struct {
+		send, broadcast func(string)
+		textType        byte
+	}{
+		{p.SendMessage, s.Message, TextTypeRaw},
+		{p.SendPopup, s.BroadcastPopup, TextTypePopup},
+		{p.SendTip, s.BroadcastTip, TextTypeTip},
+	} {
+		c.send("hello")
+		pks := sentPackets(t, p)
+		if len(pks) != 1 {
+			t.Fatal("Expected one packet, got", len(pks))
+		}
+		if text, ok := pks[0].(*Text); !ok || text.TextType != c.textType || text.Message != "hello" {
+			t.Fatal("Unexpected text sent for type", c.textType, ":", pks)
+		}
+		c.broadcast("hello")
+		if text, ok := (<-broadcasts).packet.(*Text); !ok || text.TextType != c.textType || text.Message != "hello" {
+			t.Fatal("Unexpected text broadcasted for type", c.textType)
+		}
+	}
+}
+
+func TestQuitMessage(t *testing.T) {
+	s := NewServer()
+	s.QuitMessage = "%s left"
+	var events []PlayerQuitEvent
+	s.QuitHandlers = append(s.QuitHandlers, func(ev *PlayerQuitEvent) {
+		events = append(events, *ev)
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	broadcasts := serveTest(s, stop)
+	kick := func(name string, silent bool) {
+		p := testPlayer(s, testLevel(), GamemodeSurvival)
+		p.Username, p.EntityID = name, 1
+		p.closed = make(chan struct{})
+		defer close(p.closed)
+		p.Kick("bye", silent)
+	}
+
+	kick("Steve", false)
+	req := <-broadcasts
+	if text, ok := req.packet.(*Text); !ok || text.Message != "Steve left" {
+		t.Fatal("Custom quit message is not broadcasted:", req.packet)
+	}
+	if req.filter(&player{EntityID: 1}) || !req.filter(&player{EntityID: 2}) {
+		t.Fatal("Quit message is sent to the quitting player")
+	}
+
+	kick("Alex", true)
+	s.Message("sync")
+	if text := (<-broadcasts).packet.(*Text); text.Message != "sync" {
+		t.Fatal("Silent kick broadcasted quit message:", text.Message)
+	}
+	if len(events) != 2 || events[0].Silent || !events[1].Silent || events[1].Reason != "bye" || events[1].Message != "Alex left" {
+		t.Fatal("Unexpected quit events:", events)
+	}
+}
+
+func TestJoinMessage(t *testing.T) {
+	s := NewServer()
+	s.JoinMessage = "%s arrived"
+	var events []PlayerJoinEvent
+	s.JoinHandlers = append(s.JoinHandlers, func(ev *PlayerJoinEvent) {
+		ev.Message += "!"
+		events = append(events, *ev)
+	})
+	s.Start()
+	defer s.Stop()
+	other := NewPlayer(&session{
+		EncapsulatedChan: make(chan *EncapsulatedPacket, 16),
+		Server:           s,
+		Address:          &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1},
+		closed:           make(chan struct{}),
+	})
+	other.Username = "Alex"
+	other.SendRequest = make(chan MCPEPacket, 64)
+	other.once.Do(func() {}) // Packets are buffered on SendRequest, instead of being processed
+	if err := s.RegisterPlayer(other); err != nil {
+		t.Fatal(err)
+	}
+
+	loginPlayer(t, s, "Steve", 2)
+	if len(events) != 1 || events[0].Username != "Steve" {
+		t.Fatal("Unexpected join events:", events)
+	}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case pk := <-other.SendRequest:
+			if text, ok := pk.(*Text); ok {
+				if text.Message != "Steve arrived!" {
+					t.Fatal("Unexpected join message:", text.Message)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("Join message is not broadcasted")
+		}
+	}
+}
+
+func TestSendCompressed(t *testing.T) {
+	p := testPlayer(NewServer(), testLevel(), GamemodeSurvival)
+	payload := func() []byte {
+		if len(p.EncapsulatedChan) != 1 {
+			t.Fatal("Expected one packet, got", len(p.EncapsulatedChan))
+		}
+		return (<-p.EncapsulatedChan).Buffer.Bytes()[1:] // Skips 0x8e
+	}
+	small := &Text{TextType: TextTypeRaw, Message: "hello"}
+	p.SendCompressed(small)
+	if payload()[0] != TextHead {
+		t.Fatal("Small packet is batched")
+	}
+
+	large := make([]MCPEPacket, 10)
+	size := 0
+	for i := range large {
+		large[i] = &Text{TextType: TextTypeRaw, Message: strings.Repeat("hello", 20)}
+		size += large[i].Write().Len()
+	}
+	p.SendCompressed(large...)
+	b := payload()
+	batch := new(Batch)
+	batch.Read(bytes.NewBuffer(b[1:]))
+	if b[0] != BatchHead || len(batch.Payloads) != len(large) || len(b) >= size {
+		t.Fatal("Large packets are not batched and compressed")
+	}
+
+	p.SendBatch(small)
+	if payload()[0] != BatchHead {
+		t.Fatal("Explicit batch is not batched")
+	}
+}
+
+func TestSpawnGate(t *testing.T) {
+	p := testPlayer(NewServer(), testLevel(), GamemodeCreative)
+	p.spawned = false
+	p.inventory.Holder = p
+	p.inventory.Hotbars = make([]Item, CreativeHotbarSize)
+	item := CreativeItems[5]
+	for i := 0; i < MaxPendingPackets+1; i++ { // The last one is dropped
+		p.HandlePacket(ContainerSetSlot{Windowid: InventoryWindow, Slot: 2, Item: &item}.Write())
+	}
+	if len(p.pending) != MaxPendingPackets || p.inventory.Hotbars[2].ID != 0 {
+		t.Fatal("Inventory packet before spawn is not deferred")
+	}
+	p.setSpawned()
+	if len(p.pending) != 0 || p.inventory.Hotbars[2] != item {
+		t.Fatal("Deferred inventory packet is not handled after spawn")
+	}
+}
+
+func TestSpawnSequence(t *testing.T) {
+	s := NewServer()
+	s.JoinMessage = "" // Broadcasted asynchronously
+	s.Start()
+	defer s.Stop()
+	p := loginPlayer(t, s, "Steve", 1)
+	var sequence []string
+	chunks := 0
+	for _, pk := range sentPackets(t, p) {
+		switch pk := pk.(type) {
+		case *FullChunkData:
+			if chunks++; chunks > 1 {
+				continue
+			}
+		case *PlayStatus:
+			sequence = append(sequence, fmt.Sprint("PlayStatus", pk.Status))
+			continue
+		}
+		sequence = append(sequence, fmt.Sprintf("%T", pk)[len("*highmc."):])
+	}
+	expected := []string{
+		fmt.Sprint("PlayStatus", LoginSuccess),
+		"StartGame", "SetTime", "SetSpawnPosition", "SetDifficulty", "AdventureSettings",
+		"ContainerSetContent", "CraftingData", "FullChunkData",
+		fmt.Sprint("PlayStatus", PlayerSpawn),
+		"PlayerList",
+	}
+	if fmt.Sprint(sequence) != fmt.Sprint(expected) {
+		t.Fatal("Unexpected spawn sequence:", sequence)
+	}
+	if chunks != len(p.sentChunks) || !p.spawned {
+		t.Fatal("Player is not spawned after the sequence")
+	}
+	if err := p.spawn(); err == nil {
+		t.Fatal("Spawned player is spawned again")
+	}
+}
+
+func TestAddPlayerAfterSpawn(t *testing.T) {
+	s := NewServer()
+	s.JoinMessage = ""
+	s.Start()
+	defer s.Stop()
+	loginPlayer(t, s, "Alex", 1)
+	p := loginPlayer(t, s, "Steve", 2)
+	spawned, added := false, 0
+	for deadline := time.Now().Add(time.Second); added == 0 && time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		for _, pk := range sentPackets(t, p) {
+			switch pk := pk.(type) {
+			case *PlayStatus:
+				spawned = spawned || pk.Status == PlayerSpawn
+			case *AddPlayer:
+				if !spawned {
+					t.Fatal("AddPlayer is sent before PlayStatus(PlayerSpawn)")
+				}
+				if pk.Username != "Alex" {
+					t.Fatal("Unexpected AddPlayer:", pk.Username)
+				}
+				added++
+			}
+		}
+	}
+	if added != 1 {
+		t.Fatal("Existing player is added", added, "times")
+	}
+}
+
+func TestSpawnOnce(t *testing.T) {
+	s := NewServer()
+	s.JoinMessage = ""
+	s.Start()
+	defer s.Stop()
+	p := loginPlayer(t, s, "Steve", 1)
+	sentPackets(t, p)
+	Login{Username: "Steve", Proto1: MinecraftProtocol}.Handle(p)
+	for _, pk := range sentPackets(t, p) {
+		switch pk.(type) {
+		case *PlayStatus, *StartGame, *FullChunkData:
+			t.Fatal("Spawn sequence is sent again on second Login:", pk)
+		}
+	}
+	if roster := s.Roster(); len(roster) != 1 {
+		t.Fatal("Player is registered more than once:", roster)
+	}
+}
@@ -16,20 +16,45 @@ const (
 	MinecraftVersion = "0.14.2"
 )
 
-// ServerName contains human readable server name
+// SupportedProtocols are mojang network protocol versions accepted on login, with human readable minecraft versions.
+// Register protocols before starting the server, as the map is not goroutine-safe.
+var SupportedProtocols = map[uint32]string{
+	MinecraftProtocol: MinecraftVersion,
+}
+
+// protocolRange returns lowest and highest protocol version in SupportedProtocols.
+func protocolRange() (min, max uint32) {
+	protos := GetSortedKeys(SupportedProtocols)
+	if len(protos) == 0 {
+		return
+	}
+	return uint32(protos[0]), uint32(protos[len(protos)-1])
+}
+
+// ServerName contains human readable server name, used by servers without Config.MOTD.
 var ServerName string
 
-// OnlinePlayers is count of online players
+// OnlinePlayers is count of online players on every servers, updated when players are registered/unregistered on Server.
+// Access it with sync/atomic.
 var OnlinePlayers int32
 
-// MaxPlayers is count of maximum available players
+// MaxPlayers is count of maximum available players, for GetServerString.
+// Servers advertise their own Server.MaxPlayers instead. Access it with sync/atomic.
 var MaxPlayers int32
 
-// GetServerString returns server status message for unconnected pong
+// GetServerString returns server status message for unconnected pong, with package-level ServerName and player counts.
+// It is used by routers without owner server: see Server.ServerString.
 func GetServerString() string {
-	return "MCPE;" + ServerName + ";" +
-		strconv.Itoa(MinecraftProtocol) + ";" +
-		MinecraftVersion + ";" +
-		strconv.Itoa(int(atomic.LoadInt32(&OnlinePlayers))) + ";" +
-		strconv.Itoa(int(atomic.LoadInt32(&MaxPlayers)))
+	return serverString(ServerName, atomic.LoadInt32(&OnlinePlayers), atomic.LoadInt32(&MaxPlayers))
+}
+
+// serverString returns server status message for unconnected pong.
+// It advertises the lowest supported protocol version.
+func serverString(name string, online, max int32) string {
+	proto, _ := protocolRange()
+	return "MCPE;" + name + ";" +
+		strconv.Itoa(int(proto)) + ";" +
+		SupportedProtocols[proto] + ";" +
+		strconv.Itoa(int(online)) + ";" +
+		strconv.Itoa(int(max))
 }
@@ -2,18 +2,23 @@ package highmc
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"reflect"
 	"sync/atomic"
+	"time"
 )
 
 var handlers = map[byte]reflect.Type{
+	0x01: reflect.TypeOf(UnconnectedPing{}),
+	0x1c: reflect.TypeOf(UnconnectedPong{}),
 	0x05: reflect.TypeOf(OpenConnectionRequest1{}),
 	0x06: reflect.TypeOf(OpenConnectionReply1{}),
 	0x07: reflect.TypeOf(OpenConnectionRequest2{}),
 	0x08: reflect.TypeOf(OpenConnectionReply2{}),
+	0x14: reflect.TypeOf(NoFreeConnections{}),
 	0x80: reflect.TypeOf(GeneralDataPacket{}),
 	0xa0: reflect.TypeOf(Nack{}),
 	0xc0: reflect.TypeOf(Ack{}),
@@ -53,6 +58,27 @@ func GetRaknetPacket(pid byte) (proto RaknetPacket) {
 	return
 }
 
+// RegisterRaknetPacket adds custom Raknet packet type for given pid, with its prototype value.
+// Data packet range(0x80~0x8f) and already used pids are not allowed.
+// Call this before starting routers: registry is not goroutine-safe.
+func RegisterRaknetPacket(pid byte, proto RaknetPacket) error {
+	if pid >= 0x80 && pid < 0x90 {
+		return fmt.Errorf("Raknet packet 0x%02x is reserved for data packets", pid)
+	}
+	if _, ok := handlers[pid]; ok {
+		return fmt.Errorf("Raknet packet 0x%02x is already registered", pid)
+	}
+	typ := reflect.TypeOf(proto)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if _, ok := reflect.New(typ).Interface().(RaknetPacket); !ok {
+		return fmt.Errorf("%s does not implement RaknetPacket with pointer receiver", typ)
+	}
+	handlers[pid] = typ
+	return nil
+}
+
 // GetDataPacket returns datapacket with given packet ID.
 func GetDataPacket(pid byte) (proto RaknetPacket) {
 	if v, ok := dataPacketHandlers[pid]; ok {
@@ -68,6 +94,85 @@ type RaknetPacket interface {
 	Write(*bytes.Buffer) // NOTE: Write() should put pid before encoding with Pool.NewBuffer([]byte{), and should put target session address.
 }
 
+// UnconnectedPing is a packet used in Raknet.
+// It is handled on router without creating sessions, so Handle does nothing.
+type UnconnectedPing struct {
+	PingID uint64
+
+	err error // Decode error
+}
+
+// Read implements RaknetPacket interfaces.
+func (pk *UnconnectedPing) Read(buf *bytes.Buffer) {
+	if buf.Len() < 24 {
+		pk.err = Overflow{Need: 24, Got: buf.Len()}
+		return
+	}
+	pk.PingID = ReadLong(buf)
+	if magic := string(buf.Next(16)); magic != RaknetMagic {
+		pk.err = fmt.Errorf("invalid Raknet magic %x", magic)
+	}
+}
+
+// Handle implements RaknetPacket interfaces.
+func (pk *UnconnectedPing) Handle(session *session) {}
+
+// Write implements RaknetPacket interfaces.
+func (pk *UnconnectedPing) Write(buf *bytes.Buffer) {
+	buf.WriteByte(0x01)
+	WriteLong(buf, pk.PingID)
+	buf.Write([]byte(RaknetMagic))
+}
+
+// UnconnectedPong is a packet used in Raknet.
+type UnconnectedPong struct {
+	PingID       uint64
+	ServerID     uint64
+	ServerString string
+}
+
+// Read implements RaknetPacket interfaces.
+func (pk *UnconnectedPong) Read(buf *bytes.Buffer) {
+	pk.PingID = ReadLong(buf)
+	pk.ServerID = ReadLong(buf)
+	buf.Next(16) // Magic
+	pk.ServerString = ReadString(buf)
+}
+
+// Handle implements RaknetPacket interfaces.
+func (pk *UnconnectedPong) Handle(session *session) {}
+
+// Write implements RaknetPacket interfaces.
+func (pk *UnconnectedPong) Write(buf *bytes.Buffer) {
+	buf.WriteByte(0x1c)
+	WriteLong(buf, pk.PingID)
+	WriteLong(buf, pk.ServerID)
+	buf.Write([]byte(RaknetMagic))
+	WriteString(buf, pk.ServerString)
+}
+
+// NoFreeConnections is a packet used in Raknet.
+// Router sends this to refuse connection requests when it can't accept more sessions.
+type NoFreeConnections struct {
+	ServerID uint64
+}
+
+// Read implements RaknetPacket interfaces.
+func (pk *NoFreeConnections) Read(buf *bytes.Buffer) {
+	buf.Next(16) // Magic
+	pk.ServerID = ReadLong(buf)
+}
+
+// Handle implements RaknetPacket interfaces.
+func (pk *NoFreeConnections) Handle(session *session) {}
+
+// Write implements RaknetPacket interfaces.
+func (pk *NoFreeConnections) Write(buf *bytes.Buffer) {
+	buf.WriteByte(0x14)
+	buf.Write([]byte(RaknetMagic))
+	WriteLong(buf, pk.ServerID)
+}
+
 // OpenConnectionRequest1 is a packet used in Raknet.
 type OpenConnectionRequest1 struct {
 	Protocol byte
@@ -88,7 +193,7 @@ func (pk *OpenConnectionRequest1) Handle(session *session) {
 	}
 	buf := Pool.NewBuffer(nil)
 	p := &OpenConnectionReply1{
-		ServerID: serverID,
+		ServerID: session.serverID,
 		MtuSize:  uint16(pk.MtuSize),
 	}
 	p.Write(buf)
@@ -135,12 +240,20 @@ type OpenConnectionRequest2 struct {
 	ServerAddress *net.UDPAddr
 	MtuSize       uint16
 	ClientID      uint64
+
+	err error // Decode error
 }
 
 // Read implements RaknetPacket interfaces.
 func (pk *OpenConnectionRequest2) Read(buf *bytes.Buffer) {
 	buf.Next(16)
-	pk.ServerAddress = ReadAddress(buf)
+	if pk.ServerAddress, pk.err = ParseAddress(buf); pk.err != nil {
+		return
+	}
+	if buf.Len() < 10 {
+		pk.err = Overflow{Need: 10, Got: buf.Len()}
+		return
+	}
 	pk.MtuSize = ReadShort(buf)
 	pk.ClientID = ReadLong(buf)
 }
@@ -150,11 +263,20 @@ func (pk *OpenConnectionRequest2) Handle(session *session) {
 	if session.Status != 1 {
 		return
 	}
+	if pk.err != nil {
+		session.Close("malformed connection request: " + pk.err.Error())
+		return
+	}
 	session.ID = pk.ClientID
+	if pk.MtuSize < MinMTU {
+		pk.MtuSize = MinMTU
+	} else if pk.MtuSize > MaxMTU {
+		pk.MtuSize = MaxMTU
+	}
 	atomic.StoreUint32(&session.mtuSize, uint32(pk.MtuSize))
 	buf := Pool.NewBuffer(nil)
 	p := &OpenConnectionReply2{
-		ServerID:      serverID,
+		ServerID:      session.serverID,
 		ClientAddress: session.Address,
 		MtuSize:       pk.MtuSize,
 	}
@@ -222,11 +344,12 @@ func (pk *GeneralDataPacket) Read(buf *bytes.Buffer) {
 
 // Handle implements RaknetPacket interfaces.
 func (pk *GeneralDataPacket) Handle(session *session) {
+	if session.Status < 2 { // Connection is not opened yet
+		return
+	}
 	if pk.SeqNumber < session.windowBorder[0] || pk.SeqNumber >= session.windowBorder[1] {
 		return
 	}
-	session.packetWindow[pk.SeqNumber] = true
-	session.AckChan <- ackUpdate{seqs: []uint32{pk.SeqNumber}}
 	diff := pk.SeqNumber - session.lastSeq
 	if diff != 1 {
 		for i := session.lastSeq + 1; i < pk.SeqNumber; i++ {
@@ -240,13 +363,19 @@ func (pk *GeneralDataPacket) Handle(session *session) {
 		}
 	}
 	if diff >= 1 {
+		if !session.acceptable(pk.Packets) { // Client will resend the datagram with new sequence number
+			session.AckChan <- ackUpdate{nack: true, seqs: []uint32{pk.SeqNumber}}
+			return
+		}
 		session.lastSeq = pk.SeqNumber
 		atomic.AddUint32(&session.windowBorder[0], diff)
 		atomic.AddUint32(&session.windowBorder[1], diff)
-		for _, pk := range pk.Packets {
-			session.preEncapsulated(pk)
+		for _, ep := range pk.Packets {
+			session.preEncapsulated(ep)
 		}
 	}
+	session.packetWindow[pk.SeqNumber] = true
+	session.AckChan <- ackUpdate{seqs: []uint32{pk.SeqNumber}}
 }
 
 // Write implements RaknetPacket interfaces.
@@ -291,11 +420,6 @@ func (pk *Nack) Read(buf *bytes.Buffer) {
 // Handle implements RaknetPacket interfaces.
 func (pk *Nack) Handle(session *session) {
 	session.AckChan <- ackUpdate{got: true, nack: true, seqs: pk.Seqs}
-	for _, seq := range pk.Seqs {
-		if _, ok := session.nackQueue[seq]; ok {
-			delete(session.nackQueue, seq)
-		}
-	}
 }
 
 // Write implements RaknetPacket interfaces.
@@ -363,22 +487,36 @@ type ClientHandshake struct {
 	Address            *net.UDPAddr
 	SystemAddresses    []*net.UDPAddr
 	SendPing, SendPong uint64
+
+	err error // Decode error
 }
 
 // Read implements RaknetPacket interfaces.
 func (pk *ClientHandshake) Read(buf *bytes.Buffer) {
-	pk.Address = ReadAddress(buf)
+	if pk.Address, pk.err = ParseAddress(buf); pk.err != nil {
+		return
+	}
 	addrs := make([]*net.UDPAddr, 10)
 	for i := 0; i < 10; i++ {
-		addrs[i] = ReadAddress(buf)
+		if addrs[i], pk.err = ParseAddress(buf); pk.err != nil {
+			return
+		}
 	}
 	pk.SystemAddresses = addrs
+	if buf.Len() < 16 {
+		pk.err = Overflow{Need: 16, Got: buf.Len()}
+		return
+	}
 	pk.SendPing = ReadLong(buf)
 	pk.SendPong = ReadLong(buf)
 }
 
 // Handle implements RaknetPacket interfaces.
 func (pk *ClientHandshake) Handle(session *session) {
+	if pk.err != nil {
+		session.Close("malformed client handshake: " + pk.err.Error())
+		return
+	}
 	log.Println("Raknet connection succeeded")
 	session.Status = 3
 	session.connComplete()
@@ -408,7 +546,7 @@ func (pk *ServerHandshake) Read(buf *bytes.Buffer) {
 	buf.Next(1) // Unknown
 	addrs := make([]*net.UDPAddr, 10)
 	for i := 0; i < 10; i++ {
-		addrs[0] = ReadAddress(buf)
+		addrs[i] = ReadAddress(buf)
 	}
 	pk.SystemAddresses = addrs
 	pk.SendPing = ReadLong(buf)
@@ -469,6 +607,9 @@ func (pk *Pong) Read(buf *bytes.Buffer) {
 
 // Handle implements RaknetPacket interfaces.
 func (pk *Pong) Handle(session *session) {
+	if pk.PingID == session.pingID {
+		atomic.StoreInt64(&session.ping, int64(time.Since(session.pingTime)))
+	}
 	if session.pingTries > 0 {
 		session.timeout.Reset(timeout)
 		session.pingTries = 0
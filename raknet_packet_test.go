@@ -0,0 +1,125 @@
+package highmc
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// customPacket is a plugin Raknet packet, which sets session ID.
+type customPacket struct {
+	ID uint64
+}
+
+func (pk *customPacket) Read(buf *bytes.Buffer) { pk.ID = ReadLong(buf) }
+
+func (pk *customPacket) Handle(session *session) { session.ID = pk.ID }
+
+func (pk *customPacket) Write(buf *bytes.Buffer) {
+	WriteByte(buf, 0x7f)
+	WriteLong(buf, pk.ID)
+}
+
+func TestRegisterRaknetPacket(t *testing.T) {
+	if err := RegisterRaknetPacket(0x7f, &customPacket{}); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(handlers, 0x7f)
+	if RegisterRaknetPacket(0x7f, &customPacket{}) == nil || RegisterRaknetPacket(0x84, &customPacket{}) == nil {
+		t.Fatal("Used or reserved packet ID is registered")
+	}
+
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	buf := new(bytes.Buffer)
+	(&customPacket{ID: 1234}).Write(buf)
+	s.handlePacket(Packet{Buffer: buf, Address: s.Address})
+	if s.ID != 1234 {
+		t.Fatal("Custom packet handler is not run")
+	}
+}
+
+func TestClientHandshakeTruncated(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}
+	buf := new(bytes.Buffer)
+	(&ClientHandshake{Address: addr, SystemAddresses: []*net.UDPAddr{addr, addr, addr}}).Write(buf)
+	s := testSession(t)
+	s.Status = 2
+	s.handleEncapsulated(&EncapsulatedPacket{Buffer: bytes.NewBuffer(buf.Bytes()[:buf.Len()-16])}) // 3 of 10 addresses, without pings
+	select {
+	case <-s.closed:
+	default:
+		t.Fatal("Session is not closed on truncated address list")
+	}
+	if s.Status == 3 || s.Player != nil {
+		t.Fatal("Truncated client handshake is accepted")
+	}
+}
+
+func TestServerHandshakeRead(t *testing.T) {
+	pk := &ServerHandshake{Address: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, SendPing: 1234, SendPong: 5678}
+	for i := 0; i < 10; i++ {
+		pk.SystemAddresses = append(pk.SystemAddresses, &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i)), Port: 1000 + i})
+	}
+	buf := new(bytes.Buffer)
+	pk.Write(buf)
+	buf.Next(1) // Packet ID
+	read := new(ServerHandshake)
+	read.Read(buf)
+	if len(read.SystemAddresses) != 10 {
+		t.Fatal("Unexpected system address count:", len(read.SystemAddresses))
+	}
+	for i, addr := range read.SystemAddresses {
+		if addr.String() != pk.SystemAddresses[i].String() {
+			t.Fatal("Unexpected system address", i, ":", addr)
+		}
+	}
+	if read.SendPing != 1234 || read.SendPong != 5678 || buf.Len() != 0 {
+		t.Fatal("Unexpected pings:", read.SendPing, read.SendPong)
+	}
+}
+
+func TestRaknetDispatch(t *testing.T) {
+	for _, table := range []map[byte]reflect.Type{handlers, dataPacketHandlers} {
+		for pid, typ := range table {
+			if _, ok := reflect.New(typ).Interface().(RaknetPacket); !ok {
+				t.Fatalf("Handler of 0x%02x does not implement RaknetPacket", pid)
+			}
+		}
+	}
+
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.SendChan = make(chan Packet, 8)
+	s.serverID = 1234
+	reply := func(pk RaknetPacket) {
+		sent := <-s.SendChan
+		sent.Buffer.Next(1)
+		pk.Read(sent.Buffer)
+	}
+	buf := new(bytes.Buffer)
+	(&OpenConnectionRequest1{Protocol: 7, MtuSize: 1000}).Write(buf)
+	s.handlePacket(Packet{Buffer: buf, Address: s.Address})
+	reply1 := new(OpenConnectionReply1)
+	if reply(reply1); s.Status != 1 || reply1.ServerID != 1234 || reply1.MtuSize != 1000 {
+		t.Fatal("Unexpected OpenConnectionReply1:", reply1)
+	}
+	buf = new(bytes.Buffer)
+	(&OpenConnectionRequest2{ServerAddress: s.Address, MtuSize: MaxMTU + 100, ClientID: 5678}).Write(buf)
+	s.handlePacket(Packet{Buffer: buf, Address: s.Address})
+	reply2 := new(OpenConnectionReply2)
+	if reply(reply2); s.Status != 2 || s.ID != 5678 || reply2.ServerID != 1234 || reply2.ClientAddress.String() != s.Address.String() || s.mtuSize != MaxMTU {
+		t.Fatal("Unexpected OpenConnectionReply2:", reply2)
+	}
+
+	buf = new(bytes.Buffer)
+	(&Ping{PingID: 42}).Write(buf)
+	s.handleEncapsulated(&EncapsulatedPacket{Buffer: buf})
+	dp := &DataPacket{Buffer: bytes.NewBuffer((<-s.SendChan).Buffer.Bytes()[1:])}
+	dp.Decode()
+	pong := new(Pong)
+	if pk := dp.Packets[0].Buffer; ReadByte(pk) != 0x03 {
+		t.Fatal("Pong is not sent on Ping")
+	} else if pong.Read(pk); pong.PingID != 42 {
+		t.Fatal("Unexpected ping ID:", pong.PingID)
+	}
+}
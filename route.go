@@ -4,33 +4,92 @@ import (
 	"log"
 	"math/rand"
 	"net"
+	"sync"
 	"time"
 )
 
-var serverID uint64
-var blockList = make(map[string]time.Time)
-
 // Router handles packets from network, and manages sessions.
 type Router struct {
 	conn        *net.UDPConn
 	sendChan    chan Packet
 	recvChan    chan Packet
 	closeNotify chan *net.UDPAddr
+	closed      chan struct{}
+	stopOnce    sync.Once
 	recvBuf     []byte
 
-	sessions map[string]*session
-	Owner    *Server
+	sessions  map[string]*session
+	blockList map[string]time.Time // Address: time until the address is blocked
+	Owner     *Server
+
+	// SessionConfig is applied to sessions created after modification.
+	SessionConfig SessionConfig
+
+	ServerID uint64
+	// MaxSessions is a maximum count of concurrent sessions. 0 means unlimited.
+	MaxSessions int
+	// MOTDFunc returns server status message for unconnected pong. If nil, ServerString of Owner is used,
+	// or GetServerString if the router has no owner server.
+	// It is called on router goroutine for every unconnected pings, so it should not block.
+	MOTDFunc func() string
+	rand     *rand.Rand
+}
+
+// RouterOption is an optional configuration for CreateRouter.
+type RouterOption func(*Router)
+
+// WithServerID makes the router use given server ID instead of random one.
+func WithServerID(id uint64) RouterOption {
+	return func(r *Router) {
+		r.ServerID = id
+	}
+}
+
+// WithRandSeed makes the router use random source with given seed,
+// for server ID and sessions' random values such as ping IDs.
+// It is useful to make tests reproducible.
+func WithRandSeed(seed int64) RouterOption {
+	return func(r *Router) {
+		r.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithMaxSessions limits concurrent sessions of the router.
+// Connection attempts from new addresses are refused until sessions are closed.
+func WithMaxSessions(n int) RouterOption {
+	return func(r *Router) {
+		r.MaxSessions = n
+	}
+}
+
+// WithMOTDFunc makes the router use given func for unconnected pong messages, instead of GetServerString.
+func WithMOTDFunc(fn func() string) RouterOption {
+	return func(r *Router) {
+		r.MOTDFunc = fn
+	}
 }
 
 // CreateRouter create/opens new raknet router with given port.
-func CreateRouter(port uint16) (r *Router, err error) {
+// By default server ID and random sources are random: use RouterOptions to inject them.
+func CreateRouter(port uint16, opts ...RouterOption) (r *Router, err error) {
 	r = new(Router)
-	serverID = uint64(rand.Int63())
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.rand == nil {
+		r.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if r.ServerID == 0 {
+		r.ServerID = uint64(r.rand.Int63())
+	}
 	r.sendChan = make(chan Packet, chanBufsize)
 	r.recvChan = make(chan Packet, chanBufsize)
 	r.conn, err = net.ListenUDP("udp", &net.UDPAddr{Port: int(port)})
 	r.closeNotify = make(chan *net.UDPAddr, chanBufsize)
+	r.closed = make(chan struct{})
 	r.sessions = make(map[string]*session)
+	r.blockList = make(map[string]time.Time)
+	r.SessionConfig = DefaultSessionConfig
 	// r.playerAdder = playerAdder
 	// r.playerRemover = playerRemover
 	return
@@ -42,15 +101,34 @@ func (r *Router) GetSession(address *net.UDPAddr, sendChannel chan Packet) *sess
 		return s
 	}
 	log.Println("New session:", address)
-	sess := NewSession(address)
+	config := r.SessionConfig
+	if config.Rand == nil {
+		config.Rand = rand.New(rand.NewSource(r.rand.Int63()))
+	}
+	sess := NewSession(address, config)
 	sess.SendChan = sendChannel
+	sess.sendClosed = r.closed
+	sess.serverID = r.ServerID
 	sess.Server = r.Owner
 	go sess.sendAsync()
 	go sess.work()
+	go r.watchSession(sess)
 	r.sessions[address.String()] = sess
 	return sess
 }
 
+// watchSession notifies the router work loop when the session is closed.
+func (r *Router) watchSession(sess *session) {
+	select {
+	case <-sess.closed:
+		select {
+		case r.closeNotify <- sess.Address:
+		case <-r.closed:
+		}
+	case <-r.closed:
+	}
+}
+
 // Start makes router process network I/O operations.
 func (r *Router) Start() {
 	go r.sendAsync()
@@ -58,21 +136,46 @@ func (r *Router) Start() {
 	go r.work()
 }
 
+// Stop stops the router, closes live sessions and its connection.
+// Network goroutines started by Start return after that. It is safe to call Stop more than once.
+func (r *Router) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.closed)
+	})
+}
+
+// SessionUpdateInterval is an interval of sweeping closed sessions.
+// Closed sessions are usually removed by closeNotify, so this is only a fallback.
+const SessionUpdateInterval = time.Second
+
 func (r *Router) work() {
 	defer r.conn.Close()
+	ticker := time.NewTicker(SessionUpdateInterval)
+	defer ticker.Stop()
 	for {
 		select {
+		case <-r.closed:
+			for _, sess := range r.sessions {
+				sess.Close("router stopped")
+			}
+			return
+		case <-ticker.C:
+			r.updateSession()
 		case s := <-r.closeNotify:
 			r.closeSession(s)
 		case pk := <-r.recvChan:
-			if blockList[pk.Address.String()].After(time.Now()) {
+			if r.blockList[pk.Address.String()].After(time.Now()) {
 				r.conn.WriteToUDP([]byte("\x80\x00\x00\x00\x00\x00\x08\x15"), pk.Address)
+			} else if _, ok := r.sessions[pk.Address.String()]; !ok && r.MaxSessions > 0 && len(r.sessions) >= r.MaxSessions {
+				r.refuseSession(pk)
 			} else {
-				delete(blockList, pk.Address.String())
-				r.GetSession(pk.Address, r.sendChan).ReceivedChan <- pk
+				delete(r.blockList, pk.Address.String())
+				sess := r.GetSession(pk.Address, r.sendChan)
+				select {
+				case sess.ReceivedChan <- pk:
+				case <-sess.closed: // Will be removed by closeNotify
+				}
 			}
-		default:
-			r.updateSession()
 		}
 	}
 }
@@ -84,6 +187,11 @@ func (r *Router) receivePacket() {
 	for {
 		r.recvBuf = make([]byte, 1024*1024)
 		if n, addr, err = r.conn.ReadFromUDP(r.recvBuf); err != nil {
+			select {
+			case <-r.closed: // Connection closed by Stop
+				return
+			default:
+			}
 			log.Println("Error while reading packet:", err)
 			continue
 		} else if n > 0 {
@@ -93,13 +201,21 @@ func (r *Router) receivePacket() {
 				Address: addr,
 			}
 			if c, err := buf.ReadByte(); err == nil && c == 0x01 { // Unconnected ping: no need to create session
-				pingID := ReadLong(buf)
+				ping := new(UnconnectedPing)
+				ping.Read(buf)
+				if ping.err != nil {
+					if Debug {
+						log.Println("Invalid unconnected ping from", addr, ping.err)
+					}
+					continue
+				}
 				buf := Pool.NewBuffer(nil)
-				WriteByte(buf, 0x1c)
-				WriteLong(buf, pingID)
-				WriteLong(buf, serverID)
-				buf.Write([]byte(RaknetMagic))
-				WriteString(buf, GetServerString())
+				pong := &UnconnectedPong{
+					PingID:       ping.PingID,
+					ServerID:     r.ServerID,
+					ServerString: r.motd(),
+				}
+				pong.Write(buf)
 				pk := Packet{
 					Buffer:  buf,
 					Address: addr,
@@ -108,11 +224,40 @@ func (r *Router) receivePacket() {
 				continue
 			}
 			buf.UnreadByte()
-			r.recvChan <- pk
+			select {
+			case r.recvChan <- pk:
+			case <-r.closed:
+				return
+			}
 		}
 	}
 }
 
+// motd returns server status message for unconnected pong.
+func (r *Router) motd() string {
+	if r.MOTDFunc != nil {
+		return r.MOTDFunc()
+	}
+	if r.Owner != nil {
+		return r.Owner.ServerString()
+	}
+	return GetServerString()
+}
+
+// refuseSession replies NoFreeConnections to connection requests, when the router is full.
+func (r *Router) refuseSession(pk Packet) {
+	defer Pool.Recycle(pk.Buffer)
+	if head := pk.Bytes()[0]; head != 0x05 && head != 0x07 { // Not a connection request
+		return
+	}
+	buf := Pool.NewBuffer(nil)
+	(&NoFreeConnections{ServerID: r.ServerID}).Write(buf)
+	select {
+	case r.sendChan <- Packet{buf, pk.Address, true}:
+	case <-r.closed:
+	}
+}
+
 func (r *Router) updateSession() {
 	for _, sess := range r.sessions {
 		select {
@@ -124,15 +269,23 @@ func (r *Router) updateSession() {
 }
 
 func (r *Router) closeSession(addr *net.UDPAddr) {
+	if _, ok := r.sessions[addr.String()]; !ok { // Already removed by sweep
+		return
+	}
 	delete(r.sessions, addr.String())
-	blockList[addr.String()] = time.Now().Add(time.Second + time.Millisecond*750)
+	r.blockList[addr.String()] = time.Now().Add(time.Second + time.Millisecond*750)
 }
 
 func (r *Router) sendAsync() {
-	for pk := range r.sendChan {
-		r.sendPacket(pk)
-		if pk.Recycle {
-			Pool.Recycle(pk.Buffer)
+	for {
+		select {
+		case <-r.closed:
+			return
+		case pk := <-r.sendChan:
+			r.sendPacket(pk)
+			if pk.Recycle {
+				Pool.Recycle(pk.Buffer)
+			}
 		}
 	}
 }
@@ -0,0 +1,281 @@
+package highmc
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRouterStop(t *testing.T) {
+	r, err := CreateRouter(0, WithRandSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := SessionGoroutines()
+	sess := r.GetSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, r.sendChan)
+	r.Start()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ { // Concurrent Stop should not panic
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Stop()
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-sess.closed:
+	case <-time.After(time.Second):
+		t.Fatal("Live session is not closed after Stop")
+	}
+	deadline := time.Now().Add(time.Second)
+	for SessionGoroutines() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Session goroutines are not returned: %d, expected %d", SessionGoroutines(), before)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestInjectedServerID(t *testing.T) {
+	const id = 0x123456789abcdef
+	r, err := CreateRouter(0, WithServerID(id), WithRandSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	r.Start()
+	c := dialTest(t, r)
+	defer c.conn.Close()
+
+	c.sendRaknet(&OpenConnectionRequest1{Protocol: RaknetProtocol, MtuSize: 1400})
+	b := c.read(time.Now().Add(time.Second * 5))
+	reply1 := new(OpenConnectionReply1)
+	reply1.Read(bytes.NewBuffer(b[1:]))
+	if b[0] != 0x06 || reply1.ServerID != id {
+		t.Fatalf("Unexpected OpenConnectionReply1 0x%02x with server ID %x", b[0], reply1.ServerID)
+	}
+	c.sendRaknet(&OpenConnectionRequest2{ServerAddress: c.server, MtuSize: 1400, ClientID: 1})
+	b = c.read(time.Now().Add(time.Second * 5))
+	reply2 := new(OpenConnectionReply2)
+	reply2.Read(bytes.NewBuffer(b[1:]))
+	if b[0] != 0x08 || reply2.ServerID != id {
+		t.Fatalf("Unexpected OpenConnectionReply2 0x%02x with server ID %x", b[0], reply2.ServerID)
+	}
+}
+
+func TestTwoRouters(t *testing.T) {
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var routers []*Router
+	for _, id := range []uint64{100, 101} {
+		r, err := CreateRouter(0, WithServerID(id), WithRandSeed(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Stop()
+		routers = append(routers, r)
+	}
+	routers[0].blockList[client.LocalAddr().String()] = time.Now().Add(time.Minute)
+	for _, r := range routers {
+		r.Start()
+	}
+
+	request := func(r *Router, pk interface {
+		Write(*bytes.Buffer)
+	}) []byte {
+		buf := new(bytes.Buffer)
+		pk.Write(buf)
+		if _, err := client.WriteToUDP(buf.Bytes(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: r.conn.LocalAddr().(*net.UDPAddr).Port}); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 1500)
+		client.SetReadDeadline(time.Now().Add(time.Second * 5))
+		n, err := client.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b[:n]
+	}
+	for _, r := range routers {
+		b := request(r, &UnconnectedPing{PingID: 1})
+		pong := new(UnconnectedPong)
+		pong.Read(bytes.NewBuffer(b[1:]))
+		if b[0] != 0x1c || pong.ServerID != r.ServerID {
+			t.Fatalf("Unexpected pong 0x%02x with server ID %d, expected %d", b[0], pong.ServerID, r.ServerID)
+		}
+	}
+	if b := request(routers[0], &OpenConnectionRequest1{Protocol: RaknetProtocol, MtuSize: 1400}); b[0] == 0x06 {
+		t.Fatal("Blocked address is accepted")
+	}
+	if b := request(routers[1], &OpenConnectionRequest1{Protocol: RaknetProtocol, MtuSize: 1400}); b[0] != 0x06 {
+		t.Fatalf("Address blocked on another router is refused: 0x%02x", b[0])
+	}
+}
+
+func TestUnconnectedPing(t *testing.T) {
+	buf := new(bytes.Buffer)
+	(&UnconnectedPong{PingID: 42, ServerID: 77, ServerString: "MCPE;test"}).Write(buf)
+	buf.Next(1)
+	pong := new(UnconnectedPong)
+	pong.Read(buf)
+	if pong.PingID != 42 || pong.ServerID != 77 || pong.ServerString != "MCPE;test" {
+		t.Fatal("Unexpected pong after round trip:", pong)
+	}
+
+	r, err := CreateRouter(0, WithServerID(77), WithRandSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	r.Start()
+	c := dialTest(t, r)
+	defer c.conn.Close()
+	buf.Reset()
+	(&UnconnectedPing{PingID: 41}).Write(buf)
+	bad := buf.Bytes()
+	bad[12] ^= 0xff // Breaks the magic
+	if _, err := c.conn.Write(bad); err != nil {
+		t.Fatal(err)
+	}
+	c.sendRaknet(&UnconnectedPing{PingID: 42})
+	b := c.read(time.Now().Add(time.Second * 5))
+	pong = new(UnconnectedPong)
+	pong.Read(bytes.NewBuffer(b[1:]))
+	if b[0] != 0x1c || pong.PingID != 42 || pong.ServerID != 77 {
+		t.Fatal("Unexpected pong:", pong)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	if _, err := c.conn.Read(b); err == nil {
+		t.Fatal("Ping with bad magic is answered")
+	}
+}
+
+func TestMOTDFunc(t *testing.T) {
+	motds := []string{"MCPE;first motd;1;1;0;5", "MCPE;second motd;1;1;0;5"}
+	n := 0
+	r, err := CreateRouter(0, WithMOTDFunc(func() string {
+		n++
+		return motds[(n-1)%len(motds)]
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	r.Start()
+	c := dialTest(t, r)
+	defer c.conn.Close()
+	for _, motd := range motds {
+		c.sendRaknet(&UnconnectedPing{PingID: 42})
+		b := c.read(time.Now().Add(time.Second * 5))
+		pong := new(UnconnectedPong)
+		pong.Read(bytes.NewBuffer(b[1:]))
+		if b[0] != 0x1c || pong.ServerString != motd {
+			t.Fatal("Unexpected pong message:", pong.ServerString)
+		}
+	}
+}
+
+func TestMaxSessions(t *testing.T) {
+	r, err := CreateRouter(0, WithMaxSessions(1), WithRandSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	r.Start()
+	connect := func(c *testClient) byte {
+		c.sendRaknet(&OpenConnectionRequest1{Protocol: RaknetProtocol, MtuSize: 1400})
+		return c.read(time.Now().Add(time.Second * 5))[0]
+	}
+	first, second := dialTest(t, r), dialTest(t, r)
+	defer first.conn.Close()
+	defer second.conn.Close()
+	if head := connect(first); head != 0x06 {
+		t.Fatalf("Expected OpenConnectionReply1, got 0x%02x", head)
+	}
+	if head := connect(second); head != 0x14 {
+		t.Fatalf("Expected NoFreeConnections over the cap, got 0x%02x", head)
+	}
+	if head := connect(first); head != 0x06 {
+		t.Fatalf("Existing session is affected by refused connection: got 0x%02x", head)
+	}
+}
+
+func TestReceivePacketStop(t *testing.T) {
+	r, err := CreateRouter(0, WithRandSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		r.receivePacket()
+		close(done)
+	}()
+	go r.work()
+	r.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("receivePacket does not return after Stop")
+	}
+}
+
+// runWork runs work loop of the router, and returns a func which stops the router and waits the loop to return.
+func runWork(r *Router) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		r.work()
+		close(done)
+	}()
+	return func() {
+		r.Stop()
+		<-done
+	}
+}
+
+func TestSessionSweep(t *testing.T) {
+	r, err := CreateRouter(0, WithRandSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}
+	sess := NewSession(addr, DefaultSessionConfig) // Not watched: only the sweep removes it
+	r.sessions[addr.String()] = sess
+	close(sess.closed)
+	stop := runWork(r)
+	time.Sleep(SessionUpdateInterval * 3 / 2)
+	stop()
+	if _, ok := r.sessions[addr.String()]; ok {
+		t.Fatal("Closed session is not swept on schedule")
+	}
+	if _, ok := r.blockList[addr.String()]; !ok {
+		t.Fatal("Swept session is not blocked")
+	}
+}
+
+func TestSessionCloseNotify(t *testing.T) {
+	r, err := CreateRouter(0, WithRandSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}
+	sess := r.GetSession(addr, r.sendChan)
+	go r.sendAsync() // Passes the disconnect packet
+	stop := runWork(r)
+	sess.Close("test")
+	time.Sleep(SessionUpdateInterval / 5) // Much shorter than the sweep interval
+	stop()
+	if _, ok := r.sessions[addr.String()]; ok {
+		t.Fatal("Closed session is not reaped by closeNotify")
+	}
+	if _, ok := r.blockList[addr.String()]; !ok {
+		t.Fatal("Reaped session is not blocked")
+	}
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package highmc
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// BenchmarkIdleRouter measures CPU time spent by an idle router, per SessionUpdateInterval.
+func BenchmarkIdleRouter(b *testing.B) {
+	r, err := CreateRouter(0, WithRandSeed(1))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer runWork(r)()
+	var before, after syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &before)
+	for i := 0; i < b.N; i++ {
+		time.Sleep(SessionUpdateInterval)
+	}
+	syscall.Getrusage(syscall.RUSAGE_SELF, &after)
+	cpu := time.Duration(syscall.TimevalToNsec(after.Utime) - syscall.TimevalToNsec(before.Utime))
+	b.ReportMetric(float64(cpu)/float64(b.N), "cpu-ns/interval")
+}
@@ -1,10 +1,12 @@
 package highmc
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"strings"
 	"sync/atomic"
-	"unsafe"
+	"time"
 )
 
 // Server is a main server object.
@@ -24,16 +26,135 @@ type Server struct {
 		packet MCPEPacket
 		filter func(*player) bool
 	}
+	rawBroadcastRequest chan struct {
+		ep     *EncapsulatedPacket
+		filter func(*player) bool
+	}
+	sendRequest chan struct {
+		packet MCPEPacket
+		match  func(*player) bool
+		ok     chan error
+	}
+	rosterRequest chan chan []RosterEntry
+	ops           *opList
+
+	movementRequest chan struct {
+		entityID uint64
+		pos      [6]float32
+	}
+	movements map[uint64][6]float32 // Entity movements on current tick
+	positions map[uint64][6]float32 // Last known position of online players, updated with movements
+
+	JoinMessage  string                   // Join broadcast template, %s is replaced with username. Empty string disables it.
+	QuitMessage  string                   // Quit broadcast template, %s is replaced with username. Empty string disables it.
+	JoinHandlers []func(*PlayerJoinEvent) // Called in order before join message is broadcasted
+	QuitHandlers []func(*PlayerQuitEvent) // Called in order before quit message is broadcasted
+	Commands     *CommandManager          // Commands available on the server, starting with ones added by RegisterCommand
+
+	MaxChunkRadius int32 // Maximum view radius granted to players, in chunks. Initialized with Config.ViewDistance.
+	MaxPlayers     int32 // Maximum player count shown on server list, initialized with Config.MaxPlayers. Access it with sync/atomic.
+	online         int32 // Count of players registered to the server, atomic
+
+	Config Config
+}
+
+// DefaultMaxPlayers is a default value for Config.MaxPlayers.
+const DefaultMaxPlayers = 20
+
+// Config is a configuration of the server. Start from DefaultConfig, and modify fields needed.
+type Config struct {
+	MOTD          string  // Server name shown on server list. Empty string uses ServerName.
+	MaxPlayers    int32   // Maximum player count shown on server list
+	Gamemode      uint32  // Gamemode of players joining the server
+	SpawnPosition Vector3 // Position where players spawn and respawn
+	Seed          uint32  // Level seed sent to clients
+	ViewDistance  int32   // Maximum view radius granted to players, in chunks
+}
+
+// DefaultConfig is a config used when NewServer is called without config.
+var DefaultConfig = Config{
+	MaxPlayers:    DefaultMaxPlayers,
+	Gamemode:      GamemodeCreative,
+	SpawnPosition: Vector3{X: 0, Y: 80, Z: 0},
+	Seed:          0xffffffff, // -1
+	ViewDistance:  DefaultMaxChunkRadius,
+}
+
+// Default join/quit message templates
+const (
+	DefaultJoinMessage = "%s joined the game"
+	DefaultQuitMessage = "%s quit the game"
+)
+
+// PlayerJoinEvent is passed to Server.JoinHandlers when a player spawns on the server for the first time.
+// Handlers may modify Message, or set Cancelled to suppress the broadcast.
+type PlayerJoinEvent struct {
+	Username  string
+	Message   string // Join message to broadcast
+	Cancelled bool
+}
+
+// PlayerQuitEvent is passed to Server.QuitHandlers when a player leaves the server.
+// Handlers may modify Message, or set Cancelled to suppress the broadcast.
+type PlayerQuitEvent struct {
+	Username  string
+	Reason    string // Disconnect message sent to the client
+	Message   string // Quit message to broadcast
+	Silent    bool   // True if the player is kicked silently
+	Cancelled bool
+}
+
+// TickDuration is a duration of single server tick.
+const TickDuration = time.Millisecond * 50
+
+// RosterEntry is a snapshot of online player information.
+type RosterEntry struct {
+	RawUUID  [16]byte
+	Username string
+	EntityID uint64
+	SkinName string
+	Skin     []byte
+	Ping     time.Duration
+	Position Vector3 // As of the last movement queued with AddEntityMovement
+}
+
+// PlayerListEntry converts the roster entry to PlayerList packet entry.
+func (e RosterEntry) PlayerListEntry() PlayerListEntry {
+	return PlayerListEntry{
+		RawUUID:  e.RawUUID,
+		EntityID: e.EntityID,
+		Username: e.Username,
+		Skinname: e.SkinName,
+		Skin:     e.Skin,
+	}
 }
 
-// NewServer creates new server object.
-func NewServer() *Server {
+// NewServer creates new server object with given config.
+// If config is omitted, DefaultConfig is used.
+func NewServer(config ...Config) *Server {
 	s := new(Server)
+	s.Config = DefaultConfig
+	if len(config) > 0 {
+		s.Config = config[0]
+	}
+	if s.Config.ViewDistance < 1 {
+		s.Config.ViewDistance = DefaultMaxChunkRadius
+	}
+	s.MaxChunkRadius = s.Config.ViewDistance
+	s.MaxPlayers = s.Config.MaxPlayers
 	s.OpenSessions = make(map[string]struct{})
 	s.Levels = map[string]*Level{
 		defaultLvl: {Name: "dummy", Server: s},
 	}
+	s.Levels[defaultLvl].Init()
 	s.players = make(map[string]*player)
+	s.JoinMessage = DefaultJoinMessage
+	s.QuitMessage = DefaultQuitMessage
+	s.Commands = newCommandManager()
+	s.ops = newOpList()
+	if err := s.ops.load(); err != nil {
+		log.Println("Error while loading ops:", err)
+	}
 
 	s.callbackRequest = make(chan func(*player), chanBufsize)
 	s.registerRequest = make(chan struct {
@@ -45,6 +166,22 @@ func NewServer() *Server {
 		packet MCPEPacket
 		filter func(*player) bool
 	}, chanBufsize)
+	s.rawBroadcastRequest = make(chan struct {
+		ep     *EncapsulatedPacket
+		filter func(*player) bool
+	}, chanBufsize)
+	s.sendRequest = make(chan struct {
+		packet MCPEPacket
+		match  func(*player) bool
+		ok     chan error
+	}, chanBufsize)
+	s.rosterRequest = make(chan chan []RosterEntry, chanBufsize)
+	s.movementRequest = make(chan struct {
+		entityID uint64
+		pos      [6]float32
+	}, chanBufsize)
+	s.movements = make(map[uint64][6]float32)
+	s.positions = make(map[uint64][6]float32)
 
 	s.close = make(chan struct{})
 	return s
@@ -55,11 +192,40 @@ func (s *Server) Start() {
 	go s.process()
 }
 
+// ServerString returns server status message for unconnected pong, with Config.MOTD and player counts of the server.
+// It is safe to call from any goroutine.
+func (s *Server) ServerString() string {
+	name := s.Config.MOTD
+	if name == "" {
+		name = ServerName
+	}
+	return serverString(name, atomic.LoadInt32(&s.online), atomic.LoadInt32(&s.MaxPlayers))
+}
+
+// Stop stops the server, and saves every levels.
+func (s *Server) Stop() {
+	close(s.close)
+	for name, lv := range s.Levels {
+		if err := lv.Close(); err != nil {
+			log.Println("Error while saving level", name+":", err)
+		}
+	}
+}
+
 func (s *Server) process() {
+	ticker := time.NewTicker(TickDuration)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-s.close:
 			return
+		case <-ticker.C:
+			s.tick()
+		case req := <-s.movementRequest:
+			s.movements[req.entityID] = req.pos
+			if _, ok := s.positions[req.entityID]; ok {
+				s.positions[req.entityID] = req.pos
+			}
 		case req := <-s.registerRequest:
 			if req.register {
 				if _, ok := s.players[req.player.Address.String()]; ok {
@@ -68,14 +234,29 @@ func (s *Server) process() {
 				}
 				go req.player.once.Do(req.player.process)
 				s.players[req.player.Address.String()] = req.player
+				atomic.AddInt32(&OnlinePlayers, 1)
+				atomic.AddInt32(&s.online, 1)
 				req.player.playerShown = make(map[uint64]struct{})
+				pos := req.player.Position // The player is blocked until registration is done
+				s.positions[req.player.EntityID] = [6]float32{pos.X, pos.Y, pos.Z, req.player.Yaw, req.player.BodyYaw, req.player.Pitch}
+				entry := req.player.RosterEntry().PlayerListEntry()
+				list := &PlayerList{
+					Type:          PlayerListAdd,
+					PlayerEntries: make([]PlayerListEntry, 0, len(s.players)),
+				}
 				for _, p := range s.players {
+					list.PlayerEntries = append(list.PlayerEntries, p.RosterEntry().PlayerListEntry())
 					if p.EntityID == req.player.EntityID { // player self
 						continue
 					}
+					s.sendPacket(p, &PlayerList{
+						Type:          PlayerListAdd,
+						PlayerEntries: []PlayerListEntry{entry},
+					})
 					s.ShowPlayer(p, req.player)
 					s.ShowPlayer(req.player, p)
 				}
+				s.sendPacket(req.player, list)
 				req.ok <- nil
 			} else {
 				if _, ok := s.players[req.player.Address.String()]; !ok {
@@ -83,18 +264,138 @@ func (s *Server) process() {
 					continue
 				}
 				delete(s.players, req.player.Address.String())
+				delete(s.positions, req.player.EntityID)
+				atomic.AddInt32(&OnlinePlayers, -1) // Not reached on duplicate unregister, as the player is already deleted
+				atomic.AddInt32(&s.online, -1)
+				for _, p := range s.players {
+					s.sendPacket(p, &PlayerList{
+						Type:          PlayerListRemove,
+						PlayerEntries: []PlayerListEntry{{RawUUID: req.player.UUID}},
+					})
+				}
 				req.ok <- nil
 			}
+		case req := <-s.sendRequest:
+			err := fmt.Errorf("player is not online")
+			for _, p := range s.players {
+				if req.match(p) {
+					s.sendPacket(p, req.packet)
+					err = nil
+					break
+				}
+			}
+			req.ok <- err
+		case reply := <-s.rosterRequest:
+			roster := make([]RosterEntry, 0, len(s.players))
+			for _, p := range s.players {
+				entry := p.RosterEntry()
+				pos := s.positions[p.EntityID]
+				entry.Position = Vector3{X: pos[0], Y: pos[1], Z: pos[2]}
+				roster = append(roster, entry)
+			}
+			reply <- roster
 		case req := <-s.broadcastRequest:
 			for _, p := range s.players {
 				if req.filter == nil || req.filter(p) {
-					p.SendRequest <- req.packet
+					s.sendPacket(p, req.packet)
+				}
+			}
+		case req := <-s.rawBroadcastRequest:
+			for _, p := range s.players {
+				if req.filter == nil || req.filter(p) {
+					select {
+					case p.SendRawRequest <- req.ep:
+					case <-p.closed:
+					}
 				}
 			}
 		}
 	}
 }
 
+// tick processes works which should be done once per tick.
+func (s *Server) tick() {
+	items := make(map[uint64]*Level) // Moved item entity ID: level of the item
+	for _, lv := range s.Levels {
+		records := lv.Tick()
+		moved, despawned := lv.TickItems()
+		for eid, pos := range moved {
+			s.movements[eid] = [6]float32{pos.X, pos.Y, pos.Z}
+			items[eid] = lv
+		}
+		if len(records) == 0 && len(despawned) == 0 {
+			continue
+		}
+		for _, p := range s.players {
+			if p.Level != lv {
+				continue
+			}
+			if len(records) > 0 {
+				s.sendPacket(p, &UpdateBlock{BlockRecords: records})
+			}
+			for _, eid := range despawned {
+				s.sendPacket(p, &RemoveEntity{EntityID: eid})
+			}
+		}
+	}
+	s.flushMovements(items)
+}
+
+// flushMovements sends entity movements accumulated on current tick.
+// Each player gets only one MoveEntity packet, containing movements of entities on the same level it can see:
+// players shown to it, and item entities. items is a level of each moved item entity.
+func (s *Server) flushMovements(items map[uint64]*Level) {
+	if len(s.movements) == 0 {
+		return
+	}
+	levels := make(map[uint64]*Level, len(s.players)+len(items))
+	for eid, lv := range items {
+		levels[eid] = lv
+	}
+	for _, p := range s.players {
+		levels[p.EntityID] = p.Level
+	}
+	for _, p := range s.players {
+		pk := &MoveEntity{
+			EntityIDs: make([]uint64, 0, len(s.movements)),
+			EntityPos: make([][6]float32, 0, len(s.movements)),
+		}
+		for eid, pos := range s.movements {
+			if lv, ok := levels[eid]; !ok || lv != p.Level || eid == p.EntityID {
+				continue
+			}
+			if _, item := items[eid]; !item {
+				if _, shown := p.playerShown[eid]; !shown {
+					continue
+				}
+			}
+			pk.EntityIDs = append(pk.EntityIDs, eid)
+			pk.EntityPos = append(pk.EntityPos, pos)
+		}
+		if len(pk.EntityIDs) > 0 {
+			s.sendPacket(p, pk)
+		}
+	}
+	s.movements = make(map[uint64][6]float32)
+}
+
+// AddEntityMovement queues entity movement, which will be broadcasted on the end of current tick.
+// If the entity moves several times during a tick, only the last position is sent.
+func (s *Server) AddEntityMovement(entityID uint64, x, y, z, yaw, headYaw, pitch float32) {
+	s.movementRequest <- struct {
+		entityID uint64
+		pos      [6]float32
+	}{
+		entityID,
+		[6]float32{x, y, z, yaw, headYaw, pitch},
+	}
+}
+
+// GetDefaultLevel returns default level of the server.
+func (s *Server) GetDefaultLevel() *Level {
+	return s.Levels[defaultLvl]
+}
+
 // RegisterPlayer attempts to register the player to server.
 func (s *Server) RegisterPlayer(p *player) error {
 	ok := make(chan error, 1)
@@ -145,6 +446,87 @@ func (s *Server) BroadcastPacket(pk MCPEPacket, filter func(*player) bool) {
 	}
 }
 
+// BroadcastCompressed packs given packets into one BatchPacket and broadcasts it to all online players.
+// Unlike BroadcastPacket, packets are serialized and compressed only once, and every recipient gets the same bytes.
+// If filter is not nil server will send packet to players only filter returns true.
+func (s *Server) BroadcastCompressed(filter func(*player) bool, pks ...MCPEPacket) {
+	batch := &Batch{
+		Payloads: make([][]byte, len(pks)),
+	}
+	for i, pk := range pks {
+		batch.Payloads[i] = pk.Write().Bytes()
+	}
+	s.broadcastRaw(batch.Write().Bytes(), filter)
+}
+
+// BroadcastRaw broadcasts pre-serialized MCPE packet payload to all online players.
+// The payload is wrapped only once, and every recipient gets the same packet without re-serialization.
+// If filter is not nil server will send packet to players only filter returns true.
+func (s *Server) BroadcastRaw(buf *bytes.Buffer, filter func(*player) bool) {
+	s.broadcastRaw(buf.Bytes(), filter)
+}
+
+// broadcastRaw wraps payload in an EncapsulatedPacket once, and sends the packet to every recipient.
+// The packet is never modified nor recycled after this, so sessions can encode it concurrently.
+func (s *Server) broadcastRaw(payload []byte, filter func(*player) bool) {
+	ep := new(EncapsulatedPacket)
+	ep.Reliability = 2
+	ep.Buffer = bytes.NewBuffer(append([]byte{0x8e}, payload...))
+	s.rawBroadcastRequest <- struct {
+		ep     *EncapsulatedPacket
+		filter func(*player) bool
+	}{
+		ep,
+		filter,
+	}
+}
+
+// SendTo sends given MCPEPacket to the online player with given username.
+// Username is case-insensitive. It returns error if the player is not online.
+func (s *Server) SendTo(username string, pk MCPEPacket) error {
+	return s.sendMatching(pk, func(p *player) bool {
+		return strings.EqualFold(p.Username, username)
+	})
+}
+
+// SendToUUID sends given MCPEPacket to the online player with given UUID.
+// It returns error if the player is not online.
+func (s *Server) SendToUUID(uuid [16]byte, pk MCPEPacket) error {
+	return s.sendMatching(pk, func(p *player) bool {
+		return p.UUID == uuid
+	})
+}
+
+func (s *Server) sendMatching(pk MCPEPacket, match func(*player) bool) error {
+	ok := make(chan error, 1)
+	s.sendRequest <- struct {
+		packet MCPEPacket
+		match  func(*player) bool
+		ok     chan error
+	}{
+		pk,
+		match,
+		ok,
+	}
+	return <-ok
+}
+
+// sendPacket requests p to send the packet, unless p is closed.
+// Closing players may be waiting for the server to unregister them, so blocking send could deadlock.
+func (s *Server) sendPacket(p *player, pk MCPEPacket) {
+	select {
+	case p.SendRequest <- pk:
+	case <-p.closed:
+	}
+}
+
+// Roster returns information of all online players.
+func (s *Server) Roster() []RosterEntry {
+	reply := make(chan []RosterEntry, 1)
+	s.rosterRequest <- reply
+	return <-reply
+}
+
 // Message broadcasts message to all players.
 func (s *Server) Message(msg string) {
 	s.BroadcastPacket(&Text{
@@ -154,20 +536,36 @@ func (s *Server) Message(msg string) {
 	log.Println("Broadcast> " + msg)
 }
 
+// BroadcastPopup broadcasts popup message to all players.
+func (s *Server) BroadcastPopup(msg string) {
+	s.BroadcastPacket(&Text{
+		TextType: TextTypePopup,
+		Message:  msg,
+	}, nil)
+}
+
+// BroadcastTip broadcasts tip message to all players.
+func (s *Server) BroadcastTip(msg string) {
+	s.BroadcastPacket(&Text{
+		TextType: TextTypeTip,
+		Message:  msg,
+	}, nil)
+}
+
 // ShowPlayer shows p to t.
 func (s *Server) ShowPlayer(p, t *player) {
-	x, y, z := unsafe.Pointer(&p.Position.X), unsafe.Pointer(&p.Position.Y), unsafe.Pointer(&p.Position.Z)
-	t.SendRequest <- &AddPlayer{
+	pos := s.positions[p.EntityID]
+	s.sendPacket(t, &AddPlayer{
 		RawUUID:  p.UUID,
 		Username: p.Username,
 		EntityID: p.EntityID,
-		X:        *(*float32)(atomic.LoadPointer(&x)),
-		Y:        *(*float32)(atomic.LoadPointer(&y)),
-		Z:        *(*float32)(atomic.LoadPointer(&z)),
-		BodyYaw:  p.BodyYaw,
-		Yaw:      p.Yaw,
-		Pitch:    p.Pitch,
-	}
+		X:        pos[0],
+		Y:        pos[1],
+		Z:        pos[2],
+		Yaw:      pos[3],
+		BodyYaw:  pos[4],
+		Pitch:    pos[5],
+	})
 	t.playerShown[p.EntityID] = struct{}{}
 }
 
@@ -176,9 +574,9 @@ func (s *Server) RemovePlayer(p, t *player) {
 	if _, ok := t.playerShown[p.EntityID]; !ok {
 		return
 	}
-	t.SendRequest <- &RemovePlayer{
+	s.sendPacket(t, &RemovePlayer{
 		EntityID: p.EntityID,
 		RawUUID:  p.UUID,
-	}
+	})
 	delete(t.playerShown, p.EntityID)
 }
@@ -0,0 +1,333 @@
+package highmc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlushMovementsVisibility(t *testing.T) {
+	s := NewServer()
+	lv, other := testLevel(), testLevel()
+	players := make([]*player, 3)
+	for i := range players {
+		p := NewPlayer(&session{Address: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: i + 1}})
+		p.EntityID = uint64(100 + i)
+		p.Level = lv
+		p.SendRequest = make(chan MCPEPacket, 4)
+		p.playerShown = make(map[uint64]struct{})
+		s.players[p.Address.String()] = p
+		players[i] = p
+	}
+	viewer, hidden, away := players[0], players[1], players[2]
+	away.Level = other
+	viewer.playerShown[away.EntityID] = struct{}{}
+	const item, otherItem = 200, 201
+	for _, eid := range []uint64{hidden.EntityID, away.EntityID, item, otherItem} {
+		s.movements[eid] = [6]float32{1, 2, 3}
+	}
+	s.flushMovements(map[uint64]*Level{item: lv, otherItem: other})
+	pk := (<-viewer.SendRequest).(*MoveEntity)
+	if len(pk.EntityIDs) != 1 || pk.EntityIDs[0] != item {
+		t.Fatal("Expected movement of the item on the same level only, got", pk.EntityIDs)
+	}
+	if len(s.movements) != 0 {
+		t.Fatal("Movements are not cleared after flush")
+	}
+}
+
+func TestServerStringPerServer(t *testing.T) {
+	name := ServerName
+	config := DefaultConfig
+	config.MOTD, config.MaxPlayers = "first", 7
+	first := NewServer(config)
+	config.MOTD, config.MaxPlayers = "second", 9
+	second := NewServer(config)
+	first.Start()
+	defer first.Stop()
+	second.Start()
+	defer second.Stop()
+
+	p := NewPlayer(&session{
+		EncapsulatedChan: make(chan *EncapsulatedPacket, 64),
+		Server:           first,
+		Address:          &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1},
+		closed:           make(chan struct{}),
+	})
+	if err := first.RegisterPlayer(p); err != nil {
+		t.Fatal(err)
+	}
+	if s := first.ServerString(); !strings.HasPrefix(s, "MCPE;first;") || !strings.HasSuffix(s, ";1;7") {
+		t.Fatal("Unexpected server string of the first server:", s)
+	}
+	if s := second.ServerString(); !strings.HasPrefix(s, "MCPE;second;") || !strings.HasSuffix(s, ";0;9") {
+		t.Fatal("Unexpected server string of the second server:", s)
+	}
+	if ServerName != name || first.MaxPlayers != 7 || first.MaxChunkRadius != config.ViewDistance {
+		t.Fatal("Server config is not applied per server")
+	}
+	if err := first.UnregisterPlayer(p); err != nil {
+		t.Fatal(err)
+	}
+	if s := first.ServerString(); !strings.HasSuffix(s, ";0;7") {
+		t.Fatal("Online count is not decreased after unregistration:", s)
+	}
+}
+
+func TestOnlinePlayers(t *testing.T) {
+	s := NewServer()
+	s.Start()
+	defer s.Stop()
+	online := atomic.LoadInt32(&OnlinePlayers)
+	players := make([]*player, 2)
+	for i := range players {
+		players[i] = NewPlayer(&session{
+			EncapsulatedChan: make(chan *EncapsulatedPacket, 64),
+			Server:           s,
+			Address:          &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: i + 1},
+			closed:           make(chan struct{}),
+		})
+		if err := s.RegisterPlayer(players[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&OnlinePlayers); n != online+2 {
+		t.Fatal("Online players are not increased on registration:", n)
+	}
+	if msg := GetServerString(); !strings.HasSuffix(msg, fmt.Sprintf(";%d;%d", online+2, atomic.LoadInt32(&MaxPlayers))) {
+		t.Fatal("Online players are not shown on server string:", msg)
+	}
+	for i := 0; i < 2; i++ {
+		err := s.UnregisterPlayer(players[0])
+		if (i == 0) != (err == nil) {
+			t.Fatal("Unexpected error on unregistration", i, ":", err)
+		}
+	}
+	if n := atomic.LoadInt32(&OnlinePlayers); n != online+1 {
+		t.Fatal("Unexpected online players after duplicate unregistration:", n)
+	}
+	if msg := s.ServerString(); !strings.HasSuffix(msg, fmt.Sprintf(";1;%d", DefaultMaxPlayers)) {
+		t.Fatal("Unexpected server string:", msg)
+	}
+	s.UnregisterPlayer(players[1])
+}
+
+// broadcastTarget returns a player registered to the server which is not started, receiving sends on buffered channels.
+func broadcastTarget(s *Server, port int) *player {
+	p := NewPlayer(&session{Address: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}})
+	p.SendRequest = make(chan MCPEPacket, 4)
+	p.SendRawRequest = make(chan *EncapsulatedPacket, 4)
+	s.players[p.Address.String()] = p
+	return p
+}
+
+func TestBroadcastCompressed(t *testing.T) {
+	s := NewServer()
+	players := []*player{broadcastTarget(s, 1), broadcastTarget(s, 2), broadcastTarget(s, 3)}
+	s.Start()
+	defer s.Stop()
+	s.BroadcastCompressed(nil, &Text{TextType: TextTypeRaw, Message: "hello"}, &SetTime{Time: 100, Started: true})
+	first := <-players[0].SendRawRequest
+	for _, p := range players[1:] {
+		if ep := <-p.SendRawRequest; ep != first {
+			t.Fatal("Recipients got payloads wrapped separately")
+		}
+	}
+	payload := first.Buffer.Bytes()
+	batch := new(Batch)
+	batch.Read(bytes.NewBuffer(payload[2:]))
+	if payload[0] != 0x8e || payload[1] != BatchHead || len(batch.Payloads) != 2 || batch.Payloads[0][0] != TextHead {
+		t.Fatal("Broadcast payload is not a batch of given packets")
+	}
+}
+
+func TestBroadcastRaw(t *testing.T) {
+	s := NewServer()
+	players := []*player{broadcastTarget(s, 1), broadcastTarget(s, 2), broadcastTarget(s, 3)}
+	s.Start()
+	defer s.Stop()
+	buf := (&Text{TextType: TextTypeRaw, Message: "hello"}).Write()
+	s.BroadcastRaw(buf, func(p *player) bool { return p.Address.Port != 3 })
+	first := <-players[0].SendRawRequest
+	second := <-players[1].SendRawRequest
+	if first != second || first.Reliability != 2 || !bytes.Equal(first.Buffer.Bytes(), append([]byte{0x8e}, buf.Bytes()...)) {
+		t.Fatal("Recipients got different packets")
+	}
+	next := (&Text{TextType: TextTypeRaw, Message: "bye"}).Write()
+	s.BroadcastRaw(next, nil)
+	if ep := <-players[2].SendRawRequest; !bytes.Equal(ep.Buffer.Bytes()[1:], next.Bytes()) {
+		t.Fatal("Filtered player got raw broadcast")
+	}
+}
+
+func BenchmarkBroadcastCompressed(b *testing.B) {
+	const recipients = 20
+	pk := &FullChunkData{ChunkX: 1, ChunkZ: 2, Payload: new(Chunk).FullChunkData()}
+	serialize := func() []byte {
+		return Batch{Payloads: [][]byte{pk.Write().Bytes()}}.Write().Bytes()
+	}
+	b.Run("PerRecipient", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i := 0; i < recipients; i++ {
+				serialize()
+			}
+		}
+	})
+	b.Run("Shared", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			serialize()
+		}
+	})
+}
+
+func TestRoster(t *testing.T) {
+	s := NewServer()
+	s.Start()
+	defer s.Stop()
+	join := func(name string, port int) *player {
+		p := NewPlayer(&session{
+			EncapsulatedChan: make(chan *EncapsulatedPacket, 16),
+			Server:           s,
+			Address:          &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+			closed:           make(chan struct{}),
+		})
+		p.Username = name
+		p.SendRequest = make(chan MCPEPacket, 16)
+		p.once.Do(func() {}) // Packets are buffered on SendRequest, instead of being processed
+		if err := s.RegisterPlayer(p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	list := func(p *player) string {
+		p.ExecuteCommand("list")
+		pks := sentPackets(t, p)
+		return pks[len(pks)-1].(*Text).Message
+	}
+	alice, bob := join("Alice", 1), join("Bob", 2)
+	if roster := s.Roster(); len(roster) != 2 {
+		t.Fatal("Unexpected roster after join:", roster)
+	}
+	if msg := list(alice); msg != "2 players online: Alice, Bob" {
+		t.Fatal("Unexpected /list output:", msg)
+	}
+	if err := s.UnregisterPlayer(bob); err != nil {
+		t.Fatal(err)
+	}
+	if roster := s.Roster(); len(roster) != 1 || roster[0].Username != "Alice" {
+		t.Fatal("Unexpected roster after leave:", roster)
+	}
+	if msg := list(alice); msg != "1 players online: Alice" {
+		t.Fatal("Unexpected /list output:", msg)
+	}
+}
+
+func TestBroadcastClosedPlayer(t *testing.T) {
+	s := NewServer()
+	s.Start()
+	defer s.Stop()
+	closing := NewPlayer(&session{
+		Server:  s,
+		Address: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1},
+		closed:  make(chan struct{}),
+	})
+	closing.SendRequest = make(chan MCPEPacket, 1) // Filled with PlayerList on registration
+	closing.once.Do(func() {})                     // Nobody receives requests, like a player waiting for unregistration
+	if err := s.RegisterPlayer(closing); err != nil {
+		t.Fatal(err)
+	}
+	close(closing.closed)
+	done := make(chan struct{})
+	go func() {
+		s.Message("hello")
+		s.BroadcastRaw(bytes.NewBuffer([]byte{TextHead}), nil)
+		s.Roster()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Server is blocked on closed player")
+	}
+}
+
+func TestFlushMovementsBatched(t *testing.T) {
+	s := NewServer()
+	lv := testLevel()
+	viewer := NewPlayer(&session{Address: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}})
+	viewer.Level = lv
+	viewer.SendRequest = make(chan MCPEPacket, 4)
+	s.players[viewer.Address.String()] = viewer
+	items := make(map[uint64]*Level)
+	for eid := uint64(200); eid < 205; eid++ {
+		s.movements[eid] = [6]float32{float32(eid), 2, 3}
+		items[eid] = lv
+	}
+	s.flushMovements(items)
+	if len(viewer.SendRequest) != 1 {
+		t.Fatal("Expected one MoveEntity, got", len(viewer.SendRequest), "packets")
+	}
+	pk := (<-viewer.SendRequest).(*MoveEntity)
+	if len(pk.EntityIDs) != len(items) {
+		t.Fatal("Movements are not batched:", pk.EntityIDs)
+	}
+	for i, eid := range pk.EntityIDs {
+		if items[eid] == nil || pk.EntityPos[i][0] != float32(eid) {
+			t.Fatal("Unexpected movement of entity", eid)
+		}
+	}
+}
+
+func TestSendTo(t *testing.T) {
+	s := NewServer()
+	alice, bob := broadcastTarget(s, 1), broadcastTarget(s, 2)
+	alice.Username, bob.Username = "Alice", "Bob"
+	s.Start()
+	defer s.Stop()
+	if err := s.SendTo("bob", &Text{TextType: TextTypeRaw, Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bob.SendRequest) != 1 || len(alice.SendRequest) != 0 {
+		t.Fatal("Packet is not sent to the named player only")
+	}
+	if pk := (<-bob.SendRequest).(*Text); pk.Message != "hello" {
+		t.Fatal("Unexpected message:", pk.Message)
+	}
+	if err := s.SendTo("Carol", &Text{}); err == nil {
+		t.Fatal("Sending to offline player succeeded")
+	}
+}
+
+func TestRosterPosition(t *testing.T) {
+	s := NewServer()
+	s.JoinMessage = ""
+	s.Start()
+	defer s.Stop()
+	p := loginPlayer(t, s, "Steve", 1)
+	spawn := p.Position
+	if roster := s.Roster(); len(roster) != 1 || roster[0].Position != spawn {
+		t.Fatal("Unexpected roster position after join:", roster)
+	}
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i <= 10; i++ {
+			MovePlayer{X: spawn.X + float32(i)/10, Y: spawn.Y, Z: spawn.Z}.Handle(p)
+		}
+		close(done)
+	}()
+	for moving := true; moving; {
+		select {
+		case <-done:
+			moving = false
+		default:
+		}
+		s.Roster() // Must not race with the movement
+	}
+	if roster := s.Roster(); roster[0].Position != p.Position {
+		t.Fatal("Roster position is not updated:", roster[0].Position, p.Position)
+	}
+}
@@ -6,16 +6,56 @@ import (
 	"math/rand"
 	"net"
 	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
-const windowSize = 2048
+// SessionConfig contains tunable options for Raknet sessions.
+type SessionConfig struct {
+	// WindowSize is a size of both received packet window and reliable message window.
+	WindowSize uint32
+	// Rand is a random source for the session, such as ping IDs.
+	// If nil, time-seeded source is used. It should not be shared between sessions.
+	Rand *rand.Rand
+	// SplitTimeout is how long incomplete split packets are kept for reassembly.
+	// If zero, DefaultSplitTimeout is used.
+	SplitTimeout time.Duration
+	// SendBudget is a maximum bytes of datagrams sent per update tick, excluding ACK/NACK.
+	// Excess packets are deferred to next ticks. Zero means unlimited.
+	SendBudget int
+}
+
+// DefaultSessionConfig is a SessionConfig used when router has no explicit configuration.
+var DefaultSessionConfig = SessionConfig{
+	WindowSize: 2048,
+}
 
 // MaxPingTries defines max retry count on ping timeout.
 // If ping timeouts MaxPingTries + 1 times, session will be closed.
 const MaxPingTries uint64 = 3
 
+// MaxReliableBuffered is a max count of out-of-order reliable packets buffered on a session.
+// Packets beyond this are not buffered, and retransmission is requested until the gap fills.
+const MaxReliableBuffered = 512
+
+// DefaultSplitTimeout is a default value for SessionConfig.SplitTimeout.
+const DefaultSplitTimeout = time.Second * 10
+
+// MaxSplitGroups is a max count of incomplete split packets reassembled at once on a session.
+// Splits of new packets beyond this are dropped.
+const MaxSplitGroups = 32
+
+// splitGroup is a set of received parts of a split packet.
+type splitGroup struct {
+	parts   map[uint32][]byte
+	count   uint32 // SplitCount of the first part
+	created time.Time
+}
+
+// PingInterval defines how often the server measures round-trip time of connected sessions.
+const PingInterval = time.Second * 5
+
 // RecoveryTimeout defines how long packets can live on recoery queue.
 // Once the packet is sent, the packet will be on recoery queue in RecoveryTimeout duration.
 const RecoveryTimeout = time.Second * 8
@@ -23,6 +63,8 @@ const RecoveryTimeout = time.Second * 8
 // SessionLock is a explicit locker for Sessions map.
 var timeout = time.Millisecond * 2000
 
+// ackUpdate is a request to modify ACK/NACK queues or recovery queue.
+// Those are owned by sendAsync goroutine, so every modification should be sent via AckChan.
 type ackUpdate struct {
 	got  bool // true: got ACK/NACK, false: remove ACK/NACK queue
 	nack bool // true: NACK, false: ACK
@@ -31,24 +73,33 @@ type ackUpdate struct {
 
 type session struct {
 	Status           byte
-	ReceivedChan     chan Packet // Packet from router
-	SendChan         chan Packet // Send request to router
+	ReceivedChan     chan Packet     // Packet from router
+	SendChan         chan Packet     // Send request to router
+	sendClosed       <-chan struct{} // Closed when SendChan is no longer received, e.g. router is stopped
 	EncapsulatedChan chan *EncapsulatedPacket
 	AckChan          chan ackUpdate
+	drainRequest     chan chan struct{}
 
 	Player *player
 	Server *Server
+	Config SessionConfig
 
 	ID                 uint64
 	Address            *net.UDPAddr
+	serverID           uint64 // Server ID of the router
 	updateTicker       *time.Ticker
 	windowUpdateTicker *time.Ticker
 	timeout            *time.Timer
 	mtuSize            uint32
 
-	ackQueue  map[uint32]struct{}
-	nackQueue map[uint32]struct{}
-	recovery  map[uint32]*DataPacket
+	// Owned by sendAsync goroutine: modify them with AckChan from other goroutines.
+	ackQueue   map[uint32]struct{}
+	nackQueue  map[uint32]struct{}
+	recovery   map[uint32]*DataPacket
+	deferred   []*EncapsulatedPacket // Packets exceeding send budget
+	sentBytes  int                   // Bytes sent on current update tick
+	sendBudget int64                 // atomic
+	drained    chan struct{}         // Closed when recovery queue is empty, while draining
 
 	packetWindow   map[uint32]bool
 	windowBorder   [2]uint32 // Window range: [windowBorder[0], windowBorder[1])
@@ -59,61 +110,86 @@ type session struct {
 	lastSeq      uint32 // Recv
 	lastMsgIndex uint32
 	splitID      uint16
-	splitTable   map[uint16]map[uint32][]byte
+	splitTable   map[uint16]*splitGroup
 	messageIndex uint32
 	channelIndex [8]uint32
 
 	playerAdder   func(*net.UDPAddr) chan<- *bytes.Buffer
 	playerRemover func(*net.UDPAddr) error
 	pingTries     uint64
+	pingTicker    *time.Ticker
+	pingID        uint64
+	pingTime      time.Time
+	ping          int64 // time.Duration, atomic
 	closed        chan struct{}
+	closeOnce     sync.Once
 }
 
-// NewSession returns new session instance.
-func NewSession(address *net.UDPAddr) *session {
+// NewSession returns new session instance with given session configuration.
+func NewSession(address *net.UDPAddr, config SessionConfig) *session {
 	s := new(session)
 	s.Address = address
+	s.Config = config
+	s.sendBudget = int64(config.SendBudget)
+	if s.Config.Rand == nil {
+		s.Config.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if s.Config.SplitTimeout == 0 {
+		s.Config.SplitTimeout = DefaultSplitTimeout
+	}
 
 	s.ReceivedChan = make(chan Packet, chanBufsize)
 	s.EncapsulatedChan = make(chan *EncapsulatedPacket, chanBufsize)
 	s.AckChan = make(chan ackUpdate, chanBufsize)
+	s.drainRequest = make(chan chan struct{}, chanBufsize)
 	s.closed = make(chan struct{})
 
 	s.updateTicker = time.NewTicker(time.Millisecond * 100)
 	s.windowUpdateTicker = time.NewTicker(time.Millisecond * 100)
+	s.pingTicker = time.NewTicker(PingInterval)
 	s.timeout = time.NewTimer(time.Millisecond * 1500)
 
 	s.ackQueue = make(map[uint32]struct{})
 	s.nackQueue = make(map[uint32]struct{})
 	s.recovery = make(map[uint32]*DataPacket)
 
-	s.seqNumber = 1<<32 - 1
+	s.seqNumber = 1<<32 - 1 // First nextSeq() wraps to 0
 	s.packetWindow = make(map[uint32]bool)
 	s.reliableWindow = make(map[uint32]*EncapsulatedPacket)
 
-	s.splitTable = make(map[uint16]map[uint32][]byte)
+	s.splitTable = make(map[uint16]*splitGroup)
 
-	s.windowBorder = [2]uint32{0, windowSize}
-	s.reliableBorder = [2]uint32{0, windowSize}
+	s.windowBorder = [2]uint32{0, config.WindowSize}
+	s.reliableBorder = [2]uint32{0, config.WindowSize}
 
+	// Received seq/message index 0 should be accepted as the next one of last ones
 	s.lastSeq = ^uint32(0)
 	s.lastMsgIndex = ^uint32(0)
 	return s
 }
 
+// sessionGoroutines is a count of running session/player goroutines.
+var sessionGoroutines int64
+
+// SessionGoroutines returns count of running goroutines owned by sessions and players.
+// It should return to previous value after sessions are closed, unless goroutines are leaking.
+func SessionGoroutines() int64 {
+	return atomic.LoadInt64(&sessionGoroutines)
+}
+
 func (s *session) work() {
+	atomic.AddInt64(&sessionGoroutines, 1)
+	defer atomic.AddInt64(&sessionGoroutines, -1)
 	for {
 		select { // Workaround for first-class priority close signal
 		case <-s.closed:
-			s.updateTicker.Stop()
-			s.timeout.Stop()
+			s.stopWork()
 			return
 		default:
 		}
 		select {
 		case <-s.closed:
-			s.updateTicker.Stop()
-			s.timeout.Stop()
+			s.stopWork()
 			return
 		case pk := <-s.ReceivedChan:
 			s.handlePacket(pk)
@@ -122,19 +198,52 @@ func (s *session) work() {
 				s.Close("timeout")
 				break
 			}
-			p := &Ping{PingID: uint64(rand.Uint32())<<32 | uint64(rand.Uint32())}
-			buf := Pool.NewBuffer(nil)
-			p.Write(buf)
-			s.sendEncapsulatedDirect(&EncapsulatedPacket{Buffer: buf})
+			s.sendPing()
 			s.pingTries++
 			s.timeout.Reset(timeout)
+		case <-s.pingTicker.C:
+			if s.Status == 3 {
+				s.sendPing()
+			}
 		case <-s.windowUpdateTicker.C:
 			s.windowUpdate()
+			s.expireSplits()
+			if s.Player != nil {
+				s.Player.tick()
+			}
 		}
 	}
 }
 
+// stopWork stops timers of work goroutine, and releases chunks sent to the player.
+// Chunks are released here, as sent chunks are owned by packet handling goroutine.
+func (s *session) stopWork() {
+	s.updateTicker.Stop()
+	s.windowUpdateTicker.Stop()
+	s.pingTicker.Stop()
+	s.timeout.Stop()
+	if s.Player != nil && s.Player.Level != nil {
+		s.Player.releaseChunks()
+	}
+}
+
+func (s *session) sendPing() {
+	s.pingID = uint64(s.Config.Rand.Uint32())<<32 | uint64(s.Config.Rand.Uint32())
+	s.pingTime = time.Now()
+	p := &Ping{PingID: s.pingID}
+	buf := Pool.NewBuffer(nil)
+	p.Write(buf)
+	s.sendEncapsulatedDirect(&EncapsulatedPacket{Buffer: buf})
+}
+
+// Ping returns last measured round-trip time of the session.
+func (s *session) Ping() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.ping))
+}
+
 func (s *session) sendAsync() {
+	atomic.AddInt64(&sessionGoroutines, 1)
+	defer atomic.AddInt64(&sessionGoroutines, -1)
 	for {
 		select { // Workaround for first-class priority close signal
 		case <-s.closed:
@@ -149,22 +258,97 @@ func (s *session) sendAsync() {
 			s.timeout.Stop()
 			return
 		case ep := <-s.EncapsulatedChan:
-			dp := new(DataPacket)
-			dp.Head = 0x80
-			dp.SeqNumber = atomic.AddUint32(&s.seqNumber, 1)
-			dp.Packets = []*EncapsulatedPacket{ep}
-			dp.Encode()
-			s.send(dp.Buffer)
-			dp.SendTime = time.Now()
-			s.recovery[dp.SeqNumber] = dp
+			if s.drained != nil { // Draining: no more new packets
+				continue
+			}
+			if len(s.deferred) > 0 || !s.sendable(ep) {
+				s.deferred = append(s.deferred, ep)
+				continue
+			}
+			s.sendDatagram(ep)
 		case u := <-s.AckChan:
 			s.handleAckUpdate(u)
+			s.checkDrained()
+		case done := <-s.drainRequest:
+			s.drain(done)
 		case <-s.updateTicker.C:
 			s.update()
 		}
 	}
 }
 
+// drain sends every deferred packets, resends unacknowledged packets, and closes done once all of them are acknowledged.
+// Packets already queued on EncapsulatedChan, such as Disconnect sent just before the drain request, are sent too.
+func (s *session) drain(done chan struct{}) {
+	for queued := true; queued; {
+		select {
+		case ep := <-s.EncapsulatedChan:
+			s.deferred = append(s.deferred, ep)
+		default:
+			queued = false
+		}
+	}
+	s.drained = done
+	for _, ep := range s.deferred {
+		s.sendDatagram(ep)
+	}
+	s.deferred = nil
+	for _, dp := range s.recovery {
+		s.resend(dp.Buffer)
+	}
+	s.checkDrained()
+}
+
+// checkDrained closes drained channel if the session is draining and recovery queue is empty.
+func (s *session) checkDrained() {
+	if s.drained != nil && len(s.recovery) == 0 {
+		select {
+		case <-s.drained:
+		default:
+			close(s.drained)
+		}
+	}
+}
+
+// SetSendBudget sets maximum bytes of datagrams sent per update tick. Zero means unlimited.
+// It is safe to call from any goroutine.
+func (s *session) SetSendBudget(budget int) {
+	atomic.StoreInt64(&s.sendBudget, int64(budget))
+}
+
+// sendable returns whether the packet fits in send budget of current tick.
+// The first packet on a tick is always sendable, so large packets are not deferred forever.
+func (s *session) sendable(ep *EncapsulatedPacket) bool {
+	budget := int(atomic.LoadInt64(&s.sendBudget))
+	return budget <= 0 || s.sentBytes == 0 || s.sentBytes+ep.TotalLen() <= budget
+}
+
+// sendDatagram sends the packet with new datagram, and adds it to recovery queue.
+func (s *session) sendDatagram(ep *EncapsulatedPacket) {
+	dp := new(DataPacket)
+	dp.Head = 0x80
+	dp.SeqNumber = s.nextSeq()
+	dp.Packets = []*EncapsulatedPacket{ep}
+	dp.Encode()
+	s.sentBytes += dp.Len()
+	s.resend(dp.Buffer)
+	dp.SendTime = time.Now()
+	s.recovery[dp.SeqNumber] = dp
+}
+
+// flushDeferred resets send budget for new tick, and sends deferred packets within the budget.
+func (s *session) flushDeferred() {
+	s.sentBytes = 0
+	n := 0
+	for ; n < len(s.deferred) && s.sendable(s.deferred[n]); n++ {
+		s.sendDatagram(s.deferred[n])
+	}
+	s.deferred = s.deferred[n:]
+	if len(s.deferred) == 0 {
+		s.deferred = nil
+	}
+}
+
 func (s *session) update() {
 	if len(s.ackQueue) > 0 {
 		acks := make([]uint32, len(s.ackQueue))
@@ -192,6 +376,7 @@ func (s *session) update() {
 		s.send(b)
 		s.nackQueue = make(map[uint32]struct{})
 	}
+	s.flushDeferred()
 	for seq, pk := range s.recovery {
 		if pk.SendTime.Add(RecoveryTimeout).Before(time.Now()) {
 			s.send(pk.Buffer)
@@ -217,7 +402,7 @@ func (s *session) handleAckUpdate(u ackUpdate) {
 		if u.nack {
 			for _, seq := range u.seqs {
 				if dp, ok := s.recovery[seq]; ok {
-					s.send(dp.Buffer)
+					s.resend(dp.Buffer)
 				}
 			}
 		} else {
@@ -268,60 +453,116 @@ func (s *session) handlePacket(pk Packet) {
 	}
 }
 
-func (s *session) preEncapsulated(ep *EncapsulatedPacket) {
-	if ep.Reliability >= 2 && ep.Reliability != 5 { // MessageIndex exists
-		if ep.MessageIndex < s.reliableBorder[0] || ep.MessageIndex >= s.reliableBorder[1] { // Outside of window
-			//log.Println("MessageIndex drop:", ep.MessageIndex, "should be", s.reliableBorder[0], "<= n <", s.reliableBorder[1])
-			return
+// acceptable returns whether every reliable packets on the datagram can be handled or buffered now.
+// If not, the datagram should be retransmitted later, and none of its packets should be handled:
+// otherwise unreliable packets on it would be handled twice.
+func (s *session) acceptable(eps []*EncapsulatedPacket) bool {
+	incoming := make(map[uint32]struct{})
+	for _, ep := range eps {
+		if ep.Reliability < 2 || ep.Reliability == 5 || ep.MessageIndex < s.reliableBorder[0] {
+			continue
 		}
-		if ep.MessageIndex-s.lastMsgIndex == 1 {
+		if ep.MessageIndex >= s.reliableBorder[1] { // Reliable window overflow
+			log.Println("Warning: MessageIndex", ep.MessageIndex, "is ahead of reliable window, requesting retransmission")
+			return false
+		}
+		if _, ok := s.reliableWindow[ep.MessageIndex]; !ok {
+			incoming[ep.MessageIndex] = struct{}{}
+		}
+	}
+	buffered := len(s.reliableWindow) + len(incoming)
+	for next := s.lastMsgIndex + 1; ; next++ { // Packets in order are handled instead of buffered
+		_, in := incoming[next]
+		_, win := s.reliableWindow[next]
+		if !in && !win {
+			break
+		}
+		buffered--
+	}
+	if buffered > MaxReliableBuffered {
+		log.Println("Warning: reliable window is full, waiting for MessageIndex", s.lastMsgIndex+1)
+		return false
+	}
+	return true
+}
+
+// preEncapsulated orders reliable packets with MessageIndex, and passes them to handleEncapsulated.
+// Packets ahead of current message index are buffered on reliableWindow until the gap fills.
+// The datagram should be checked with acceptable first, so the window does not overflow.
+func (s *session) preEncapsulated(ep *EncapsulatedPacket) {
+	if ep.Reliability < 2 || ep.Reliability == 5 { // MessageIndex does not exist
+		s.handleEncapsulated(ep)
+		return
+	}
+	if ep.MessageIndex < s.reliableBorder[0] { // Already handled
+		return
+	}
+	if ep.MessageIndex-s.lastMsgIndex != 1 {
+		s.reliableWindow[ep.MessageIndex] = ep
+		return
+	}
+	s.lastMsgIndex++
+	s.reliableBorder[0]++
+	s.reliableBorder[1]++
+	s.handleEncapsulated(ep)
+	if len(s.reliableWindow) > 0 {
+		for _, i := range GetSortedKeys(s.reliableWindow) {
+			if uint32(i)-s.lastMsgIndex != 1 {
+				break
+			}
 			s.lastMsgIndex++
 			s.reliableBorder[0]++
 			s.reliableBorder[1]++
-			s.handleEncapsulated(ep)
-			if len(s.reliableWindow) > 0 {
-				for _, i := range GetSortedKeys(s.reliableWindow) {
-					if uint32(i)-s.lastMsgIndex != 1 {
-						break
-					}
-					s.lastMsgIndex++
-					s.reliableBorder[0]++
-					s.reliableBorder[1]++
-					s.handleEncapsulated(s.reliableWindow[uint32(i)])
-					delete(s.reliableWindow, uint32(i))
-				}
-			}
-		} else {
-			s.reliableWindow[ep.MessageIndex] = ep
+			s.handleEncapsulated(s.reliableWindow[uint32(i)])
+			delete(s.reliableWindow, uint32(i))
 		}
-	} else {
-		s.handleEncapsulated(ep)
 	}
 }
 
 func (s *session) joinSplits(ep *EncapsulatedPacket) {
-	if s.Status < 3 {
+	if s.Status < 3 || ep.SplitCount > MaxSplitCount || ep.SplitIndex >= ep.SplitCount {
 		return
 	}
-	tab, ok := s.splitTable[ep.SplitID]
+	group, ok := s.splitTable[ep.SplitID]
 	if !ok {
-		s.splitTable[ep.SplitID] = make(map[uint32][]byte)
-		tab = s.splitTable[ep.SplitID]
+		if len(s.splitTable) >= MaxSplitGroups {
+			log.Println("Too many split packets on", s.Address, "dropping split", ep.SplitID)
+			return
+		}
+		group = &splitGroup{
+			parts:   make(map[uint32][]byte),
+			count:   ep.SplitCount,
+			created: time.Now(),
+		}
+		s.splitTable[ep.SplitID] = group
 	}
-	if _, ok := tab[ep.SplitIndex]; !ok {
-		tab[ep.SplitIndex] = ep.Buffer.Bytes()
+	if ep.SplitCount != group.count {
+		log.Println("Split count mismatch on", s.Address, "split", ep.SplitID, "dropping part", ep.SplitIndex)
+		return
+	}
+	if _, ok := group.parts[ep.SplitIndex]; !ok {
+		group.parts[ep.SplitIndex] = ep.Buffer.Bytes()
 	}
-	if len(tab) == int(ep.SplitCount) {
+	if len(group.parts) == int(group.count) {
 		sep := new(EncapsulatedPacket)
 		sep.Buffer = Pool.NewBuffer(nil)
-		for i := uint32(0); i < ep.SplitCount; i++ {
-			sep.Write(tab[i])
+		for i := uint32(0); i < group.count; i++ {
+			sep.Write(group.parts[i])
 		}
 		delete(s.splitTable, ep.SplitID)
 		s.handleEncapsulated(sep)
 	}
 }
 
+// expireSplits discards split packets which are not completed in SplitTimeout.
+func (s *session) expireSplits() {
+	for id, group := range s.splitTable {
+		if time.Since(group.created) > s.Config.SplitTimeout {
+			delete(s.splitTable, id)
+		}
+	}
+}
+
 func (s *session) handleEncapsulated(ep *EncapsulatedPacket) {
 	if ep.HasSplit {
 		if s.Status > 2 {
@@ -333,8 +574,13 @@ func (s *session) handleEncapsulated(ep *EncapsulatedPacket) {
 	}
 	head := ReadByte(ep.Buffer)
 
-	if s.Status > 2 && head == 0x8e {
-		s.Player.HandlePacket(ep.Buffer)
+	if head == 0x8e {
+		if s.Status > 2 {
+			s.Player.HandlePacket(ep.Buffer)
+		} else {
+			log.Println("Warning: Got MCPE packet in connecting state, dropping")
+			Pool.Recycle(ep.Buffer)
+		}
 		return
 	}
 
@@ -354,6 +600,10 @@ func (s *session) connComplete() {
 
 // SendEncapsulated processes EncapsulatedPacket informations before sending.
 func (s *session) SendEncapsulated(ep *EncapsulatedPacket) {
+	if ep.Len() > MaxPacketSize { // Splits would exceed MaxSplitCount
+		log.Println("Dropping too large packet to", s.Address, "with", ep.Len(), "bytes")
+		return
+	}
 	if ep.Reliability >= 2 && ep.Reliability != 5 {
 		ep.MessageIndex = s.messageIndex
 		s.messageIndex++
@@ -366,7 +616,7 @@ func (s *session) SendEncapsulated(ep *EncapsulatedPacket) {
 		splitID := s.splitID
 		s.splitID++
 		splitIndex := uint32(0)
-		mtu := (atomic.LoadUint32(&s.mtuSize) - 34)
+		mtu := (atomic.LoadUint32(&s.mtuSize) - splitOverhead)
 		splitCount := uint32(ep.Len()) / mtu
 		if uint32(ep.Len())%mtu != 0 {
 			splitCount++
@@ -397,25 +647,71 @@ func (s *session) SendEncapsulated(ep *EncapsulatedPacket) {
 func (s *session) sendEncapsulatedDirect(ep *EncapsulatedPacket) {
 	dp := new(DataPacket)
 	dp.Head = 0x80
-	dp.SeqNumber = atomic.AddUint32(&s.seqNumber, 1)
+	dp.SeqNumber = s.nextSeq()
 	dp.Packets = []*EncapsulatedPacket{ep}
 	dp.Encode()
 	s.send(dp.Buffer)
 }
 
+// nextSeq returns sequence number for new datagram.
+// Sequence numbers are encoded as triad, so they are masked to 24 bits to match with ACKs from client.
+func (s *session) nextSeq() uint32 {
+	return atomic.AddUint32(&s.seqNumber, 1) & 0xffffff
+}
+
 func (s *session) send(pk *bytes.Buffer) {
-	s.SendChan <- Packet{pk, s.Address, true}
+	s.sendPacket(Packet{pk, s.Address, true})
+}
+
+// resend sends datagram kept on recovery queue, without recycling the buffer after sending.
+func (s *session) resend(pk *bytes.Buffer) {
+	s.sendPacket(Packet{pk, s.Address, false})
+}
+
+// sendPacket passes the packet to router. The packet is dropped if the router is stopped.
+func (s *session) sendPacket(pk Packet) {
+	select {
+	case s.SendChan <- pk:
+	case <-s.sendClosed:
+	}
+}
+
+// DisconnectDrain is a time to wait for ACKs of remaining packets on graceful close.
+const DisconnectDrain = time.Millisecond * 500
+
+// CloseGraceful stops accepting new packets, resends unacknowledged packets, and closes the session
+// once they are acknowledged or drain duration passes. It returns immediately without waiting.
+func (s *session) CloseGraceful(reason string, drain time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case s.drainRequest <- done:
+		case <-s.closed:
+			return
+		}
+		timer := time.NewTimer(drain)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+		case <-s.closed:
+			return
+		}
+		s.Close(reason)
+	}()
 }
 
 // Close stops current session.
 func (s *session) Close(reason string) {
-	select {
-	case <-s.closed: // Already closed
+	closing := false
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		closing = true
+	})
+	if !closing { // Already closed
 		log.Println("Warning: duplicate close attempt")
 		return
-	default:
 	}
-	close(s.closed)
 	data := &EncapsulatedPacket{Buffer: Pool.NewBuffer([]byte{0x15})}
 	s.sendEncapsulatedDirect(data)
 	log.Println("Session closed:", reason)
@@ -0,0 +1,479 @@
+package highmc
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testSession returns session with buffered channels, and starts its send goroutine.
+func testSession(t *testing.T) *session {
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.SendChan = make(chan Packet, 64)
+	s.EncapsulatedChan = make(chan *EncapsulatedPacket, 64)
+	go s.sendAsync()
+	t.Cleanup(func() { s.Close("test end") })
+	return s
+}
+
+// readDatagram decodes next datagram sent by the session.
+func readDatagram(t *testing.T, s *session) *DataPacket {
+	select {
+	case pk := <-s.SendChan:
+		b := pk.Buffer.Bytes()
+		if b[0] < 0x80 || b[0] > 0x8f {
+			t.Fatalf("Expected data packet, got 0x%02x", b[0])
+		}
+		dp := &DataPacket{Buffer: bytes.NewBuffer(append([]byte(nil), b[1:]...))}
+		dp.Decode()
+		return dp
+	case <-time.After(time.Second):
+		t.Fatal("Timeout while waiting for datagram")
+	}
+	return nil
+}
+
+func TestDrainQueuedPackets(t *testing.T) {
+	s := testSession(t)
+	s.EncapsulatedChan <- &EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer([]byte{0x8e, DisconnectHead})}
+	done := make(chan struct{})
+	s.drainRequest <- done
+	dp := readDatagram(t, s)
+	if len(dp.Packets) != 1 || !bytes.Equal(dp.Packets[0].Buffer.Bytes(), []byte{0x8e, DisconnectHead}) {
+		t.Fatal("Queued packet is not sent while draining")
+	}
+	select {
+	case <-done:
+		t.Fatal("Drain completed before ACK")
+	default:
+	}
+	s.AckChan <- ackUpdate{got: true, seqs: []uint32{dp.SeqNumber}}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Drain is not completed after ACK")
+	}
+}
+
+func TestCloseGraceful(t *testing.T) {
+	s := testSession(t)
+	s.EncapsulatedChan <- &EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}
+	sent := readDatagram(t, s)
+	s.CloseGraceful("test kick", time.Second)
+	if dp := readDatagram(t, s); dp.SeqNumber != sent.SeqNumber {
+		t.Fatal("Unexpected datagram while draining:", dp.SeqNumber)
+	}
+	select {
+	case <-s.closed:
+		t.Fatal("Session is closed before ACK")
+	default:
+	}
+	s.AckChan <- ackUpdate{got: true, seqs: []uint32{sent.SeqNumber}}
+	select {
+	case <-s.closed:
+	case <-time.After(time.Second):
+		t.Fatal("Session is not closed after pending packets are acknowledged")
+	}
+
+	s = testSession(t)
+	s.EncapsulatedChan <- &EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}
+	readDatagram(t, s)
+	s.CloseGraceful("test kick", time.Millisecond*50)
+	readDatagram(t, s)
+	select {
+	case <-s.closed:
+	case <-time.After(time.Second):
+		t.Fatal("Session is not closed after drain timeout")
+	}
+}
+
+func TestResendKeepsBuffer(t *testing.T) {
+	s := testSession(t)
+	s.EncapsulatedChan <- &EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer([]byte{1, 2, 3})}
+	select {
+	case pk := <-s.SendChan:
+		if pk.Recycle {
+			t.Fatal("Datagram on recovery queue is recycled after sending")
+		}
+		s.AckChan <- ackUpdate{got: true, nack: true, seqs: []uint32{0}}
+		if re := <-s.SendChan; re.Buffer != pk.Buffer || re.Recycle {
+			t.Fatal("NACKed datagram is not resent from recovery queue without recycling")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout while waiting for datagram")
+	}
+}
+
+func TestCloseReleasesChunks(t *testing.T) {
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.SendChan = make(chan Packet, 64)
+	lv := testLevel()
+	s.Player = NewPlayer(s)
+	s.Player.Level = lv
+	s.Player.chunkRadius = 0
+	s.Player.unsentChunks(ChunkPos{})
+	chunk := lv.LoadedChunks[ChunkPos{}]
+	if atomic.LoadUint64(&chunk.Refs) != 1 {
+		t.Fatal("Sent chunk is not referenced")
+	}
+	done := make(chan struct{})
+	go func() {
+		s.work()
+		close(done)
+	}()
+	s.Close("test end")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Session work goroutine did not return after close")
+	}
+	if refs := atomic.LoadUint64(&chunk.Refs); refs != 0 || len(s.Player.sentChunks) != 0 {
+		t.Fatal("Sent chunks are not released after close:", refs)
+	}
+}
+
+func TestDatagramRetransmitWholly(t *testing.T) {
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.Status = 2
+	s.AckChan = make(chan ackUpdate, 8)
+	for i := uint32(1); i <= MaxReliableBuffered; i++ { // Full reliable window, waiting for MessageIndex 0
+		s.reliableWindow[i] = &EncapsulatedPacket{Reliability: 2, MessageIndex: i, Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}
+	}
+	unreliable := &EncapsulatedPacket{Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}
+	ahead := &EncapsulatedPacket{Reliability: 2, MessageIndex: MaxReliableBuffered + 1, Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}
+	(&GeneralDataPacket{SeqNumber: 0, Packets: []*EncapsulatedPacket{unreliable, ahead}}).Handle(s)
+	if u := <-s.AckChan; !u.nack || len(u.seqs) != 1 || u.seqs[0] != 0 {
+		t.Fatal("Datagram overflowing reliable window is not NACKed")
+	}
+	if unreliable.Len() != 2 || s.lastSeq != ^uint32(0) || len(s.reliableWindow) != MaxReliableBuffered {
+		t.Fatal("Packets on NACKed datagram are handled")
+	}
+
+	gap := &EncapsulatedPacket{Reliability: 2, MessageIndex: 0, Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}
+	(&GeneralDataPacket{SeqNumber: 1, Packets: []*EncapsulatedPacket{ahead, gap}}).Handle(s)
+	<-s.AckChan // NACK of the gap, sequence number 0
+	if u := <-s.AckChan; u.nack || len(u.seqs) != 1 || u.seqs[0] != 1 {
+		t.Fatal("Datagram filling the gap is not ACKed")
+	}
+	if s.lastMsgIndex != MaxReliableBuffered+1 || len(s.reliableWindow) != 0 {
+		t.Fatal("Buffered packets are not handled after the gap is filled:", s.lastMsgIndex, len(s.reliableWindow))
+	}
+}
+
+func TestReliableWindowBounded(t *testing.T) {
+	const flood = MaxReliableBuffered * 2
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.Status = 2
+	s.AckChan = make(chan ackUpdate, 8)
+	defer close(s.AckChan)
+	go func() {
+		for range s.AckChan { // Rejected datagrams are NACKed again on every following datagram
+		}
+	}()
+	for i := uint32(0); i < flood; i++ { // MessageIndex 0 never arrives
+		ep := &EncapsulatedPacket{Reliability: 2, MessageIndex: i + 1, Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}
+		(&GeneralDataPacket{SeqNumber: i, Packets: []*EncapsulatedPacket{ep}}).Handle(s)
+		if len(s.reliableWindow) > MaxReliableBuffered {
+			t.Fatal("Reliable window grows over the cap:", len(s.reliableWindow))
+		}
+	}
+	if len(s.reliableWindow) != MaxReliableBuffered {
+		t.Fatal("Unexpected reliable window size:", len(s.reliableWindow))
+	}
+}
+
+func TestJoinSplitsCountMismatch(t *testing.T) {
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.Status = 3
+	part := func(index, count uint32) *EncapsulatedPacket {
+		return &EncapsulatedPacket{
+			HasSplit: true, SplitID: 1, SplitIndex: index, SplitCount: count,
+			Buffer: bytes.NewBuffer([]byte{0xff}), // Unknown packet head, ignored after joined
+		}
+	}
+	s.joinSplits(part(2, 3))
+	s.joinSplits(part(1, 2)) // Would join missing part 0 with count 2
+	if group, ok := s.splitTable[1]; !ok || len(group.parts) != 1 {
+		t.Fatal("Split part with mismatching count is not dropped")
+	}
+	s.joinSplits(part(0, 3))
+	s.joinSplits(part(1, 3))
+	if len(s.splitTable) != 0 {
+		t.Fatal("Split parts are not joined")
+	}
+}
+
+func TestSplitExpire(t *testing.T) {
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.Status = 3
+	part := func(id uint16) *EncapsulatedPacket {
+		return &EncapsulatedPacket{HasSplit: true, SplitID: id, SplitCount: 2, Buffer: bytes.NewBuffer([]byte{0xff})}
+	}
+	s.joinSplits(part(1))
+	s.joinSplits(part(2))
+	s.splitTable[1].created = time.Now().Add(-DefaultSplitTimeout - time.Second) // Abandoned
+	s.expireSplits()
+	if _, ok := s.splitTable[1]; ok {
+		t.Fatal("Abandoned split packet is not expired")
+	}
+	if _, ok := s.splitTable[2]; !ok {
+		t.Fatal("Recent split packet is expired")
+	}
+
+	for i := 0; i < MaxSplitGroups*2; i++ {
+		s.joinSplits(part(uint16(i + 10)))
+	}
+	if len(s.splitTable) != MaxSplitGroups {
+		t.Fatal("Split groups are not capped:", len(s.splitTable))
+	}
+}
+
+func TestSplitCountBound(t *testing.T) {
+	ep := &EncapsulatedPacket{HasSplit: true, SplitCount: 1 << 30, Buffer: bytes.NewBuffer([]byte{0xff})}
+	if err := Safe(func() { NewEncapsulated(ep.Bytes()) }); err == nil {
+		t.Fatal("Packet with absurd split count is decoded")
+	}
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.Status = 3
+	if allocs := testing.AllocsPerRun(10, func() {
+		ep.SplitIndex = 1<<30 - 1
+		s.joinSplits(ep)
+	}); allocs != 0 || len(s.splitTable) != 0 {
+		t.Fatal("Split part with absurd split count is not dropped")
+	}
+}
+
+func TestPacketsBeforeConnection(t *testing.T) {
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.AckChan = make(chan ackUpdate, 8)
+	login := func() *EncapsulatedPacket {
+		return &EncapsulatedPacket{Buffer: bytes.NewBuffer(append([]byte{0x8e}, (&Login{Username: "Steve"}).Write().Bytes()...))}
+	}
+	(&GeneralDataPacket{SeqNumber: 0, Packets: []*EncapsulatedPacket{login()}}).Handle(s)
+	if s.lastSeq != ^uint32(0) || len(s.AckChan) != 0 {
+		t.Fatal("Datagram is handled before connection is opened")
+	}
+
+	// Connection is opened, but client handshake is not done. Login would panic if it is passed to nil Player.
+	s.Status = 2
+	(&GeneralDataPacket{SeqNumber: 0, Packets: []*EncapsulatedPacket{login()}}).Handle(s)
+	if s.lastSeq != 0 || s.Player != nil || s.Status != 2 {
+		t.Fatal("Login is processed before handshake completion")
+	}
+}
+
+func TestSessionWindowSize(t *testing.T) {
+	const seq = 3000 // Beyond default window size
+	config := DefaultSessionConfig
+	config.WindowSize = 4096
+	for _, c := range []struct {
+		config   SessionConfig
+		seq      uint32
+		accepted bool
+	}{
+		{DefaultSessionConfig, seq, false},
+		{config, seq, true},
+		{config, config.WindowSize, false},
+	} {
+		s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, c.config)
+		s.Status = 2
+		s.AckChan = make(chan ackUpdate, c.seq+1) // NACKs for the gap
+		(&GeneralDataPacket{SeqNumber: c.seq, Packets: []*EncapsulatedPacket{{Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}}}).Handle(s)
+		if accepted := s.lastSeq == c.seq; accepted != c.accepted {
+			t.Fatal("Window size", c.config.WindowSize, "accepted sequence number", c.seq, ":", accepted)
+		}
+	}
+}
+
+func TestSequenceNumbers(t *testing.T) {
+	s := testSession(t)
+	for i := uint32(0); i < 3; i++ {
+		s.EncapsulatedChan <- &EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}
+		if seq := readDatagram(t, s).SeqNumber; seq != i {
+			t.Fatal("Expected sequence number", i, "got", seq)
+		}
+	}
+
+	s = NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.seqNumber = 0xffffff - 1
+	if s.nextSeq() != 0xffffff || s.nextSeq() != 0 {
+		t.Fatal("Sequence number does not wrap around 24 bits")
+	}
+	s.Status = 2
+	s.AckChan = make(chan ackUpdate, 1)
+	(&GeneralDataPacket{SeqNumber: 0}).Handle(s)
+	if u := <-s.AckChan; u.nack || len(u.seqs) != 1 || u.seqs[0] != 0 || s.lastSeq != 0 {
+		t.Fatal("First received sequence number is not 0")
+	}
+}
+
+func TestSessionGoroutines(t *testing.T) {
+	const n = 20
+	before := SessionGoroutines()
+	sendChan := make(chan Packet, 64)
+	go func() {
+		for range sendChan {
+		}
+	}()
+	defer close(sendChan)
+	sessions := make([]*session, n)
+	for i := range sessions {
+		s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000 + i}, DefaultSessionConfig)
+		s.SendChan = sendChan
+		go s.sendAsync()
+		go s.work()
+		sessions[i] = s
+	}
+	for _, s := range sessions {
+		s.Close("test end")
+	}
+	deadline := time.Now().Add(time.Second)
+	for SessionGoroutines() > before {
+		if time.Now().After(deadline) {
+			t.Fatal("Session goroutines are leaked:", SessionGoroutines()-before)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestSendAsyncStopsOnClose(t *testing.T) {
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.SendChan = make(chan Packet, 64)
+	s.EncapsulatedChan = make(chan *EncapsulatedPacket)
+	done := make(chan struct{})
+	go func() {
+		s.sendAsync()
+		close(done)
+	}()
+	s.Close("test end")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sendAsync did not return after close")
+	}
+	select {
+	case s.EncapsulatedChan <- &EncapsulatedPacket{Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}:
+		t.Fatal("EncapsulatedChan is consumed after close")
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestAckFlush(t *testing.T) {
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.Status = 3
+	s.AckChan = make(chan ackUpdate, 1)
+	s.SendChan = make(chan Packet, 1)
+	(&GeneralDataPacket{SeqNumber: 0}).Handle(s)
+	u := <-s.AckChan
+	if u.nack || len(u.seqs) != 1 || u.seqs[0] != 0 {
+		t.Fatal("Received datagram is not queued to ACK")
+	}
+	s.handleAckUpdate(u)
+	if len(s.SendChan) != 0 {
+		t.Fatal("ACK is sent before update")
+	}
+	s.update()
+	pk := <-s.SendChan
+	if head, _ := pk.ReadByte(); head != 0xc0 {
+		t.Fatalf("Expected ACK, got 0x%02x", head)
+	}
+	if seqs := DecodeAck(pk.Buffer); len(seqs) != 1 || seqs[0] != 0 {
+		t.Fatal("Unexpected ACK sequence numbers:", seqs)
+	}
+}
+
+func TestRecoveryConcurrentAcks(t *testing.T) { // Run with -race
+	const n = 500
+	s := testSession(t)
+	go func() {
+		for {
+			select {
+			case <-s.SendChan:
+			case <-s.closed:
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			s.EncapsulatedChan <- &EncapsulatedPacket{Buffer: bytes.NewBuffer([]byte{0x8e, TextHead})}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for seq := uint32(0); seq < n; seq++ {
+			(&Ack{Seqs: []uint32{seq}}).Handle(s)
+			(&Nack{Seqs: []uint32{seq + 1}}).Handle(s)
+		}
+	}()
+	wg.Wait()
+	seqs := make([]uint32, n)
+	for i := range seqs {
+		seqs[i] = uint32(i)
+	}
+	done := make(chan struct{})
+	s.drainRequest <- done // Sends packets still queued on EncapsulatedChan
+	(&Ack{Seqs: seqs}).Handle(s)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Recovery queue is not empty after every datagrams are acknowledged")
+	}
+}
+
+func TestSendBudget(t *testing.T) {
+	s := NewSession(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}, DefaultSessionConfig)
+	s.SendChan = make(chan Packet, 64)
+	s.EncapsulatedChan = make(chan *EncapsulatedPacket, 64)
+	s.updateTicker.Stop()
+	tick := make(chan time.Time)
+	s.updateTicker = &time.Ticker{C: tick} // Ticks are driven by the test
+	s.SetSendBudget(100)
+	go s.sendAsync()
+	defer s.Close("test end")
+	sync := func() { s.AckChan <- ackUpdate{} } // AckChan is unbuffered: returns after previous work is done
+
+	for i := 0; i < 5; i++ {
+		s.EncapsulatedChan <- &EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer(make([]byte, 60))}
+	}
+	for len(s.EncapsulatedChan) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	sync()
+	for i := 0; i < 5; i++ {
+		if i > 0 {
+			tick <- time.Now()
+			sync()
+		}
+		if n := len(s.SendChan); n != 1 {
+			t.Fatal("Tick", i, "sent", n, "datagrams over the budget")
+		}
+		readDatagram(t, s)
+	}
+	tick <- time.Now()
+	sync()
+	if len(s.SendChan) != 0 {
+		t.Fatal("Datagram is sent without deferred packets")
+	}
+
+	for i := 0; i < 2; i++ {
+		s.EncapsulatedChan <- &EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer(make([]byte, 60))}
+	}
+	for len(s.EncapsulatedChan) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s.AckChan <- ackUpdate{seqs: []uint32{0}} // Queue ACK of received datagram
+	readDatagram(t, s)
+	tick <- time.Now()
+	sync()
+	if pk := <-s.SendChan; pk.Buffer.Bytes()[0] != 0xc0 {
+		t.Fatalf("Expected ACK, got 0x%02x", pk.Buffer.Bytes()[0])
+	}
+	readDatagram(t, s) // Deferred datagram is sent on the same tick
+}
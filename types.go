@@ -1,8 +1,13 @@
 package highmc
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"log"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/minero/minero/proto/nbt"
 )
@@ -21,6 +26,11 @@ func (b Block) Item() Item {
 	}
 }
 
+// IsSolid returns whether entities can't pass through the block.
+func (b Block) IsSolid() bool {
+	return b.Properties().Solid
+}
+
 // ChunkPos is a type for identifying chunks by x-z coordinate.
 type ChunkPos struct {
 	X, Z int32
@@ -40,12 +50,16 @@ type ChunkDelivery struct {
 	Chunk *Chunk
 }
 
+// WorldHeight is a height of MCPE levels. Valid block Y coordinates are 0~WorldHeight-1.
+const WorldHeight = 128
+
 // Chunk contains block data for each MCPE level chunks.
-// Each chunk holds 16*16*128 blocks, and consumes at least 83208 bytes of memory.
+// Each chunk holds 16*16*WorldHeight blocks, and consumes at least 83208 bytes of memory.
+// Blocks above WorldHeight are treated as air: reads return zero values, and writes are ignored.
 //
 // A zero value for Chunk is a valid value.
 type Chunk struct {
-	BlockData    [16 * 16 * 128]byte
+	BlockData    [16 * 16 * WorldHeight]byte
 	MetaData     [16 * 16 * 64]byte // Nibbles
 	LightData    [16 * 16 * 64]byte // Nibbles
 	SkyLightData [16 * 16 * 64]byte // Nibbles
@@ -53,7 +67,12 @@ type Chunk struct {
 	BiomeData    [16 * 16 * 4]byte // Uints
 
 	Position ChunkPos
-	Refs     uint64
+	Refs     uint64 // Count of players viewing the chunk, atomic. Use Level.RefChunk/UnrefChunk
+
+	dirty    bool           // Modified after load
+	accessed uint64         // Level access clock on last access, atomic
+	released time.Time      // Time when Refs became 0, or the chunk was loaded
+	payload  unsafe.Pointer // *[]byte, cached FullChunkData. nil if modified after last call, atomic
 }
 
 // FallbackChunk is a chunk to be returned if level provider fails to load chunk from file.
@@ -67,16 +86,30 @@ func (c *Chunk) CopyFrom(chunk Chunk) {
 	copy(c.SkyLightData[:], chunk.SkyLightData[:])
 	copy(c.HeightMap[:], chunk.HeightMap[:])
 	copy(c.BiomeData[:], chunk.BiomeData[:])
+	c.invalidate()
+}
+
+// invalidate drops cached FullChunkData payload. Setters should call this after modifying chunk data.
+func (c *Chunk) invalidate() {
+	atomic.StorePointer(&c.payload, nil)
 }
 
 // GetBlock returns block ID at given coordinates.
 func (c *Chunk) GetBlock(x, y, z byte) byte {
+	if y >= WorldHeight {
+		return 0
+	}
 	return c.BlockData[uint16(y)<<8|uint16(z)<<4|uint16(x)]
 }
 
 // SetBlock sets block ID at given coordinates.
 func (c *Chunk) SetBlock(x, y, z, id byte) {
+	if y >= WorldHeight {
+		return
+	}
 	c.BlockData[uint16(y)<<8|uint16(z)<<4|uint16(x)] = id
+	c.dirty = true
+	c.invalidate()
 	if id != 0 && y > c.GetHeightMap(x, z) {
 		c.SetHeightMap(x, z, y)
 	}
@@ -87,55 +120,74 @@ func (c *Chunk) SetBlock(x, y, z, id byte) {
 
 // GetBlockMeta returns block meta at given coordinates.
 func (c *Chunk) GetBlockMeta(x, y, z byte) byte {
-	if x&1 == 0 {
-		return c.MetaData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] & 0x0f
+	if y >= WorldHeight {
+		return 0
 	}
-	return c.MetaData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] >> 4
+	return nibbleGet(c.MetaData[:], x, y, z)
 }
 
 // SetBlockMeta sets block meta at given coordinates.
 func (c *Chunk) SetBlockMeta(x, y, z, id byte) {
-	b := c.MetaData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1]
-	if x&1 == 0 {
-		c.MetaData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] = (b & 0xf0) | (id & 0x0f)
-	} else {
-		c.MetaData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] = (id&0xf)<<4 | (b & 0x0f)
+	if y >= WorldHeight {
+		return
 	}
+	nibbleSet(c.MetaData[:], x, y, z, id)
+	c.dirty = true
+	c.invalidate()
 }
 
 // GetBlockLight returns block light level at given coordinates.
 func (c *Chunk) GetBlockLight(x, y, z byte) byte {
-	if x&1 == 0 {
-		return c.LightData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] & 0x0f
+	if y >= WorldHeight {
+		return 0
 	}
-	return c.LightData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] >> 4
+	return nibbleGet(c.LightData[:], x, y, z)
 }
 
 // SetBlockLight sets block light level at given coordinates.
 func (c *Chunk) SetBlockLight(x, y, z, id byte) {
-	b := c.LightData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1]
-	if x&1 == 0 {
-		c.LightData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] = (b & 0xf0) | (id & 0x0f)
-	} else {
-		c.LightData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] = (id&0xf)<<4 | (b & 0x0f)
+	if y >= WorldHeight {
+		return
 	}
+	nibbleSet(c.LightData[:], x, y, z, id)
+	c.invalidate()
 }
 
 // GetBlockSkyLight returns sky light level at given coordinates.
+// Above WorldHeight is open sky, so it returns full sky light.
 func (c *Chunk) GetBlockSkyLight(x, y, z byte) byte {
-	if x&1 == 0 {
-		return c.SkyLightData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] & 0x0f
+	if y >= WorldHeight {
+		return 15
 	}
-	return c.SkyLightData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] >> 4
+	return nibbleGet(c.SkyLightData[:], x, y, z)
 }
 
 // SetBlockSkyLight sets sky light level at given coordinates.
 func (c *Chunk) SetBlockSkyLight(x, y, z, id byte) {
-	b := c.SkyLightData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1]
+	if y >= WorldHeight {
+		return
+	}
+	nibbleSet(c.SkyLightData[:], x, y, z, id)
+	c.invalidate()
+}
+
+// nibbleGet reads 4-bit value at given coordinates from nibble array.
+// Even x is stored on low nibble, odd x on high nibble.
+func nibbleGet(arr []byte, x, y, z byte) byte {
 	if x&1 == 0 {
-		c.SkyLightData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] = (b & 0xf0) | (id & 0x0f)
+		return arr[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] & 0x0f
+	}
+	return arr[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] >> 4
+}
+
+// nibbleSet writes 4-bit value at given coordinates to nibble array, leaving the other nibble untouched.
+func nibbleSet(arr []byte, x, y, z, v byte) {
+	offset := uint16(y)<<7 | uint16(z)<<3 | uint16(x)>>1
+	b := arr[offset]
+	if x&1 == 0 {
+		arr[offset] = (b & 0xf0) | (v & 0x0f)
 	} else {
-		c.SkyLightData[uint16(y)<<7|uint16(z)<<3|uint16(x)>>1] = (id&0xf)<<4 | (b & 0x0f)
+		arr[offset] = (v&0xf)<<4 | (b & 0x0f)
 	}
 }
 
@@ -147,6 +199,7 @@ func (c *Chunk) GetHeightMap(x, z byte) byte {
 // SetHeightMap saves highest block height on given X-Z coordinates.
 func (c *Chunk) SetHeightMap(x, z, h byte) {
 	c.HeightMap[uint16(z)<<4|uint16(x)] = h
+	c.invalidate()
 }
 
 // GetBiomeID returns biome ID on given X-Z coordinates.
@@ -157,6 +210,7 @@ func (c *Chunk) GetBiomeID(x, z byte) byte {
 // SetBiomeID sets biome ID on given X-Z coordinates.
 func (c *Chunk) SetBiomeID(x, z, id byte) {
 	c.BiomeData[uint16(z)<<6|uint16(x)<<2] = id
+	c.invalidate()
 }
 
 // GetBiomeColor returns biome color on given X-Z coordinates.
@@ -169,6 +223,7 @@ func (c *Chunk) GetBiomeColor(x, z byte) (r, g, b byte) {
 func (c *Chunk) SetBiomeColor(x, z, r, g, b byte) {
 	offset := uint16(z)<<6 | uint16(x)<<2
 	c.BiomeData[offset+1], c.BiomeData[offset+2], c.BiomeData[offset+3] = r, g, b
+	c.invalidate()
 }
 
 // PopulateHeight populates chunk's block height map.
@@ -181,7 +236,7 @@ func (c *Chunk) PopulateHeight() {
 }
 
 func (c *Chunk) getHeight(x, z byte) {
-	for y := byte(127); y > 0; y-- {
+	for y := byte(WorldHeight - 1); y > 0; y-- {
 		if c.GetBlock(x, y, z) != 0 {
 			c.SetHeightMap(x, z, y)
 			return
@@ -189,9 +244,39 @@ func (c *Chunk) getHeight(x, z byte) {
 	}
 }
 
+// Column is a summary of a single X-Z block column, returned by Chunk.ColumnInfo.
+type Column struct {
+	TopSolid   byte // Y of the highest solid block, 0 if there's none
+	SkyExposed bool // True if there's no block above TopSolid
+	Surface    byte // Block ID on TopSolid
+}
+
+// ColumnInfo scans block column on given X-Z coordinates once.
+// Use this when finding spawnable surfaces or sky light levels, instead of scanning the column again.
+func (c *Chunk) ColumnInfo(x, z byte) Column {
+	col := Column{SkyExposed: true}
+	for y := WorldHeight - 1; y >= 0; y-- {
+		id := c.GetBlock(x, byte(y), z)
+		if id == 0 {
+			continue
+		}
+		if (Block{ID: id}).IsSolid() {
+			col.TopSolid, col.Surface = byte(y), id
+			return col
+		}
+		col.SkyExposed = false
+	}
+	return col
+}
+
 // FullChunkData returns full chunk payload for FullChunkDataPacket. Order is layered.
+// The payload is cached until the chunk is modified, so callers should not modify returned slice.
 func (c *Chunk) FullChunkData() []byte {
-	buf := Pool.NewBuffer(c.BlockData[:]) // Block ID
+	if p := atomic.LoadPointer(&c.payload); p != nil {
+		return *(*[]byte)(p)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, fullChunkDataSize))
+	buf.Write(c.BlockData[:]) // Block ID
 	Write(buf, c.MetaData[:])
 	Write(buf, c.SkyLightData[:])
 	Write(buf, c.LightData[:])
@@ -199,9 +284,13 @@ func (c *Chunk) FullChunkData() []byte {
 	Write(buf, c.BiomeData[:])
 	Write(buf, []byte{0, 0, 0, 0}) // Extra data: NBT length 0
 	// No tile entity NBT fields
-	return buf.Bytes()
+	payload := buf.Bytes()
+	atomic.StorePointer(&c.payload, unsafe.Pointer(&payload))
+	return payload
 }
 
+const fullChunkDataSize = 16*16*WorldHeight + 16*16*64*3 + 16*16 + 16*16*4 + 4
+
 // ID represents ID for Minecraft blocks/items.
 type ID uint16
 
@@ -593,19 +682,19 @@ const (
 	_                     // 367
 	_                     // 368
 	_                     // 369
-	_                     // 370
+	GhastTear             // 370
 	GoldNugget            // 371
-	_                     // 372
-	_                     // 373
-	_                     // 374
-	_                     // 375
-	_                     // 376
-	_                     // 377
-	_                     // 378
+	NetherWart            // 372
+	Potion                // 373
+	GlassBottle           // 374
+	SpiderEye             // 375
+	FermentedSpiderEye    // 376
+	BlazePowder           // 377
+	MagmaCream            // 378
 	_                     // 379
 	_                     // 380
 	_                     // 381
-	_                     // 382
+	GlisteringMelon       // 382
 	SpawnEgg              // 383
 	_                     // 384
 	_                     // 385
@@ -619,7 +708,7 @@ const (
 	BakedPotato           // 393
 	_                     // 394
 	_                     // 395
-	_                     // 396
+	GoldenCarrot          // 396
 	_                     // 397
 	_                     // 398
 	_                     // 399
@@ -997,13 +1086,23 @@ var idMap = map[string]ID{
 	"Steak":              Steak,              // 364
 	"RawChicken":         RawChicken,         // 365
 	"CookedChicken":      CookedChicken,      // 366
+	"GhastTear":          GhastTear,          // 370
 	"GoldNugget":         GoldNugget,         // 371
+	"NetherWart":         NetherWart,         // 372
+	"Potion":             Potion,             // 373
+	"GlassBottle":        GlassBottle,        // 374
+	"SpiderEye":          SpiderEye,          // 375
+	"FermentedSpiderEye": FermentedSpiderEye, // 376
+	"BlazePowder":        BlazePowder,        // 377
+	"MagmaCream":         MagmaCream,         // 378
+	"GlisteringMelon":    GlisteringMelon,    // 382
 	"SpawnEgg":           SpawnEgg,           // 383
 	"Emerald":            Emerald,            // 388
 	"FlowerPot":          FlowerPot,          // 390
 	"Carrot":             Carrot,             // 391
 	"Potato":             Potato,             // 392
 	"BakedPotato":        BakedPotato,        // 393
+	"GoldenCarrot":       GoldenCarrot,       // 396
 	"PumpkinPie":         PumpkinPie,         // 400
 	"NetherBrick":        NetherBrick,        // 405
 	"Quartz":             Quartz,             // 406
@@ -1324,13 +1423,23 @@ var nameMap = map[ID]string{
 	Steak:              "Steak",              // 364
 	RawChicken:         "RawChicken",         // 365
 	CookedChicken:      "CookedChicken",      // 366
+	GhastTear:          "GhastTear",          // 370
 	GoldNugget:         "GoldNugget",         // 371
+	NetherWart:         "NetherWart",         // 372
+	Potion:             "Potion",             // 373
+	GlassBottle:        "GlassBottle",        // 374
+	SpiderEye:          "SpiderEye",          // 375
+	FermentedSpiderEye: "FermentedSpiderEye", // 376
+	BlazePowder:        "BlazePowder",        // 377
+	MagmaCream:         "MagmaCream",         // 378
+	GlisteringMelon:    "GlisteringMelon",    // 382
 	SpawnEgg:           "SpawnEgg",           // 383
 	Emerald:            "Emerald",            // 388
 	FlowerPot:          "FlowerPot",          // 390
 	Carrot:             "Carrot",             // 391
 	Potato:             "Potato",             // 392
 	BakedPotato:        "BakedPotato",        // 393
+	GoldenCarrot:       "GoldenCarrot",       // 396
 	PumpkinPie:         "PumpkinPie",         // 400
 	NetherBrick:        "NetherBrick",        // 405
 	Quartz:             "Quartz",             // 406
@@ -1344,6 +1453,175 @@ var updateMap = map[byte]struct{}{
 	Torch.Block(): {},
 }
 
+// BlockProperties contains physical properties of a block type.
+type BlockProperties struct {
+	Hardness    float32 // Negative hardness means unbreakable blocks, and zero hardness can be broken instantly
+	Solid       bool    // True if entities can't pass through the block
+	Transparent bool    // True if light passes through the block
+	Light       byte    // Light level emitted by the block, 0~15
+}
+
+// defaultBlockProperties is used for block IDs not in blockPropsMap.
+var defaultBlockProperties = BlockProperties{Solid: true}
+
+// blockPropsMap is a table of block properties.
+// This is the single source for break times, collisions and lighting; blocks not in the map use defaultBlockProperties.
+var blockPropsMap = map[byte]BlockProperties{
+	Air.Block():                {Transparent: true},
+	Stone.Block():              {Hardness: 1.5, Solid: true},
+	Grass.Block():              {Hardness: 0.6, Solid: true},
+	Dirt.Block():               {Hardness: 0.5, Solid: true},
+	Cobblestone.Block():        {Hardness: 2, Solid: true},
+	Plank.Block():              {Hardness: 2, Solid: true},
+	Sapling.Block():            {Transparent: true},
+	Bedrock.Block():            {Hardness: -1, Solid: true},
+	Water.Block():              {Hardness: -1, Transparent: true},
+	StillWater.Block():         {Hardness: -1, Transparent: true},
+	Lava.Block():               {Hardness: -1, Transparent: true, Light: 15},
+	StillLava.Block():          {Hardness: -1, Transparent: true, Light: 15},
+	Sand.Block():               {Hardness: 0.5, Solid: true},
+	Gravel.Block():             {Hardness: 0.6, Solid: true},
+	GoldOre.Block():            {Hardness: 3, Solid: true},
+	IronOre.Block():            {Hardness: 3, Solid: true},
+	CoalOre.Block():            {Hardness: 3, Solid: true},
+	Log.Block():                {Hardness: 2, Solid: true},
+	Leaves.Block():             {Hardness: 0.2, Solid: true, Transparent: true},
+	Sponge.Block():             {Hardness: 0.6, Solid: true},
+	Glass.Block():              {Hardness: 0.3, Solid: true, Transparent: true},
+	LapisOre.Block():           {Hardness: 3, Solid: true},
+	LapisBlock.Block():         {Hardness: 3, Solid: true},
+	Sandstone.Block():          {Hardness: 0.8, Solid: true},
+	BedBlock.Block():           {Hardness: 0.2, Solid: true, Transparent: true},
+	Cobweb.Block():             {Hardness: 4, Transparent: true},
+	TallGrass.Block():          {Transparent: true},
+	Bush.Block():               {Transparent: true},
+	Wool.Block():               {Hardness: 0.8, Solid: true},
+	Dandelion.Block():          {Transparent: true},
+	Poppy.Block():              {Transparent: true},
+	BrownMushroom.Block():      {Transparent: true, Light: 1},
+	RedMushroom.Block():        {Transparent: true},
+	GoldBlock.Block():          {Hardness: 3, Solid: true},
+	IronBlock.Block():          {Hardness: 5, Solid: true},
+	DoubleSlab.Block():         {Hardness: 2, Solid: true},
+	Slab.Block():               {Hardness: 2, Solid: true, Transparent: true},
+	Bricks.Block():             {Hardness: 2, Solid: true},
+	Tnt.Block():                {Solid: true},
+	Bookshelf.Block():          {Hardness: 1.5, Solid: true},
+	MossStone.Block():          {Hardness: 2, Solid: true},
+	Obsidian.Block():           {Hardness: 50, Solid: true},
+	Torch.Block():              {Transparent: true, Light: 14},
+	Fire.Block():               {Transparent: true, Light: 15},
+	MonsterSpawner.Block():     {Hardness: 5, Solid: true, Transparent: true},
+	WoodStairs.Block():         {Hardness: 2, Solid: true, Transparent: true},
+	Chest.Block():              {Hardness: 2.5, Solid: true, Transparent: true},
+	DiamondOre.Block():         {Hardness: 3, Solid: true},
+	DiamondBlock.Block():       {Hardness: 5, Solid: true},
+	CraftingTable.Block():      {Hardness: 2.5, Solid: true},
+	WheatBlock.Block():         {Transparent: true},
+	Farmland.Block():           {Hardness: 0.6, Solid: true, Transparent: true},
+	Furnace.Block():            {Hardness: 3.5, Solid: true},
+	BurningFurnace.Block():     {Hardness: 3.5, Solid: true, Light: 13},
+	SignPost.Block():           {Hardness: 1, Transparent: true},
+	DoorBlock.Block():          {Hardness: 3, Solid: true, Transparent: true},
+	Ladder.Block():             {Hardness: 0.4, Transparent: true},
+	CobbleStairs.Block():       {Hardness: 2, Solid: true, Transparent: true},
+	WallSign.Block():           {Hardness: 1, Transparent: true},
+	IronDoorBlock.Block():      {Hardness: 5, Solid: true, Transparent: true},
+	RedstoneOre.Block():        {Hardness: 3, Solid: true},
+	GlowingRedstoneOre.Block(): {Hardness: 3, Solid: true, Light: 9},
+	Snow.Block():               {Hardness: 0.1, Transparent: true},
+	Ice.Block():                {Hardness: 0.5, Solid: true, Transparent: true},
+	SnowBlock.Block():          {Hardness: 0.2, Solid: true},
+	Cactus.Block():             {Hardness: 0.4, Solid: true, Transparent: true},
+	ClayBlock.Block():          {Hardness: 0.6, Solid: true},
+	Reeds.Block():              {Transparent: true},
+	Fence.Block():              {Hardness: 2, Solid: true, Transparent: true},
+	Pumpkin.Block():            {Hardness: 1, Solid: true},
+	Netherrack.Block():         {Hardness: 0.4, Solid: true},
+	SoulSand.Block():           {Hardness: 0.5, Solid: true},
+	Glowstone.Block():          {Hardness: 0.3, Solid: true, Transparent: true, Light: 15},
+	LitPumpkin.Block():         {Hardness: 1, Solid: true, Light: 15},
+	CakeBlock.Block():          {Hardness: 0.5, Solid: true, Transparent: true},
+	Trapdoor.Block():           {Hardness: 3, Solid: true, Transparent: true},
+	StoneBricks.Block():        {Hardness: 1.5, Solid: true},
+	IronBar.Block():            {Hardness: 5, Solid: true, Transparent: true},
+	GlassPane.Block():          {Hardness: 0.3, Solid: true, Transparent: true},
+	MelonBlock.Block():         {Hardness: 1, Solid: true},
+	PumpkinStem.Block():        {Transparent: true},
+	MelonStem.Block():          {Transparent: true},
+	Vine.Block():               {Hardness: 0.2, Transparent: true},
+	FenceGate.Block():          {Hardness: 2, Solid: true, Transparent: true},
+	BrickStairs.Block():        {Hardness: 2, Solid: true, Transparent: true},
+	StoneBrickStairs.Block():   {Hardness: 1.5, Solid: true, Transparent: true},
+	Mycelium.Block():           {Hardness: 0.6, Solid: true},
+	WaterLily.Block():          {Solid: true, Transparent: true},
+	NetherBricks.Block():       {Hardness: 2, Solid: true},
+	NetherBrickFence.Block():   {Hardness: 2, Solid: true, Transparent: true},
+	NetherBricksStairs.Block(): {Hardness: 2, Solid: true, Transparent: true},
+	EnchantingTable.Block():    {Hardness: 5, Solid: true, Transparent: true},
+	BrewingStand.Block():       {Hardness: 0.5, Solid: true, Transparent: true, Light: 1},
+	EndPortal.Block():          {Hardness: -1, Transparent: true, Light: 15},
+	EndStone.Block():           {Hardness: 3, Solid: true},
+	SandstoneStairs.Block():    {Hardness: 0.8, Solid: true, Transparent: true},
+	EmeraldOre.Block():         {Hardness: 3, Solid: true},
+	EmeraldBlock.Block():       {Hardness: 5, Solid: true},
+	SpruceWoodStairs.Block():   {Hardness: 2, Solid: true, Transparent: true},
+	BirchWoodStairs.Block():    {Hardness: 2, Solid: true, Transparent: true},
+	JungleWoodStairs.Block():   {Hardness: 2, Solid: true, Transparent: true},
+	CobbleWall.Block():         {Hardness: 2, Solid: true, Transparent: true},
+	FlowerPotBlock.Block():     {Solid: true, Transparent: true},
+	CarrotBlock.Block():        {Transparent: true},
+	PotatoBlock.Block():        {Transparent: true},
+	Anvil.Block():              {Hardness: 5, Solid: true, Transparent: true},
+	TrappedChest.Block():       {Hardness: 2.5, Solid: true, Transparent: true},
+	RedstoneBlock.Block():      {Hardness: 5, Solid: true},
+	QuartzBlock.Block():        {Hardness: 0.8, Solid: true},
+	QuartzStairs.Block():       {Hardness: 0.8, Solid: true, Transparent: true},
+	DoubleWoodSlab.Block():     {Hardness: 2, Solid: true},
+	WoodSlab.Block():           {Hardness: 2, Solid: true, Transparent: true},
+	StainedClay.Block():        {Hardness: 1.25, Solid: true},
+	AcaciaWoodStairs.Block():   {Hardness: 2, Solid: true, Transparent: true},
+	DarkOakWoodStairs.Block():  {Hardness: 2, Solid: true, Transparent: true},
+	IronTrapdoor.Block():       {Hardness: 5, Solid: true, Transparent: true},
+	HayBale.Block():            {Hardness: 0.5, Solid: true},
+	Carpet.Block():             {Hardness: 0.1, Solid: true, Transparent: true},
+	HardenedClay.Block():       {Hardness: 1.25, Solid: true},
+	CoalBlock.Block():          {Hardness: 5, Solid: true},
+	PackedIce.Block():          {Hardness: 0.5, Solid: true},
+	DoublePlant.Block():        {Transparent: true},
+	FenceGateSpruce.Block():    {Hardness: 2, Solid: true, Transparent: true},
+	FenceGateBirch.Block():     {Hardness: 2, Solid: true, Transparent: true},
+	FenceGateJungle.Block():    {Hardness: 2, Solid: true, Transparent: true},
+	FenceGateDarkOak.Block():   {Hardness: 2, Solid: true, Transparent: true},
+	FenceGateAcacia.Block():    {Hardness: 2, Solid: true, Transparent: true},
+	GrassPath.Block():          {Hardness: 0.6, Solid: true, Transparent: true},
+	Podzol.Block():             {Hardness: 0.5, Solid: true},
+	BeetrootBlock.Block():      {Transparent: true},
+	Stonecutter.Block():        {Hardness: 3.5, Solid: true},
+	GlowingObsidian.Block():    {Hardness: 10, Solid: true, Light: 12},
+}
+
+// Properties returns physical properties of the block.
+func (b Block) Properties() BlockProperties {
+	if props, ok := blockPropsMap[b.ID]; ok {
+		return props
+	}
+	return defaultBlockProperties
+}
+
+// BreakTime returns minimum time to break the block in survival mode, assuming the fastest tool.
+// It returns false if the block is unbreakable.
+func BreakTime(id byte) (time.Duration, bool) {
+	hardness := Block{ID: id}.Properties().Hardness
+	if hardness < 0 {
+		return 0, false
+	}
+	return time.Duration(float64(hardness) * 1.5 / maxToolSpeed * float64(time.Second)), true
+}
+
+// maxToolSpeed is a break speed multiplier of the fastest tool.
+const maxToolSpeed = 12
+
 // StringID returns item ID with given name.
 // If there's no such item, returns -1(65535).
 func StringID(name string) ID {
@@ -1353,6 +1631,28 @@ func StringID(name string) ID {
 	return 65535
 }
 
+func init() {
+	if err := checkNameMaps(); err != nil {
+		panic(err)
+	}
+}
+
+// checkNameMaps checks every canonical name in nameMap resolves back to the same ID,
+// and every ID in idMap has a canonical name.
+func checkNameMaps() error {
+	for id, name := range nameMap {
+		if got, ok := idMap[name]; !ok || got != id {
+			return fmt.Errorf("item name %s does not resolve to ID %d", name, id)
+		}
+	}
+	for name, id := range idMap {
+		if _, ok := nameMap[id]; !ok {
+			return fmt.Errorf("item %s(ID %d) has no canonical name", name, id)
+		}
+	}
+	return nil
+}
+
 // CreativeItems is a list of inventory items for creative mode players.
 var CreativeItems = []Item{
 	{ID: 4, Meta: 0},
@@ -1849,10 +2149,17 @@ func (i *Item) Read(buf io.Reader) {
 	i.Meta = ReadShort(buf)
 	length := uint32(ReadLShort(buf))
 	if length > 0 {
-		b, _ := Read(buf, int(length))
-		compound := Pool.NewBuffer(b)
-		i.Compound = new(nbt.Compound)
-		i.Compound.ReadFrom(compound)
+		b, err := Read(buf, int(length))
+		if err != nil {
+			log.Println("Error while reading item NBT, ignoring tag:", err)
+			return
+		}
+		compound := new(nbt.Compound)
+		if _, err := compound.ReadFrom(Pool.NewBuffer(b)); err != nil {
+			log.Println("Error while parsing item NBT, ignoring tag:", err)
+			return
+		}
+		i.Compound = compound
 	}
 }
 
@@ -1866,8 +2173,12 @@ func (i Item) Write() []byte {
 	WriteByte(buf, i.Amount)
 	WriteShort(buf, i.Meta)
 	compound := Pool.NewBuffer(nil)
-	i.Compound = new(nbt.Compound)
-	i.Compound.WriteTo(compound)
+	if i.Compound != nil {
+		if _, err := i.Compound.WriteTo(compound); err != nil {
+			log.Println("Error while writing item NBT, omitting tag:", err)
+			compound.Reset()
+		}
+	}
 	WriteLShort(buf, uint16(compound.Len()))
 	buf.Write(compound.Bytes())
 	return buf.Bytes()
@@ -1886,3 +2197,194 @@ func (i Item) Block() Block {
 func (i Item) IsBlock() bool {
 	return i.ID < 256
 }
+
+// MaxEnchantLevel is the maximum enchantment level AddEnchant accepts.
+const MaxEnchantLevel = 5
+
+// Enchant is an enchantment entry of item NBT.
+type Enchant struct {
+	ID    uint16
+	Level uint16
+}
+
+// enchList returns "ench" list tag of the item compound, or nil if there is no such tag.
+func (i Item) enchList() *nbt.List {
+	if i.Compound == nil {
+		return nil
+	}
+	list, _ := i.Compound.Value["ench"].(*nbt.List)
+	return list
+}
+
+// Enchants returns enchantments of the item.
+func (i Item) Enchants() []Enchant {
+	list := i.enchList()
+	if list == nil {
+		return nil
+	}
+	enchants := make([]Enchant, 0, len(list.Value))
+	for _, tag := range list.Value {
+		c, ok := tag.(*nbt.Compound)
+		if !ok {
+			continue
+		}
+		id, ok1 := c.Value["id"].(*nbt.Short)
+		lvl, ok2 := c.Value["lvl"].(*nbt.Short)
+		if !ok1 || !ok2 {
+			continue
+		}
+		enchants = append(enchants, Enchant{ID: uint16(id.Int16), Level: uint16(lvl.Int16)})
+	}
+	return enchants
+}
+
+// AddEnchant adds enchantment to the item, creating item compound if the item has none.
+// If the item already has the enchantment, its level is replaced.
+// Level is clamped to 1~MaxEnchantLevel.
+func (i *Item) AddEnchant(id, level uint16) {
+	if level < 1 {
+		level = 1
+	} else if level > MaxEnchantLevel {
+		level = MaxEnchantLevel
+	}
+	if i.Compound == nil {
+		i.Compound = &nbt.Compound{Value: make(map[string]nbt.Tag)}
+	} else if i.Compound.Value == nil {
+		i.Compound.Value = make(map[string]nbt.Tag)
+	}
+	list := i.enchList()
+	if list == nil {
+		list = &nbt.List{Typ: nbt.TagCompound}
+		i.Compound.Value["ench"] = list
+	}
+	for _, tag := range list.Value {
+		if c, ok := tag.(*nbt.Compound); ok {
+			if eid, ok := c.Value["id"].(*nbt.Short); ok && uint16(eid.Int16) == id {
+				c.Value["lvl"] = &nbt.Short{Int16: int16(level)}
+				return
+			}
+		}
+	}
+	list.Value = append(list.Value, &nbt.Compound{Value: map[string]nbt.Tag{
+		"id":  &nbt.Short{Int16: int16(id)},
+		"lvl": &nbt.Short{Int16: int16(level)},
+	}})
+}
+
+// RemoveEnchant removes enchantment from the item.
+// If no enchantments are left, "ench" tag is removed.
+func (i *Item) RemoveEnchant(id uint16) {
+	list := i.enchList()
+	if list == nil {
+		return
+	}
+	tags := list.Value[:0]
+	for _, tag := range list.Value {
+		if c, ok := tag.(*nbt.Compound); ok {
+			if eid, ok := c.Value["id"].(*nbt.Short); ok && uint16(eid.Int16) == id {
+				continue
+			}
+		}
+		tags = append(tags, tag)
+	}
+	list.Value = tags
+	if len(list.Value) == 0 {
+		delete(i.Compound.Value, "ench")
+	}
+}
+
+// display returns "display" compound tag of the item compound.
+// If create is true, it creates the item compound and display tag if the item has none. Otherwise it returns nil.
+func (i *Item) display(create bool) *nbt.Compound {
+	if i.Compound == nil {
+		if !create {
+			return nil
+		}
+		i.Compound = &nbt.Compound{Value: make(map[string]nbt.Tag)}
+	} else if i.Compound.Value == nil {
+		if !create {
+			return nil
+		}
+		i.Compound.Value = make(map[string]nbt.Tag)
+	}
+	if display, ok := i.Compound.Value["display"].(*nbt.Compound); ok {
+		if display.Value == nil {
+			display.Value = make(map[string]nbt.Tag)
+		}
+		return display
+	}
+	if !create {
+		return nil
+	}
+	display := &nbt.Compound{Name: "display", Value: make(map[string]nbt.Tag)}
+	i.Compound.Value["display"] = display
+	return display
+}
+
+// pruneDisplay removes "display" tag if it has no values left.
+func (i *Item) pruneDisplay(display *nbt.Compound) {
+	if len(display.Value) == 0 {
+		delete(i.Compound.Value, "display")
+	}
+}
+
+// CustomName returns custom name of the item, or empty string if the item is not named.
+func (i Item) CustomName() string {
+	display := i.display(false)
+	if display == nil {
+		return ""
+	}
+	if name, ok := display.Value["Name"].(*nbt.String); ok {
+		return name.Value
+	}
+	return ""
+}
+
+// SetCustomName sets custom name of the item.
+// Empty name removes custom name tag.
+func (i *Item) SetCustomName(name string) {
+	if name == "" {
+		if display := i.display(false); display != nil {
+			delete(display.Value, "Name")
+			i.pruneDisplay(display)
+		}
+		return
+	}
+	i.display(true).Value["Name"] = &nbt.String{Value: name}
+}
+
+// Lore returns lore lines of the item.
+func (i Item) Lore() []string {
+	display := i.display(false)
+	if display == nil {
+		return nil
+	}
+	list, ok := display.Value["Lore"].(*nbt.List)
+	if !ok {
+		return nil
+	}
+	lore := make([]string, 0, len(list.Value))
+	for _, tag := range list.Value {
+		if line, ok := tag.(*nbt.String); ok {
+			lore = append(lore, line.Value)
+		}
+	}
+	return lore
+}
+
+// SetLore sets lore lines of the item.
+// Empty lore removes lore tag.
+func (i *Item) SetLore(lore []string) {
+	if len(lore) == 0 {
+		if display := i.display(false); display != nil {
+			delete(display.Value, "Lore")
+			i.pruneDisplay(display)
+		}
+		return
+	}
+	list := &nbt.List{Typ: nbt.TagString, Value: make([]nbt.Tag, len(lore))}
+	for j, line := range lore {
+		list.Value[j] = &nbt.String{Value: line}
+	}
+	i.display(true).Value["Lore"] = list
+}
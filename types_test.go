@@ -0,0 +1,269 @@
+package highmc
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/minero/minero/proto/nbt"
+)
+
+func TestChunkNibbles(t *testing.T) {
+	c := new(Chunk)
+	for x := byte(0); x < 16; x++ {
+		for y := byte(0); y < WorldHeight; y += 7 {
+			for z := byte(0); z < 16; z++ {
+				v, o := (x+y+z)&0xf, x^1 // o is the other x parity, sharing the byte
+				c.SetBlockMeta(o, y, z, 0xa)
+				c.SetBlockLight(o, y, z, 0xb)
+				c.SetBlockSkyLight(o, y, z, 0xc)
+				c.SetBlockMeta(x, y, z, v)
+				c.SetBlockLight(x, y, z, v^3)
+				c.SetBlockSkyLight(x, y, z, v^5)
+				if c.GetBlockMeta(x, y, z) != v || c.GetBlockLight(x, y, z) != v^3 || c.GetBlockSkyLight(x, y, z) != v^5 {
+					t.Fatal("Unexpected nibble on", x, y, z)
+				}
+				if c.GetBlockMeta(o, y, z) != 0xa || c.GetBlockLight(o, y, z) != 0xb || c.GetBlockSkyLight(o, y, z) != 0xc {
+					t.Fatal("Adjacent nibble is disturbed on", o, y, z)
+				}
+			}
+		}
+	}
+}
+
+func TestNibbleArray(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	arr := make([]byte, 16384)
+	ref := make([]byte, 16384)
+	for n := 0; n < 4096; n++ {
+		x, y, z, v := byte(r.Intn(16)), byte(r.Intn(WorldHeight)), byte(r.Intn(16)), byte(r.Intn(16))
+		nibbleSet(arr, x, y, z, v)
+		i := int(y)<<7 | int(z)<<3 | int(x)>>1 // Layout of former per-field implementations
+		if x&1 == 0 {
+			ref[i] = ref[i]&0xf0 | v
+		} else {
+			ref[i] = v<<4 | ref[i]&0x0f
+		}
+		if !bytes.Equal(arr, ref) || nibbleGet(arr, x, y, z) != v {
+			t.Fatal("Nibble array differs from per-field layout on", x, y, z)
+		}
+	}
+}
+
+func TestColumnInfo(t *testing.T) {
+	c := new(Chunk)
+	c.SetBlock(1, 60, 1, byte(Grass))
+	c.SetBlock(1, 70, 1, byte(TallGrass)) // Non-solid overhang
+	if col := c.ColumnInfo(1, 1); col.TopSolid != 60 || col.SkyExposed || col.Surface != byte(Grass) {
+		t.Fatal("Unexpected column under non-solid overhang:", col)
+	}
+	c.SetBlock(1, 80, 1, byte(Stone)) // Solid overhang, with air gap below
+	if col := c.ColumnInfo(1, 1); col.TopSolid != 80 || !col.SkyExposed || col.Surface != byte(Stone) {
+		t.Fatal("Unexpected column under solid overhang:", col)
+	}
+	if col := c.ColumnInfo(2, 2); col.TopSolid != 0 || !col.SkyExposed || col.Surface != 0 {
+		t.Fatal("Unexpected empty column:", col)
+	}
+}
+
+func TestItemTruncatedNBT(t *testing.T) {
+	for _, tag := range [][]byte{
+		{0x0a, 0x00},                   // Compound without end tag
+		{0x0a, 0x00, 0x00, 0x03, 0x00}, // Int tag truncated in name
+	} {
+		for _, length := range []int{len(tag), 50} { // Declared length fits, and exceeds the payload
+			buf := new(bytes.Buffer)
+			WriteShort(buf, uint16(Stone))
+			WriteByte(buf, 3)
+			WriteShort(buf, 1)
+			WriteLShort(buf, uint16(length))
+			buf.Write(tag)
+			item := new(Item)
+			item.Read(buf)
+			if item.ID != Stone || item.Amount != 3 || item.Meta != 1 || item.Compound != nil {
+				t.Fatal("Unexpected item with truncated NBT:", item)
+			}
+		}
+	}
+}
+
+func TestItemNBTRoundTrip(t *testing.T) {
+	item := Item{ID: DiamondSword, Amount: 1, Compound: &nbt.Compound{Value: map[string]nbt.Tag{
+		"display": &nbt.Compound{Value: map[string]nbt.Tag{"Name": &nbt.String{Value: "Blade"}}},
+	}}}
+	read := new(Item)
+	read.Read(bytes.NewBuffer(item.Write()))
+	if read.Compound == nil {
+		t.Fatal("Item NBT is not written")
+	}
+	if display, ok := read.Compound.Value["display"].(*nbt.Compound); !ok || display.Value["Name"].(*nbt.String).Value != "Blade" {
+		t.Fatal("Item NBT is not preserved:", read.Compound)
+	}
+	if item.Compound.Value["display"] == nil {
+		t.Fatal("Item NBT is modified on write")
+	}
+
+	b := Item{ID: Stone, Amount: 1}.Write()
+	if !bytes.Equal(b[len(b)-2:], []byte{0, 0}) {
+		t.Fatal("Item without NBT is written with non-empty tag:", b)
+	}
+	read = new(Item)
+	read.Read(bytes.NewBuffer(b))
+	if read.Compound != nil {
+		t.Fatal("Item NBT is created on read")
+	}
+}
+
+func TestEnchants(t *testing.T) {
+	item := Item{ID: DiamondSword, Amount: 1}
+	item.AddEnchant(9, 3)
+	item.AddEnchant(17, 100) // Clamped to MaxEnchantLevel
+	item.AddEnchant(9, 0)    // Replaces level, clamped to 1
+	read := new(Item)
+	read.Read(bytes.NewBuffer(item.Write()))
+	list, ok := read.Compound.Value["ench"].(*nbt.List)
+	if !ok || list.Typ != nbt.TagCompound || len(list.Value) != 2 {
+		t.Fatal("Unexpected ench list:", read.Compound.Value["ench"])
+	}
+	if enchants := read.Enchants(); len(enchants) != 2 || enchants[0] != (Enchant{ID: 9, Level: 1}) || enchants[1] != (Enchant{ID: 17, Level: MaxEnchantLevel}) {
+		t.Fatal("Unexpected enchantments:", enchants)
+	}
+
+	read.RemoveEnchant(9)
+	removed := new(Item)
+	removed.Read(bytes.NewBuffer(read.Write()))
+	if enchants := removed.Enchants(); len(enchants) != 1 || enchants[0].ID != 17 {
+		t.Fatal("Unexpected enchantments after removal:", enchants)
+	}
+	removed.RemoveEnchant(17)
+	if _, ok := removed.Compound.Value["ench"]; ok || removed.Enchants() != nil {
+		t.Fatal("Empty ench list is left")
+	}
+}
+
+func TestItemDisplay(t *testing.T) {
+	item := Item{ID: DiamondSword, Amount: 1}
+	item.SetCustomName("Blade")
+	item.SetLore([]string{"first", "second"})
+	read := new(Item)
+	read.Read(bytes.NewBuffer(item.Write()))
+	display, ok := read.Compound.Value["display"].(*nbt.Compound)
+	if !ok {
+		t.Fatal("Display tag is not written")
+	}
+	if name, ok := display.Value["Name"].(*nbt.String); !ok || name.Value != "Blade" {
+		t.Fatal("Unexpected Name tag:", display.Value["Name"])
+	}
+	if lore, ok := display.Value["Lore"].(*nbt.List); !ok || lore.Typ != nbt.TagString || len(lore.Value) != 2 {
+		t.Fatal("Unexpected Lore tag:", display.Value["Lore"])
+	}
+	if lore := read.Lore(); read.CustomName() != "Blade" || len(lore) != 2 || lore[1] != "second" {
+		t.Fatal("Unexpected display:", read.CustomName(), lore)
+	}
+
+	read.SetCustomName("")
+	if _, ok := display.Value["Name"]; ok || read.CustomName() != "" {
+		t.Fatal("Name tag is not removed")
+	}
+	read.SetLore(nil)
+	if _, ok := read.Compound.Value["display"]; ok || read.Lore() != nil {
+		t.Fatal("Empty display tag is left")
+	}
+	plain := new(Item)
+	plain.SetCustomName("")
+	if plain.Compound != nil {
+		t.Fatal("Item compound is created on clearing name")
+	}
+}
+
+func TestNameRoundTrip(t *testing.T) {
+	for id, name := range nameMap {
+		if got := StringID(name); got != id {
+			t.Fatal("Name", name, "resolves to", got, "instead of", id)
+		}
+	}
+	idMap["test_unnamed_item"] = 0xfff0
+	defer delete(idMap, "test_unnamed_item")
+	if checkNameMaps() == nil {
+		t.Fatal("Item without canonical name is not detected")
+	}
+}
+
+func TestChunkDataCache(t *testing.T) {
+	c := new(Chunk)
+	for _, modify := range []func(){
+		func() { c.SetBlock(1, 2, 3, 4) },
+		func() { c.SetBlockMeta(1, 2, 3, 5) },
+		func() { c.SetBlockLight(1, 2, 3, 6) },
+		func() { c.SetBiomeColor(1, 3, 7, 8, 9) },
+	} {
+		cached := c.FullChunkData()
+		if again := c.FullChunkData(); &again[0] != &cached[0] || len(cached) != fullChunkDataSize {
+			t.Fatal("Full chunk data is not cached")
+		}
+		modify()
+		if bytes.Equal(c.FullChunkData(), cached) {
+			t.Fatal("Full chunk data is not invalidated after modification")
+		}
+	}
+}
+
+func BenchmarkFullChunkData(b *testing.B) {
+	c := new(Chunk)
+	b.Run("Cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.FullChunkData()
+		}
+	})
+	b.Run("Modified", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.SetBlock(0, 0, 0, byte(i))
+			c.FullChunkData()
+		}
+	})
+}
+
+func TestOutOfHeight(t *testing.T) {
+	c := new(Chunk)
+	c.SetBlock(1, 200, 1, byte(Stone))
+	c.SetBlockMeta(1, 200, 1, 2)
+	c.SetBlockLight(1, 200, 1, 3)
+	c.SetBlockSkyLight(1, 200, 1, 4)
+	if c.GetBlock(1, 200, 1) != 0 || c.GetBlockMeta(1, 200, 1) != 0 || c.GetBlockLight(1, 200, 1) != 0 || c.GetBlockSkyLight(1, 200, 1) != 15 {
+		t.Fatal("Out of height block is not air under the sky")
+	}
+	if c.GetHeightMap(1, 1) != 0 || !bytes.Equal(c.FullChunkData(), new(Chunk).FullChunkData()) {
+		t.Fatal("Out of height write modified the chunk")
+	}
+
+	lv := testLevel()
+	pos := BlockPos{X: 1, Y: 200, Z: 1}
+	lv.Set(pos, Block{ID: Stone.Block(), Meta: 2})
+	if block := lv.Get(pos); block.ID != 0 || block.Meta != 0 {
+		t.Fatal("Out of height block on level is not air:", block)
+	}
+}
+
+func TestBlockProperties(t *testing.T) {
+	for id, expected := range map[ID]BlockProperties{
+		Stone:     {Hardness: 1.5, Solid: true},
+		Air:       {Transparent: true},
+		Glass:     {Hardness: 0.3, Solid: true, Transparent: true},
+		Glowstone: {Hardness: 0.3, Solid: true, Transparent: true, Light: 15},
+	} {
+		if props := (Block{ID: id.Block()}).Properties(); props != expected {
+			t.Fatal("Unexpected properties of", id, ":", props)
+		}
+	}
+	if props := (Block{ID: 230}).Properties(); props != defaultBlockProperties {
+		t.Fatal("Unknown block does not have default properties:", props)
+	}
+	if _, ok := BreakTime(Bedrock.Block()); ok {
+		t.Fatal("Bedrock is breakable")
+	}
+	if d, ok := BreakTime(Stone.Block()); !ok || d <= 0 {
+		t.Fatal("Unexpected break time of stone:", d)
+	}
+}
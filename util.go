@@ -35,15 +35,24 @@ func Safe(panicFunc func()) error {
 	return err
 }
 
+// MaxDeflateSize is a maximum size of decompressed data on DecodeDeflate.
+const MaxDeflateSize = 1 << 21
+
 // DecodeDeflate returns decompressed data of given byte slice.
+// Returns error if the data is larger than MaxDeflateSize after decompression.
 func DecodeDeflate(b []byte) (*bytes.Buffer, error) {
 	r, err := zlib.NewReader(Pool.NewBuffer(b))
 	if err != nil {
 		return nil, err
 	}
+	defer r.Close()
 	output := Pool.NewBuffer(nil)
-	io.Copy(output, r)
-	r.Close()
+	if _, err := io.Copy(output, io.LimitReader(r, MaxDeflateSize+1)); err != nil {
+		return nil, err
+	}
+	if output.Len() > MaxDeflateSize {
+		return nil, fmt.Errorf("decompressed data exceeds %d bytes", MaxDeflateSize)
+	}
 	return output, nil
 }
 
@@ -114,3 +123,95 @@ type Vector3 struct {
 func (v Vector3) Distance(to Vector3) float32 {
 	return float32(math.Sqrt(float64((to.X-v.X)*(to.X-v.X) + (to.Y-v.Y)*(to.Y-v.Y) + (to.Z-v.Z)*(to.Z-v.Z))))
 }
+
+// Add returns the sum of two vectors.
+func (v Vector3) Add(w Vector3) Vector3 {
+	return Vector3{X: v.X + w.X, Y: v.Y + w.Y, Z: v.Z + w.Z}
+}
+
+// Subtract returns the vector minus given vector.
+func (v Vector3) Subtract(w Vector3) Vector3 {
+	return Vector3{X: v.X - w.X, Y: v.Y - w.Y, Z: v.Z - w.Z}
+}
+
+// Scale returns the vector multiplied by given scalar.
+func (v Vector3) Scale(f float32) Vector3 {
+	return Vector3{X: v.X * f, Y: v.Y * f, Z: v.Z * f}
+}
+
+// Length returns the length of the vector.
+func (v Vector3) Length() float32 {
+	return float32(math.Sqrt(float64(v.X*v.X + v.Y*v.Y + v.Z*v.Z)))
+}
+
+// Normalize returns the unit vector with same direction. Zero vector returns zero vector.
+func (v Vector3) Normalize() Vector3 {
+	l := v.Length()
+	if l == 0 {
+		return Vector3{}
+	}
+	return v.Scale(1 / l)
+}
+
+// Floor returns the position of block containing the vector.
+// Y is clamped to level height.
+func (v Vector3) Floor() BlockPos {
+	y := math.Floor(float64(v.Y))
+	if y < 0 {
+		y = 0
+	} else if y > WorldHeight-1 {
+		y = WorldHeight - 1
+	}
+	return BlockPos{
+		X: int32(math.Floor(float64(v.X))),
+		Y: byte(y),
+		Z: int32(math.Floor(float64(v.Z))),
+	}
+}
+
+// BlockPos is an alias of Floor.
+func (v Vector3) BlockPos() BlockPos {
+	return v.Floor()
+}
+
+// Side returns the vector moved by 1 on given side. Invalid side returns the vector itself.
+func (v Vector3) Side(face int) Vector3 {
+	switch face {
+	case SideDown:
+		v.Y--
+	case SideUp:
+		v.Y++
+	case SideNorth:
+		v.Z--
+	case SideSouth:
+		v.Z++
+	case SideWest:
+		v.X--
+	case SideEast:
+		v.X++
+	}
+	return v
+}
+
+// AABB is an axis-aligned bounding box, from Min to Max.
+type AABB struct {
+	Min, Max Vector3
+}
+
+// BlockAABB returns the bounding box of a full block on given position.
+func BlockAABB(pos BlockPos) AABB {
+	min := pos.Vector3()
+	return AABB{Min: min, Max: min.Add(Vector3{1, 1, 1})}
+}
+
+// Offset returns the box moved by given vector.
+func (bb AABB) Offset(v Vector3) AABB {
+	return AABB{Min: bb.Min.Add(v), Max: bb.Max.Add(v)}
+}
+
+// Intersects returns whether two boxes overlap. Boxes only touching each other's faces do not intersect.
+func (bb AABB) Intersects(o AABB) bool {
+	return bb.Min.X < o.Max.X && bb.Max.X > o.Min.X &&
+		bb.Min.Y < o.Max.Y && bb.Max.Y > o.Min.Y &&
+		bb.Min.Z < o.Max.Z && bb.Max.Z > o.Min.Z
+}
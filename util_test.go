@@ -0,0 +1,50 @@
+package highmc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestVector3(t *testing.T) {
+	v := Vector3{X: 1, Y: 2, Z: 3}
+	if v.Add(Vector3{X: 1, Y: 1, Z: 1}) != (Vector3{X: 2, Y: 3, Z: 4}) {
+		t.Fatal("Unexpected sum:", v.Add(Vector3{X: 1, Y: 1, Z: 1}))
+	}
+	if v.Subtract(v) != (Vector3{}) {
+		t.Fatal("Unexpected difference:", v.Subtract(v))
+	}
+	if v.Scale(-2) != (Vector3{X: -2, Y: -4, Z: -6}) {
+		t.Fatal("Unexpected scaled vector:", v.Scale(-2))
+	}
+	if l := (Vector3{X: 3, Y: 4}).Length(); l != 5 {
+		t.Fatal("Unexpected length:", l)
+	}
+	if l := (Vector3{X: 3, Y: 4, Z: 12}).Normalize().Length(); math.Abs(float64(l)-1) > 1e-6 {
+		t.Fatal("Normalized vector is not a unit vector:", l)
+	}
+	if n := (Vector3{}).Normalize(); n != (Vector3{}) || math.IsNaN(float64(n.X)) {
+		t.Fatal("Normalized zero vector is not zero:", n)
+	}
+	for vec, expected := range map[Vector3]BlockPos{
+		{X: -0.5, Y: 2.9, Z: 15.9}: {X: -1, Y: 2, Z: 15},
+		{X: 1, Y: -3, Z: -16}:      {X: 1, Y: 0, Z: -16},
+		{X: 0, Y: 200, Z: 0}:       {X: 0, Y: WorldHeight - 1, Z: 0},
+	} {
+		if got := vec.Floor(); got != expected {
+			t.Fatal("Unexpected floor of", vec, ":", got)
+		}
+	}
+	for side, expected := range map[int]Vector3{
+		SideDown:  {X: 1, Y: 1, Z: 3},
+		SideUp:    {X: 1, Y: 3, Z: 3},
+		SideNorth: {X: 1, Y: 2, Z: 2},
+		SideSouth: {X: 1, Y: 2, Z: 4},
+		SideWest:  {X: 0, Y: 2, Z: 3},
+		SideEast:  {X: 2, Y: 2, Z: 3},
+		99:        v,
+	} {
+		if got := v.Side(side); got != expected {
+			t.Fatal("Unexpected vector on side", side, ":", got)
+		}
+	}
+}